@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/devingen/api-core/model"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TextIndexName is the name of the text index that CreateTextIndex creates. A
+// collection can have only one text index.
+const TextIndexName = "text"
+
+var errNoTextField = errors.New("there isn't any text field to index")
+
+// CreateTextIndex creates the text index of the text fields that the full-text
+// searches of the collection use. The language is the default language of the index,
+// which determines the stop words and the stemming rules. The index is replaced if
+// it exists with other fields since a collection can have only one text index.
+func (s *Database) CreateTextIndex(ctx context.Context, databaseName, collectionName string, fields []model.Field, language string) (string, error) {
+	keys := model.TextIndexKeys(fields)
+	if len(keys) == 0 {
+		return "", errNoTextField
+	}
+
+	collection, err := s.ConnectToCollection(databaseName, collectionName)
+	if err != nil {
+		return "", err
+	}
+
+	indexOptions := options.Index().SetName(TextIndexName)
+	if language != "" {
+		indexOptions.SetDefaultLanguage(language)
+	}
+	index := mongo.IndexModel{Keys: keys, Options: indexOptions}
+	name, err := collection.Indexes().CreateOne(ctx, index)
+	if err == nil {
+		return name, nil
+	}
+
+	// IndexOptionsConflict or IndexKeySpecsConflict
+	var commandError mongo.CommandError
+	if !errors.As(err, &commandError) || (commandError.Code != 85 && commandError.Code != 86) {
+		return "", err
+	}
+	if _, err := collection.Indexes().DropOne(ctx, TextIndexName); err != nil {
+		return "", err
+	}
+	return collection.Indexes().CreateOne(ctx, index)
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	core "github.com/devingen/api-core"
+	"github.com/devingen/api-core/internal/match"
+	"github.com/devingen/api-core/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MaxLoaderDepth is the maximum depth of the nested references that Loader.Populate
+// accepts.
+var MaxLoaderDepth = 3
+
+// Loader loads the referenced documents with one $in query per collection instead of
+// one Get per reference, which avoids the N+1 queries of resolving the references of
+// a list. It caches the documents it loads, including the missing ones, so a Loader
+// must be created for each request to not return stale documents. It's safe for
+// concurrent use.
+type Loader struct {
+	storage      Storage
+	databaseName string
+	mutex        sync.Mutex
+	cache        map[loaderKey]model.DataModel
+}
+
+// loaderKey is the key of a document in the cache of Loader.
+type loaderKey struct {
+	database   string
+	collection string
+	id         primitive.ObjectID
+}
+
+// NewLoader creates a Loader of the storage. The references that don't have a
+// database are loaded from the database with the name.
+func NewLoader(storage Storage, databaseName string) *Loader {
+	return &Loader{
+		storage:      storage,
+		databaseName: databaseName,
+		cache:        map[loaderKey]model.DataModel{},
+	}
+}
+
+// Load returns the referenced documents in the order of the references. The documents
+// that don't exist are nil. The references that are not in the cache are loaded with
+// one query per collection.
+func (l *Loader) Load(ctx context.Context, refs []model.DBRef) ([]model.DataModel, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	keys := make([]loaderKey, len(refs))
+	added := make([]loaderKey, 0)
+	missing := map[loaderKey][]interface{}{}
+	for i, ref := range refs {
+		keys[i] = l.key(ref)
+		if _, isCached := l.cache[keys[i]]; isCached {
+			continue
+		}
+		// the documents that are not found are cached as nil
+		l.cache[keys[i]] = nil
+		added = append(added, keys[i])
+		collection := loaderKey{database: keys[i].database, collection: keys[i].collection}
+		missing[collection] = append(missing[collection], ref.ID)
+	}
+
+	collections := make([]loaderKey, 0, len(missing))
+	for collection := range missing {
+		collections = append(collections, collection)
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		if collections[i].database != collections[j].database {
+			return collections[i].database < collections[j].database
+		}
+		return collections[i].collection < collections[j].collection
+	})
+	for _, collection := range collections {
+		query := bson.M{"_id": bson.M{"$in": bson.A(missing[collection])}}
+		err := l.storage.Find(ctx, collection.database, collection.collection, query, FindOptions{}, func(cur *mongo.Cursor) error {
+			var doc model.DataModel
+			if err := cur.Decode(&doc); err != nil {
+				return err
+			}
+			if id, isObjectID := doc["_id"].(primitive.ObjectID); isObjectID {
+				l.cache[loaderKey{database: collection.database, collection: collection.collection, id: id}] = doc
+			}
+			return nil
+		})
+		if err != nil {
+			// the documents of all the collections are loaded again by the next call
+			for _, key := range added {
+				delete(l.cache, key)
+			}
+			return nil, err
+		}
+	}
+
+	docs := make([]model.DataModel, len(refs))
+	for i, key := range keys {
+		docs[i] = l.cache[key]
+	}
+	return docs, nil
+}
+
+func (l *Loader) key(ref model.DBRef) loaderKey {
+	database := ref.Database
+	if database == "" {
+		database = l.databaseName
+	}
+	return loaderKey{database: database, collection: ref.Ref, id: ref.ID}
+}
+
+// Populate replaces the references stored in the reference fields of the documents
+// with the referenced documents like the lookup stages of the reference fields do.
+// The references are the values that model.ToDBRef converts, which are the ones that
+// model.Validate accepts, and the ones in the object and array fields are populated
+// as well. The single references whose documents don't exist are deleted and the
+// missing documents of the multiple references are skipped.
+//
+// The depth is the number of the levels of the references to populate. The fields of
+// the populated documents are the fields of the reference field (GetFields), so the
+// references in them are populated if the depth is more than 1. It returns a DVNError
+// with status 400 if the depth is more than MaxLoaderDepth.
+func (l *Loader) Populate(ctx context.Context, docs []*model.DataModel, fields []model.Field, depth int) error {
+	if depth > MaxLoaderDepth {
+		return core.NewError(http.StatusBadRequest, "max-depth-exceeded:"+strconv.Itoa(MaxLoaderDepth))
+	}
+
+	targets := make([]populateTarget, 0, len(docs))
+	for _, dm := range docs {
+		if dm != nil {
+			targets = append(targets, populateTarget{doc: *dm, fields: fields})
+		}
+	}
+	for level := 0; level < depth && len(targets) > 0; level++ {
+		slots := make([]referenceSlot, 0)
+		for _, target := range targets {
+			slots = append(slots, collectReferences(target.doc, target.fields)...)
+		}
+		refs := make([]model.DBRef, 0, len(slots))
+		for _, slot := range slots {
+			refs = append(refs, slot.refs...)
+		}
+		loaded, err := l.Load(ctx, refs)
+		if err != nil {
+			return err
+		}
+
+		targets = make([]populateTarget, 0)
+		for _, slot := range slots {
+			populated := make([]interface{}, 0, len(slot.refs))
+			for _, doc := range loaded[:len(slot.refs)] {
+				if doc != nil {
+					// the cached documents are shared by the references
+					copied := doc.Copy()
+					populated = append(populated, copied)
+					targets = append(targets, populateTarget{doc: copied, fields: slot.fields})
+				}
+			}
+			loaded = loaded[len(slot.refs):]
+
+			switch {
+			case !slot.isSingle:
+				slot.doc[slot.key] = bson.A(populated)
+			case len(populated) == 0:
+				delete(slot.doc, slot.key)
+			default:
+				slot.doc[slot.key] = populated[0]
+			}
+		}
+	}
+	return nil
+}
+
+// populateTarget is a document whose references are populated with its fields.
+type populateTarget struct {
+	doc    map[string]interface{}
+	fields []model.Field
+}
+
+// referenceSlot is a key of a document that stores a reference, or an array of
+// references if it's not single. The fields are the fields of the referenced
+// documents.
+type referenceSlot struct {
+	doc      map[string]interface{}
+	key      string
+	refs     []model.DBRef
+	isSingle bool
+	fields   []model.Field
+}
+
+// collectReferences returns the references in the reference fields of the document
+// and in its object and array fields.
+func collectReferences(doc map[string]interface{}, fields []model.Field) []referenceSlot {
+	slots := make([]referenceSlot, 0)
+	for _, field := range fields {
+		value, has := doc[field.GetID()]
+		if !has || value == nil {
+			continue
+		}
+
+		switch field.GetType() {
+		case model.FieldTypeReference:
+			reference := model.ReferenceFromField(field)
+			slot := referenceSlot{doc: doc, key: field.GetID(), isSingle: reference.IsSingle(), fields: reference.GetFields()}
+			if slot.refs = toDBRefs(reference, value); slot.refs != nil {
+				slots = append(slots, slot)
+			}
+		case model.FieldTypeObject:
+			if inner, isDocument := model.WritableDocument(value); isDocument {
+				slots = append(slots, collectReferences(inner, field.GetFields())...)
+			}
+		case model.FieldTypeArray:
+			items := model.ArrayFromField(field).GetItems()
+			elements, isArray := match.AsArray(value)
+			if items == nil || !isArray {
+				continue
+			}
+			for _, element := range elements {
+				if inner, isDocument := model.WritableDocument(element); isDocument {
+					slots = append(slots, collectReferences(inner, items.GetFields())...)
+				}
+			}
+		}
+	}
+	return slots
+}
+
+// toDBRefs returns the references in the value of the reference field, which is an
+// array if it's not a single reference. It returns nil if the value is not a
+// reference, such as the documents that are already populated.
+func toDBRefs(field model.ReferenceField, value interface{}) []model.DBRef {
+	values := []interface{}{value}
+	if !field.IsSingle() {
+		items, isArray := match.AsArray(value)
+		if !isArray {
+			return nil
+		}
+		values = items
+	}
+
+	refs := make([]model.DBRef, 0, len(values))
+	for _, v := range values {
+		if isPopulated(v) {
+			return nil
+		}
+		ref, isRef := model.ToDBRef(field.GetOtherCollection(), v)
+		if !isRef {
+			return nil
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// isPopulated returns true if the value is a document that has other keys than the
+// ones of a DBRef, which is a referenced document that is already populated.
+func isPopulated(value interface{}) bool {
+	doc, isDocument := match.AsDocument(value)
+	if !isDocument {
+		return false
+	}
+	for key := range doc {
+		switch key {
+		case "_ref", "_id", "_db", "$ref", "$id", "$db":
+		default:
+			return true
+		}
+	}
+	return false
+}
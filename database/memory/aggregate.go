@@ -0,0 +1,620 @@
+package memory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// runPipeline runs the aggregation stages on the documents of the collection with the
+// case-insensitive collation that Database.Aggregate uses. The collection name is
+// empty if the documents are not of a collection like in $facet.
+func (s *Database) runPipeline(databaseName, collectionName string, docs []bson.M, pipeline interface{}) ([]bson.M, error) {
+	stages, isArray := match.AsArray(pipeline)
+	if !isArray {
+		return nil, fmt.Errorf("pipeline must be an array")
+	}
+
+	matcher := match.New(match.Options{CaseInsensitive: true})
+	for i, stage := range stages {
+		stageDocument, isDocument := match.AsDocument(stage)
+		if !isDocument || len(stageDocument) != 1 {
+			return nil, fmt.Errorf("a pipeline stage specification object must contain exactly one field")
+		}
+
+		var err error
+		for name, spec := range stageDocument {
+			switch name {
+			case "$match":
+				if i == 0 && collectionName != "" {
+					docs, spec, err = s.textSearch(databaseName, collectionName, docs, spec, matcher)
+				}
+				if err == nil {
+					docs, err = filter(docs, spec, matcher)
+				}
+			case "$geoNear":
+				if i != 0 {
+					return nil, fmt.Errorf("$geoNear is only valid as the first stage in a pipeline")
+				}
+				docs, err = geoNear(docs, spec, matcher)
+			case "$lookup":
+				docs, err = s.lookup(databaseName, docs, spec, matcher)
+			case "$project":
+				docs, err = project(docs, spec, matcher)
+			case "$unwind":
+				docs, err = unwind(docs, spec)
+			case "$addFields", "$set":
+				docs, err = addFields(docs, spec, matcher)
+			case "$sort":
+				var sortDocument bson.D
+				sortDocument, err = toSortDocument(spec)
+				if err == nil {
+					sortDocuments(docs, sortDocument, matcher)
+				}
+			case "$skip":
+				count, isNumber := toInt64(spec)
+				if !isNumber {
+					return nil, fmt.Errorf("invalid argument to $skip stage")
+				}
+				docs = skip(docs, count)
+			case "$limit":
+				count, isNumber := toInt64(spec)
+				if !isNumber || count <= 0 {
+					return nil, fmt.Errorf("the limit must be positive")
+				}
+				docs = take(docs, count)
+			case "$group":
+				docs, err = group(docs, spec, matcher)
+			case "$count":
+				docs, err = count(docs, spec)
+			case "$facet":
+				docs, err = s.facet(databaseName, docs, spec)
+			default:
+				err = fmt.Errorf("unsupported pipeline stage %s", name)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// textSearch keeps the documents that match the $text query of the $match stage
+// with the text index of the collection, sets their text scores and returns the rest
+// of the query.
+func (s *Database) textSearch(databaseName, collectionName string, docs []bson.M, spec interface{}, matcher *match.Matcher) ([]bson.M, interface{}, error) {
+	query, isDocument := match.AsDocument(spec)
+	if !isDocument {
+		return docs, spec, nil
+	}
+	operand, hasText := query["$text"]
+	if !hasText {
+		return docs, spec, nil
+	}
+	search, isValid := match.ParseTextSearch(operand)
+	if !isValid {
+		return nil, nil, fmt.Errorf("$text requires a $search string")
+	}
+
+	s.mutex.RLock()
+	paths, hasIndex := s.textIndexes[collectionKey(databaseName, collectionName)]
+	s.mutex.RUnlock()
+	if !hasIndex {
+		return nil, nil, fmt.Errorf("text index required for $text query")
+	}
+
+	result := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		values := make([]string, 0)
+		for _, path := range paths {
+			for _, value := range match.Lookup(doc, path) {
+				if text, isString := value.(string); isString {
+					values = append(values, text)
+				}
+			}
+		}
+		if score, matches := search.Score(values); matches {
+			matcher.SetTextScore(doc, score)
+			result = append(result, doc)
+		}
+	}
+
+	rest := bson.M{}
+	for key, value := range query {
+		if key != "$text" {
+			rest[key] = value
+		}
+	}
+	return result, rest, nil
+}
+
+// geoNear keeps the documents whose key field is in the distance range of the point,
+// sets their distances in meters and sorts them by distance. The key is required
+// since there isn't any index to choose the field from. The spherical option is
+// implied since only the GeoJSON points are supported.
+func geoNear(docs []bson.M, spec interface{}, matcher *match.Matcher) ([]bson.M, error) {
+	options, isDocument := match.AsDocument(spec)
+	if !isDocument {
+		return nil, fmt.Errorf("$geoNear specification must be an object")
+	}
+	key, _ := options["key"].(string)
+	distanceField, _ := options["distanceField"].(string)
+	if key == "" || distanceField == "" {
+		return nil, fmt.Errorf("$geoNear requires key and distanceField")
+	}
+	near, err := match.ParseNearQuery(options["near"], options["minDistance"], options["maxDistance"])
+	if err != nil {
+		return nil, err
+	}
+	if query, hasQuery := options["query"]; hasQuery {
+		if docs, err = filter(docs, query, matcher); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		distance, isInRange := near.Distance(match.Lookup(doc, key))
+		if !isInRange {
+			continue
+		}
+		if err := setPath(doc, distanceField, distance); err != nil {
+			return nil, err
+		}
+		result = append(result, doc)
+	}
+	sortDocuments(result, bson.D{{Key: distanceField, Value: 1}}, matcher)
+	return result, nil
+}
+
+// count replaces the documents with a document that contains the number of them.
+// There isn't any document in the result if there isn't any document to count.
+func count(docs []bson.M, spec interface{}) ([]bson.M, error) {
+	field, isString := spec.(string)
+	if !isString || field == "" || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+		return nil, fmt.Errorf("the count field must be a non-empty string without $ and .")
+	}
+	if len(docs) == 0 {
+		return []bson.M{}, nil
+	}
+	return []bson.M{{field: int32(len(docs))}}, nil
+}
+
+// group groups the documents by the value of the _id expression and computes the
+// accumulators of the groups. The groups are in the order of their first documents.
+func group(docs []bson.M, spec interface{}, matcher *match.Matcher) ([]bson.M, error) {
+	fields, isDocument := match.AsDocument(spec)
+	if !isDocument {
+		return nil, fmt.Errorf("a group's fields must be specified in an object")
+	}
+	idExpression, hasID := fields["_id"]
+	if !hasID {
+		return nil, fmt.Errorf("a group specification must include an _id")
+	}
+
+	type accumulator struct {
+		operator   string
+		expression interface{}
+	}
+	accumulators := map[string]accumulator{}
+	for name, value := range fields {
+		if name == "_id" {
+			continue
+		}
+		operators, isOperator := match.AsDocument(value)
+		if !isOperator || len(operators) != 1 {
+			return nil, fmt.Errorf("the group aggregate field '%s' must be defined as an expression inside an object", name)
+		}
+		for operator, expression := range operators {
+			if operator == "$count" {
+				// { $count: {} } is the same as { $sum: 1 }
+				operator, expression = "$sum", int64(1)
+			}
+			accumulators[name] = accumulator{operator, expression}
+		}
+	}
+
+	type groupValues struct {
+		id     interface{}
+		values map[string][]interface{}
+	}
+	groups := make([]*groupValues, 0)
+	for _, doc := range docs {
+		id, err := matcher.Evaluate(idExpression, doc)
+		if err != nil {
+			return nil, err
+		}
+		var g *groupValues
+		for _, existing := range groups {
+			if matcher.Compare(existing.id, id) == 0 {
+				g = existing
+				break
+			}
+		}
+		if g == nil {
+			g = &groupValues{id: id, values: map[string][]interface{}{}}
+			groups = append(groups, g)
+		}
+		for name, a := range accumulators {
+			value, err := matcher.Evaluate(a.expression, doc)
+			if err != nil {
+				return nil, err
+			}
+			g.values[name] = append(g.values[name], value)
+		}
+	}
+
+	result := make([]bson.M, len(groups))
+	for i, g := range groups {
+		doc := bson.M{"_id": g.id}
+		for name, a := range accumulators {
+			value, err := matcher.Accumulate(a.operator, g.values[name])
+			if err != nil {
+				return nil, err
+			}
+			doc[name] = value
+		}
+		result[i] = doc
+	}
+	return result, nil
+}
+
+// facet runs the pipelines of the facet on the copies of the documents and returns
+// a document that contains the results of the pipelines.
+func (s *Database) facet(databaseName string, docs []bson.M, spec interface{}) ([]bson.M, error) {
+	pipelines, isDocument := match.AsDocument(spec)
+	if !isDocument {
+		return nil, fmt.Errorf("$facet specification stage must be an object")
+	}
+
+	result := bson.M{}
+	for name, pipeline := range pipelines {
+		copies := make([]bson.M, len(docs))
+		for i, doc := range docs {
+			copied, err := toDocument(doc)
+			if err != nil {
+				return nil, err
+			}
+			copies[i] = copied
+		}
+
+		facetDocs, err := s.runPipeline(databaseName, "", copies, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		items := make(bson.A, len(facetDocs))
+		for i, doc := range facetDocs {
+			items[i] = doc
+		}
+		result[name] = items
+	}
+	return []bson.M{result}, nil
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), v == float64(int64(v))
+	}
+	return 0, false
+}
+
+// lookup joins the documents of the other collection by the local and foreign fields
+// and runs the pipeline on them if there is one. The variables (let) are not supported.
+func (s *Database) lookup(databaseName string, docs []bson.M, spec interface{}, matcher *match.Matcher) ([]bson.M, error) {
+	options, isDocument := match.AsDocument(spec)
+	if !isDocument {
+		return nil, fmt.Errorf("the $lookup specification must be an object")
+	}
+	if _, hasLet := options["let"]; hasLet {
+		return nil, fmt.Errorf("$lookup with let is not supported")
+	}
+	from, _ := options["from"].(string)
+	as, _ := options["as"].(string)
+	localField, _ := options["localField"].(string)
+	foreignField, _ := options["foreignField"].(string)
+	pipeline, hasPipeline := options["pipeline"]
+	if from == "" || as == "" {
+		return nil, fmt.Errorf("$lookup requires from and as")
+	}
+	if (localField == "") != (foreignField == "") {
+		return nil, fmt.Errorf("$lookup requires both or neither of localField and foreignField")
+	}
+	if localField == "" && !hasPipeline {
+		return nil, fmt.Errorf("$lookup requires either a pipeline or localField and foreignField")
+	}
+
+	foreignDocs := s.documents(databaseName, from)
+	for _, doc := range docs {
+		joined := foreignDocs
+		if localField != "" {
+			localValues := make(bson.A, 0)
+			for _, value := range match.Lookup(doc, localField) {
+				if items, isArray := match.AsArray(value); isArray {
+					localValues = append(localValues, items...)
+				} else {
+					localValues = append(localValues, value)
+				}
+			}
+			if len(localValues) == 0 {
+				// the missing local field matches the foreign fields that are null or missing
+				localValues = bson.A{nil}
+			}
+
+			var err error
+			joined, err = filter(foreignDocs, bson.M{foreignField: bson.M{"$in": localValues}}, matcher)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		copies := make([]bson.M, len(joined))
+		for i, joinedDoc := range joined {
+			copies[i], _ = toDocument(joinedDoc)
+		}
+		if hasPipeline {
+			var err error
+			copies, err = s.runPipeline(databaseName, from, copies, pipeline)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result := make(bson.A, len(copies))
+		for i, joinedDoc := range copies {
+			result[i] = joinedDoc
+		}
+		if err := setPath(doc, as, result); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// unwind outputs a document for each item of the array field.
+func unwind(docs []bson.M, spec interface{}) ([]bson.M, error) {
+	path, _ := spec.(string)
+	preserve := false
+	includeArrayIndex := ""
+	if options, isDocument := match.AsDocument(spec); isDocument {
+		path, _ = options["path"].(string)
+		preserve, _ = options["preserveNullAndEmptyArrays"].(bool)
+		includeArrayIndex, _ = options["includeArrayIndex"].(string)
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("$unwind path must be prefixed by a '$'")
+	}
+	path = path[1:]
+
+	result := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		value, has := getPath(doc, path)
+		array, isArray := value.(bson.A)
+		switch {
+		case isArray && len(array) > 0:
+			for i, item := range array {
+				unwound, err := toDocument(doc)
+				if err != nil {
+					return nil, err
+				}
+				if err := setPath(unwound, path, item); err != nil {
+					return nil, err
+				}
+				if includeArrayIndex != "" {
+					_ = setPath(unwound, includeArrayIndex, int64(i))
+				}
+				result = append(result, unwound)
+			}
+		case has && value != nil && !isArray:
+			if includeArrayIndex != "" {
+				_ = setPath(doc, includeArrayIndex, nil)
+			}
+			result = append(result, doc)
+		case preserve:
+			if isArray {
+				unsetPath(doc, path)
+			}
+			if includeArrayIndex != "" {
+				_ = setPath(doc, includeArrayIndex, nil)
+			}
+			result = append(result, doc)
+		}
+	}
+	return result, nil
+}
+
+// addFields sets the values of the expressions to the fields of the documents.
+func addFields(docs []bson.M, spec interface{}, matcher *match.Matcher) ([]bson.M, error) {
+	fields, isDocument := match.AsDocument(spec)
+	if !isDocument {
+		return nil, fmt.Errorf("$addFields specification stage must be an object")
+	}
+
+	for _, doc := range docs {
+		values := make(map[string]interface{}, len(fields))
+		for path, expression := range fields {
+			value, err := matcher.Evaluate(expression, doc)
+			if err != nil {
+				return nil, err
+			}
+			values[path] = value
+		}
+		for path, value := range values {
+			if err := setPath(doc, path, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+// projection is a tree of the paths of a $project stage.
+type projection struct {
+	include    bool
+	expression interface{}
+	children   map[string]*projection
+}
+
+func (p *projection) add(path string, include bool, expression interface{}) {
+	node := p
+	for _, part := range strings.Split(path, ".") {
+		if node.children == nil {
+			node.children = map[string]*projection{}
+		}
+		child, has := node.children[part]
+		if !has {
+			child = &projection{}
+			node.children[part] = child
+		}
+		node = child
+	}
+	node.include = include
+	node.expression = expression
+}
+
+// project keeps or removes the fields of the documents. The fields can be set to an
+// expression as well in the inclusion projections.
+func project(docs []bson.M, spec interface{}, matcher *match.Matcher) ([]bson.M, error) {
+	fields, isDocument := match.AsDocument(spec)
+	if !isDocument || len(fields) == 0 {
+		return nil, fmt.Errorf("$project specification must be a nonempty object")
+	}
+
+	root := &projection{}
+	inclusion, exclusion := false, false
+	excludeID := false
+	for path, value := range fields {
+		switch {
+		case isFlag(value, false):
+			if path == "_id" {
+				excludeID = true
+				continue
+			}
+			exclusion = true
+			root.add(path, false, nil)
+		case isFlag(value, true):
+			inclusion = true
+			root.add(path, true, nil)
+		default:
+			inclusion = true
+			root.add(path, true, value)
+		}
+	}
+	if inclusion && exclusion {
+		return nil, fmt.Errorf("cannot do exclusion and inclusion in the same $project")
+	}
+
+	if inclusion && !excludeID {
+		if _, has := root.children["_id"]; !has {
+			root.add("_id", true, nil)
+		}
+	}
+
+	result := make([]bson.M, len(docs))
+	for i, doc := range docs {
+		if !inclusion {
+			if excludeID {
+				delete(doc, "_id")
+			}
+			excludeFields(doc, root)
+			result[i] = doc
+			continue
+		}
+
+		projected, err := includeFields(doc, doc, root, matcher)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = projected
+	}
+	return result, nil
+}
+
+func isFlag(value interface{}, flag bool) bool {
+	if b, isBool := value.(bool); isBool {
+		return b == flag
+	}
+	if n, isNumber := toInt64(value); isNumber {
+		return (n != 0) == flag
+	}
+	return false
+}
+
+func includeFields(root bson.M, doc bson.M, p *projection, matcher *match.Matcher) (bson.M, error) {
+	result := bson.M{}
+	for key, child := range p.children {
+		if child.expression != nil {
+			value, err := matcher.Evaluate(child.expression, root)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = value
+			continue
+		}
+
+		value, has := doc[key]
+		if !has {
+			continue
+		}
+		if child.children == nil {
+			result[key] = value
+			continue
+		}
+
+		projected, keep, err := includeValue(root, value, child, matcher)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			result[key] = projected
+		}
+	}
+	return result, nil
+}
+
+func includeValue(root bson.M, value interface{}, p *projection, matcher *match.Matcher) (interface{}, bool, error) {
+	switch v := value.(type) {
+	case bson.M:
+		projected, err := includeFields(root, v, p, matcher)
+		return projected, true, err
+	case bson.A:
+		items := bson.A{}
+		for _, item := range v {
+			projected, keep, err := includeValue(root, item, p, matcher)
+			if err != nil {
+				return nil, false, err
+			}
+			if keep {
+				items = append(items, projected)
+			}
+		}
+		return items, true, nil
+	}
+	return nil, false, nil
+}
+
+func excludeFields(value interface{}, p *projection) {
+	switch v := value.(type) {
+	case bson.M:
+		for key, child := range p.children {
+			if child.children == nil {
+				delete(v, key)
+			} else if childValue, has := v[key]; has {
+				excludeFields(childValue, child)
+			}
+		}
+	case bson.A:
+		for _, item := range v {
+			excludeFields(item, p)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package memory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// toDocument converts any value that can be marshalled to BSON into a document that
+// contains the same types the driver decodes: bson.M for the documents and bson.A
+// for the arrays. The result doesn't share any memory with the value.
+func toDocument(value interface{}) (bson.M, error) {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// decode decodes the document into the item like mongo.SingleResult.Decode does.
+func decode(doc bson.M, item interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, item)
+}
+
+// getPath returns the value at the dotted path. Numeric parts are used as the
+// index of the arrays.
+func getPath(doc bson.M, path string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case bson.M:
+			child, has := v[part]
+			if !has {
+				return nil, false
+			}
+			value = child
+		case bson.A:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setPath sets the value at the dotted path by creating the missing documents on
+// the way. The arrays are padded with null when the index is beyond their length.
+func setPath(doc bson.M, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	var parent interface{} = doc
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch p := parent.(type) {
+		case bson.M:
+			if last {
+				p[part] = value
+				return nil
+			}
+			child, has := p[part]
+			if !has || child == nil {
+				child = bson.M{}
+				p[part] = child
+			}
+			parent = child
+		case bson.A:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 {
+				return fmt.Errorf("cannot create field '%s' in array of %s", part, path)
+			}
+			for len(p) <= index {
+				p = append(p, nil)
+			}
+			if err := setPath(doc, strings.Join(parts[:i], "."), p); err != nil {
+				return err
+			}
+			if last {
+				p[index] = value
+				return nil
+			}
+			if p[index] == nil {
+				p[index] = bson.M{}
+			}
+			parent = p[index]
+		default:
+			return fmt.Errorf("cannot create field '%s' in element {%s: %v}", part, strings.Join(parts[:i], "."), parent)
+		}
+	}
+	return nil
+}
+
+// unsetPath removes the field at the dotted path. The items of the arrays are set
+// to null instead of being removed like the $unset operator does.
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	parent, has := getPath(doc, strings.Join(parts[:len(parts)-1], "."))
+	if len(parts) == 1 {
+		parent, has = doc, true
+	}
+	if !has {
+		return
+	}
+
+	last := parts[len(parts)-1]
+	switch p := parent.(type) {
+	case bson.M:
+		delete(p, last)
+	case bson.A:
+		if index, err := strconv.Atoi(last); err == nil && index >= 0 && index < len(p) {
+			p[index] = nil
+		}
+	}
+}
@@ -0,0 +1,370 @@
+// Package memory contains an in-memory implementation of database.Storage that is
+// meant to be used in the tests of the controllers and services.
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/devingen/api-core/database"
+	"github.com/devingen/api-core/internal/match"
+	"github.com/devingen/api-core/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Database keeps the documents of the collections in memory. It supports the common
+// query operators, sort, skip and limit in the queries and the $match, $lookup,
+// $project, $unwind, $addFields, $sort, $skip, $limit, $group, $count and $facet
+// stages in the aggregations. $text is supported in the first $match stage if the
+// collection has a text index and $geoNear is supported as the first stage without
+// an index. The geo queries compute the distances on a sphere like 2dsphere indexes.
+type Database struct {
+	mutex       sync.RWMutex
+	collections map[string][]bson.M
+	textIndexes map[string][]string
+}
+
+var _ database.Storage = (*Database)(nil)
+
+func New() *Database {
+	return &Database{
+		collections: map[string][]bson.M{},
+		textIndexes: map[string][]string{},
+	}
+}
+
+func collectionKey(databaseName, collectionName string) string {
+	return databaseName + "." + collectionName
+}
+
+// documents returns copies of the documents of the collection in the insertion order.
+func (s *Database) documents(databaseName, collectionName string) []bson.M {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stored := s.collections[collectionKey(databaseName, collectionName)]
+	docs := make([]bson.M, len(stored))
+	for i, doc := range stored {
+		docs[i], _ = toDocument(doc)
+	}
+	return docs
+}
+
+func (s *Database) Aggregate(ctx context.Context, databaseName, collectionName string, condition []bson.M, appender database.Appender) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	docs, err := s.runPipeline(databaseName, collectionName, s.documents(databaseName, collectionName), condition)
+	if err != nil {
+		return err
+	}
+	return iterate(ctx, docs, appender)
+}
+
+// CreateTextIndex sets the text fields that the $text queries of the collection
+// search in like database.Database.CreateTextIndex. The language is not used since
+// the stemming and the stop words are not supported.
+func (s *Database) CreateTextIndex(ctx context.Context, databaseName, collectionName string, fields []model.Field, language string) (string, error) {
+	keys := model.TextIndexKeys(fields)
+	if len(keys) == 0 {
+		return "", errors.New("there isn't any text field to index")
+	}
+
+	paths := make([]string, len(keys))
+	for i, key := range keys {
+		paths[i] = key.Key
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.textIndexes[collectionKey(databaseName, collectionName)] = paths
+	return database.TextIndexName, nil
+}
+
+func (s *Database) Get(ctx context.Context, databaseName, collectionName, id string, item interface{}) error {
+	oID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	return s.FindOne(ctx, databaseName, collectionName, bson.M{"_id": oID}, item)
+}
+
+func (s *Database) Create(ctx context.Context, databaseName, collectionName string, item interface{}) (*primitive.ObjectID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	doc, err := toDocument(item)
+	if err != nil {
+		return nil, err
+	}
+	if _, hasID := doc["_id"]; !hasID {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	id, isObjectID := doc["_id"].(primitive.ObjectID)
+	if !isObjectID {
+		return nil, errors.New("_id of the item must be an ObjectID")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := collectionKey(databaseName, collectionName)
+	for _, stored := range s.collections[key] {
+		if stored["_id"] == id {
+			return nil, mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+				Code:    11000,
+				Message: "E11000 duplicate key error collection: " + key + " index: _id_ dup key: { _id: ObjectId('" + id.Hex() + "') }",
+			}}}
+		}
+	}
+	s.collections[key] = append(s.collections[key], doc)
+	return &id, nil
+}
+
+// Update applies the update operators to the document and decodes the document as
+// it was before the update into the result like FindOneAndUpdate does.
+func (s *Database) Update(ctx context.Context, databaseName, collectionName string, id primitive.ObjectID, result interface{}, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	update, err := toDocument(data)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := collectionKey(databaseName, collectionName)
+	for i, stored := range s.collections[key] {
+		if stored["_id"] != id {
+			continue
+		}
+
+		updated, err := toDocument(stored)
+		if err != nil {
+			return err
+		}
+		if err := applyUpdate(updated, update); err != nil {
+			return err
+		}
+		s.collections[key][i] = updated
+		return decode(stored, result)
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (s *Database) Delete(ctx context.Context, databaseName, collectionName string, id string) (*mongo.DeleteResult, error) {
+	oID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := collectionKey(databaseName, collectionName)
+	for i, stored := range s.collections[key] {
+		if stored["_id"] == oID {
+			s.collections[key] = append(s.collections[key][:i], s.collections[key][i+1:]...)
+			return &mongo.DeleteResult{DeletedCount: 1}, nil
+		}
+	}
+	return &mongo.DeleteResult{DeletedCount: 0}, nil
+}
+
+func (s *Database) FindOne(ctx context.Context, databaseName, collectionName string, query bson.M, item interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	docs, err := filter(s.documents(databaseName, collectionName), query, match.New(match.Options{}))
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return decode(docs[0], item)
+}
+
+func (s *Database) Find(ctx context.Context, databaseName, collectionName string, query bson.M, queryOptions database.FindOptions, appender database.Appender) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	matcher := match.New(match.Options{})
+	docs, err := filter(s.documents(databaseName, collectionName), query, matcher)
+	if err != nil {
+		return err
+	}
+
+	if queryOptions.Sort != nil {
+		sortDocument, err := toSortDocument(queryOptions.Sort)
+		if err != nil {
+			return err
+		}
+		sortDocuments(docs, sortDocument, matcher)
+	}
+	docs = skip(docs, queryOptions.Skip)
+	limit := queryOptions.Limit
+	if limit < 0 {
+		limit = -limit
+	}
+	docs = take(docs, limit)
+
+	return iterate(ctx, docs, appender)
+}
+
+// filter returns the documents that match the query.
+func filter(docs []bson.M, query interface{}, matcher *match.Matcher) ([]bson.M, error) {
+	if query == nil {
+		return docs, nil
+	}
+
+	result := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		matched, err := matcher.Match(query, doc)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			result = append(result, doc)
+		}
+	}
+	return result, nil
+}
+
+// toSortDocument converts the sort option or the value of the $sort stage into an
+// ordered document.
+func toSortDocument(value interface{}) (bson.D, error) {
+	if d, isD := value.(bson.D); isD {
+		return d, nil
+	}
+
+	doc, isDocument := match.AsDocument(value)
+	if !isDocument {
+		return nil, errors.New("sort must be a document")
+	}
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	// a map doesn't keep the order of the keys, sort them to be deterministic at least
+	sort.Strings(keys)
+
+	d := make(bson.D, len(keys))
+	for i, key := range keys {
+		d[i] = bson.E{Key: key, Value: doc[key]}
+	}
+	return d, nil
+}
+
+// sortDocuments sorts the documents in the BSON comparison order. The arrays are
+// represented by their smallest item in ascending and largest item in descending sort.
+func sortDocuments(docs []bson.M, sortDocument bson.D, matcher *match.Matcher) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, e := range sortDocument {
+			descending := isDescending(e.Value)
+			a := sortValue(docs[i], e.Key, descending, matcher)
+			b := sortValue(docs[j], e.Key, descending, matcher)
+
+			result := matcher.Compare(a, b)
+			if result == 0 {
+				continue
+			}
+			if descending {
+				return result > 0
+			}
+			return result < 0
+		}
+		return false
+	})
+}
+
+func isDescending(order interface{}) bool {
+	switch o := order.(type) {
+	case int:
+		return o < 0
+	case int32:
+		return o < 0
+	case int64:
+		return o < 0
+	case float64:
+		return o < 0
+	}
+	return false
+}
+
+func sortValue(doc bson.M, path string, descending bool, matcher *match.Matcher) interface{} {
+	var result interface{}
+	found := false
+	for _, value := range match.Lookup(doc, path) {
+		items, isArray := match.AsArray(value)
+		if !isArray {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			if !found {
+				result, found = item, true
+				continue
+			}
+			comparison := matcher.Compare(item, result)
+			if (descending && comparison > 0) || (!descending && comparison < 0) {
+				result = item
+			}
+		}
+	}
+	return result
+}
+
+func skip(docs []bson.M, count int64) []bson.M {
+	if count <= 0 {
+		return docs
+	}
+	if count >= int64(len(docs)) {
+		return []bson.M{}
+	}
+	return docs[count:]
+}
+
+func take(docs []bson.M, count int64) []bson.M {
+	if count <= 0 || count >= int64(len(docs)) {
+		return docs
+	}
+	return docs[:count]
+}
+
+// iterate calls the appender for each document with a cursor like the Database does.
+func iterate(ctx context.Context, docs []bson.M, appender database.Appender) error {
+	documents := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		documents[i] = doc
+	}
+
+	cur, err := mongo.NewCursorFromDocuments(documents, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+
+		err := appender(cur)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
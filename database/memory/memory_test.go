@@ -0,0 +1,603 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	core "github.com/devingen/api-core"
+	"github.com/devingen/api-core/database"
+	"github.com/devingen/api-core/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCRUD(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+
+	id, err := db.Create(ctx, "test", "users", bson.M{"name": "Ada", "tags": bson.A{"math"}})
+	assert.Nil(t, err)
+
+	var user bson.M
+	assert.Nil(t, db.Get(ctx, "test", "users", id.Hex(), &user))
+	assert.Equal(t, "Ada", user["name"])
+
+	var before bson.M
+	err = db.Update(ctx, "test", "users", *id, &before, bson.M{
+		"$set":  bson.M{"address.city": "London"},
+		"$push": bson.M{"tags": "poetry"},
+		"$inc":  bson.M{"visits": 1},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, before["address"])
+
+	var after bson.M
+	assert.Nil(t, db.FindOne(ctx, "test", "users", bson.M{"address.city": "London", "tags": "poetry"}, &after))
+	assert.Equal(t, int32(1), after["visits"])
+
+	_, err = db.Create(ctx, "test", "users", bson.M{"_id": *id})
+	assert.True(t, mongo.IsDuplicateKeyError(err))
+
+	result, err := db.Delete(ctx, "test", "users", id.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), result.DeletedCount)
+	assert.Equal(t, mongo.ErrNoDocuments, db.Get(ctx, "test", "users", id.Hex(), &user))
+}
+
+func TestFind(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	for _, name := range []string{"bob", "Alice", "carol", "dave"} {
+		_, _ = db.Create(ctx, "test", "users", bson.M{"name": name, "length": len(name)})
+	}
+
+	names := make([]string, 0)
+	err := db.Find(ctx, "test", "users", bson.M{"length": bson.M{"$gte": 4}}, database.FindOptions{
+		Sort:  bson.D{{Key: "name", Value: -1}},
+		Skip:  1,
+		Limit: 2,
+	}, func(cur *mongo.Cursor) error {
+		var user bson.M
+		err := cur.Decode(&user)
+		names = append(names, user["name"].(string))
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"carol", "Alice"}, names)
+}
+
+func TestAggregateQueryConfig(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	orgID, _ := db.Create(ctx, "test", "organisations", bson.M{"name": "Devingen"})
+	userID, _ := db.Create(ctx, "test", "users", bson.M{
+		"name":         "Ada",
+		"organisation": model.DBRef{Ref: "organisations", ID: *orgID, Database: "test"},
+	})
+	_, _ = db.Create(ctx, "test", "users", bson.M{"name": "Bob"})
+	for _, title := range []string{"write", "read", "review"} {
+		_, _ = db.Create(ctx, "test", "tasks", bson.M{
+			"title": title,
+			"owner": model.DBRef{Ref: "users", ID: *userID, Database: "test"},
+		})
+	}
+
+	config := &model.QueryConfig{
+		Fields: []model.Field{
+			model.New(model.FieldTypeText, "name"),
+			model.NewReference("organisation", "organisations", true).ToField(),
+			model.NewReverseReference("tasks", "tasks", "owner", false).
+				SetFilter(&model.Filter{Comparison: model.ComparisonContain, FieldId: "title", FieldValue: "r"}).
+				SetSort(&model.SortConfig{ID: "title", Order: 1}).
+				ToField(),
+		},
+		Filter: &model.Filter{Comparison: model.ComparisonEq, FieldId: "organisation.name", FieldValue: "devingen"},
+	}
+
+	pipeline, err := config.ToPipeline()
+	assert.Nil(t, err)
+
+	var results []bson.M
+	err = db.Aggregate(ctx, "test", "users", pipeline, func(cur *mongo.Cursor) error {
+		var user bson.M
+		err := cur.Decode(&user)
+		results = append(results, user)
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "Devingen", results[0]["organisation"].(bson.M)["name"])
+
+	tasks := results[0]["tasks"].(bson.A)
+	assert.Len(t, tasks, 3)
+	assert.Equal(t, "read", tasks[0].(bson.M)["title"])
+	assert.Equal(t, *userID, tasks[0].(bson.M)["owner"].(bson.M)["_id"].(primitive.ObjectID))
+}
+
+func TestAggregateCursorPagination(t *testing.T) {
+	model.CursorSecret = []byte("secret")
+	defer func() { model.CursorSecret = nil }()
+
+	ctx := context.Background()
+	db := New()
+	for _, name := range []string{"e", "b", "d", "a", "c"} {
+		_, _ = db.Create(ctx, "test", "letters", bson.M{"name": name})
+	}
+	_, _ = db.Create(ctx, "test", "letters", bson.M{"name": "c"})
+
+	page := func(config *model.QueryConfig) ([]string, string, string) {
+		pipeline, err := config.ToPipeline()
+		assert.Nil(t, err)
+
+		var results []*model.DataModel
+		err = db.Aggregate(ctx, "test", "letters", pipeline, func(cur *mongo.Cursor) error {
+			var letter model.DataModel
+			err := cur.Decode(&letter)
+			results = append(results, &letter)
+			return err
+		})
+		assert.Nil(t, err)
+
+		next, prev, err := config.Cursors(results)
+		assert.Nil(t, err)
+		names := make([]string, len(results))
+		for i, result := range results {
+			names[i] = result.GetString("name")
+		}
+		return names, next, prev
+	}
+
+	sort := []model.SortConfig{{ID: "name", Order: -1}}
+	names, next, prev := page(&model.QueryConfig{Sort: sort, Limit: 2})
+	assert.Equal(t, []string{"e", "d"}, names)
+	assert.Empty(t, prev)
+
+	names, next, prev = page(&model.QueryConfig{Sort: sort, Limit: 2, After: next})
+	assert.Equal(t, []string{"c", "c"}, names)
+
+	names, next, _ = page(&model.QueryConfig{Sort: sort, Limit: 2, After: next})
+	assert.Equal(t, []string{"b", "a"}, names)
+
+	names, _, _ = page(&model.QueryConfig{Sort: sort, Limit: 2, After: next})
+	assert.Empty(t, names)
+
+	names, _, _ = page(&model.QueryConfig{Sort: sort, Limit: 2, Before: prev})
+	assert.Equal(t, []string{"e", "d"}, names)
+
+	// the cursors are signed and bound to the sort
+	_, err := (&model.QueryConfig{Sort: []model.SortConfig{{ID: "name", Order: 1}}, After: prev}).ToPipeline()
+	assert.Equal(t, core.NewError(http.StatusBadRequest, "cursor-sort-mismatch"), err)
+	_, err = (&model.QueryConfig{Sort: sort, After: prev[1:]}).ToPipeline()
+	assert.Equal(t, core.NewError(http.StatusBadRequest, "invalid-cursor"), err)
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	for _, name := range []string{"Ada", "Bob", "Alan", "Carol", "Alice"} {
+		_, _ = db.Create(ctx, "test", "users", bson.M{"name": name})
+	}
+
+	config := &model.QueryConfig{
+		Filter: &model.Filter{Comparison: model.ComparisonStartsWith, FieldId: "name", FieldValue: "a"},
+		Sort:   []model.SortConfig{{ID: "name", Order: 1}},
+		Skip:   1,
+		Limit:  1,
+	}
+	results, meta, err := database.List(ctx, db, "test", "users", config)
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "Alan", results[0].GetString("name"))
+	assert.Equal(t, &model.Meta{Total: 3}, meta)
+
+	config.Filter = &model.Filter{Comparison: model.ComparisonEq, FieldId: "name", FieldValue: "Dave"}
+	results, meta, err = database.List(ctx, db, "test", "users", config)
+	assert.Nil(t, err)
+	assert.Empty(t, results)
+	assert.Equal(t, &model.Meta{Total: 0}, meta)
+}
+
+func TestAggregateFormula(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	for _, task := range []bson.M{
+		{"title": "a", "estimate": 3, "spent": 1},
+		{"title": "b", "estimate": 2, "spent": 4},
+		{"title": "c", "estimate": 5, "spent": 1},
+	} {
+		_, _ = db.Create(ctx, "test", "tasks", task)
+	}
+
+	config := &model.QueryConfig{
+		Fields: []model.Field{
+			model.New(model.FieldTypeText, "title"),
+			model.NewFormula("remaining", "estimate - spent", model.FieldTypeNumber).ToField(),
+		},
+		Filter: &model.Filter{Comparison: model.ComparisonGt, FieldId: "remaining", FieldValue: 0},
+		Sort:   []model.SortConfig{{ID: "remaining", Order: -1}},
+	}
+	results, _, err := database.List(ctx, db, "test", "tasks", config)
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "c", results[0].GetString("title"))
+	assert.EqualValues(t, 4, (*results[0])["remaining"])
+	assert.Equal(t, "a", results[1].GetString("title"))
+}
+
+func TestAggregateRollup(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	customers := map[string]*primitive.ObjectID{}
+	for _, name := range []string{"Ada", "Bob", "Carol"} {
+		customers[name], _ = db.Create(ctx, "test", "customers", bson.M{"name": name})
+	}
+	for _, order := range []struct {
+		customer string
+		status   string
+		total    float64
+	}{
+		{"Ada", "open", 10}, {"Ada", "closed", 5}, {"Bob", "open", 7.5}, {"Bob", "open", 2.5}, {"Bob", "open", 1},
+	} {
+		_, _ = db.Create(ctx, "test", "orders", bson.M{
+			"customer": bson.M{"_id": *customers[order.customer]},
+			"status":   order.status,
+			"total":    order.total,
+		})
+	}
+
+	open := &model.Filter{Comparison: model.ComparisonEq, FieldId: "status", FieldValue: "open"}
+	config := &model.QueryConfig{
+		Fields: []model.Field{
+			model.New(model.FieldTypeText, "name"),
+			model.NewRollup("openOrders", "orders", "customer", model.RollupFunctionCount, "").SetFilter(open).ToField(),
+			model.NewRollup("averageTotal", "orders", "customer", model.RollupFunctionAvg, "total").ToField(),
+		},
+		Filter: &model.Filter{Comparison: model.ComparisonLt, FieldId: "openOrders", FieldValue: 3},
+		Sort:   []model.SortConfig{{ID: "openOrders", Order: -1}},
+	}
+	results, _, err := database.List(ctx, db, "test", "customers", config)
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "Ada", results[0].GetString("name"))
+	assert.EqualValues(t, 1, (*results[0])["openOrders"])
+	assert.Equal(t, 7.5, (*results[0])["averageTotal"])
+	assert.Equal(t, "Carol", results[1].GetString("name"))
+	assert.EqualValues(t, 0, (*results[1])["openOrders"])
+	assert.Nil(t, (*results[1])["averageTotal"])
+}
+
+func TestGroup(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	for _, order := range []bson.M{
+		{"status": "open", "total": 10, "createdAt": time.Date(2024, 1, 31, 23, 30, 0, 0, time.UTC)},
+		{"status": "open", "total": 5, "createdAt": time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"status": "closed", "total": 20, "createdAt": time.Date(2024, 2, 10, 9, 0, 0, 0, time.UTC)},
+		{"status": "closed", "total": 1, "createdAt": time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC)},
+		{"status": "cancelled", "total": 8, "createdAt": time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+	} {
+		_, _ = db.Create(ctx, "test", "orders", order)
+	}
+
+	config := &model.QueryConfig{
+		Fields: []model.Field{
+			model.New(model.FieldTypeText, "status"),
+			model.NewNumber("total", "").ToField(),
+			model.New(model.FieldTypeDate, "createdAt"),
+		},
+		Filter:   &model.Filter{Comparison: model.ComparisonNe, FieldId: "status", FieldValue: "cancelled"},
+		Location: time.FixedZone("UTC+1", 60*60),
+	}
+	groups, err := database.Group(ctx, db, "test", "orders", config, model.GroupConfig{
+		Keys:       []model.GroupKey{{ID: "createdAt", Bucket: model.DateBucketMonth}},
+		Aggregates: []model.GroupAggregate{{ID: "revenue", Function: model.RollupFunctionSum, FieldId: "total"}},
+	})
+	assert.Nil(t, err)
+	// the first order is in February in UTC+1
+	assert.Len(t, groups, 1)
+	assert.Equal(t, 4, groups[0].Count)
+	assert.EqualValues(t, 36, groups[0].Aggregates["revenue"])
+
+	facets, err := database.Facets(ctx, db, "test", "orders", &model.QueryConfig{}, map[string]model.GroupConfig{
+		"status": {Keys: []model.GroupKey{{ID: "status"}}, Sort: []model.SortConfig{{ID: "count", Order: -1}, {ID: "status", Order: 1}}},
+		"all":    {Aggregates: []model.GroupAggregate{{ID: "average", Function: model.RollupFunctionAvg, FieldId: "total"}}},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, facets["status"], 3)
+	assert.Equal(t, model.DataModel{"status": "closed"}, facets["status"][0].Key)
+	assert.Equal(t, 2, facets["status"][0].Count)
+	assert.Equal(t, model.DataModel{"status": "cancelled"}, facets["status"][2].Key)
+	assert.Equal(t, 8.8, facets["all"][0].Aggregates["average"])
+
+	_, err = database.Group(ctx, db, "test", "orders", config, model.GroupConfig{
+		Keys: []model.GroupKey{{ID: "status", Bucket: model.DateBucketDay}},
+	})
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{"group:invalid-bucket-for-field-type:status:text"}), err)
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	for _, article := range []bson.M{
+		{"title": "Coffee shops in Zürich", "body": "The best coffee and cake"},
+		{"title": "Decaf coffee", "body": "Coffee without caffeine"},
+		{"title": "Tea", "body": "Green tea and black tea"},
+		{"title": "Café culture", "body": "A coffee in a café and a newspaper"},
+	} {
+		_, _ = db.Create(ctx, "test", "articles", article)
+	}
+
+	fields := []model.Field{model.New(model.FieldTypeText, "title"), model.New(model.FieldTypeText, "body")}
+	config := &model.QueryConfig{
+		Fields: fields,
+		Search: &model.SearchConfig{Text: "coffee cafe -decaf", Language: "en"},
+		Sort:   []model.SortConfig{{ID: model.TextScoreField, Order: -1}},
+	}
+	_, _, err := database.List(ctx, db, "test", "articles", config)
+	assert.EqualError(t, err, "text index required for $text query")
+
+	_, err = db.CreateTextIndex(ctx, "test", "articles", fields, "english")
+	assert.Nil(t, err)
+	results, meta, err := database.List(ctx, db, "test", "articles", config)
+	assert.Nil(t, err)
+	assert.Equal(t, &model.Meta{Total: 2}, meta)
+	assert.Equal(t, "Café culture", results[0].GetString("title"))
+	assert.Equal(t, 3.0, (*results[0])[model.TextScoreField])
+	assert.Equal(t, "Coffee shops in Zürich", results[1].GetString("title"))
+
+	config.Search = &model.SearchConfig{Text: `"coffee and cake" zurich`}
+	results, _, err = database.List(ctx, db, "test", "articles", config)
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "Coffee shops in Zürich", results[0].GetString("title"))
+
+	config.Search = &model.SearchConfig{Text: " ", Language: "klingon"}
+	_, _, err = database.List(ctx, db, "test", "articles", config)
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{"search:text-required", "search:invalid-language:klingon"}), err)
+}
+
+func TestGeo(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	for _, venue := range []bson.M{
+		{"name": "Hagia Sophia", "category": "museum", "location": model.NewGeoPoint(28.9802, 41.0086)},
+		{"name": "Galata Tower", "category": "museum", "location": model.NewGeoPoint(28.9741, 41.0256)},
+		{"name": "Taksim Square", "category": "square", "location": model.NewGeoPoint(28.9869, 41.0370)},
+		{"name": "Kocatepe Mosque", "category": "mosque", "location": model.NewGeoPoint(32.8597, 39.9169)},
+	} {
+		_, _ = db.Create(ctx, "test", "venues", venue)
+	}
+
+	names := func(results []*model.DataModel) []string {
+		names := make([]string, len(results))
+		for i, result := range results {
+			names[i] = result.GetString("name")
+		}
+		return names
+	}
+	config := &model.QueryConfig{
+		Fields: []model.Field{
+			model.New(model.FieldTypeText, "name"),
+			model.New(model.FieldTypeText, "category"),
+			model.NewGeo("location", model.GeoShapePoint).ToField(),
+		},
+		Filter: &model.Filter{Comparison: model.ComparisonNear, FieldId: "location", FieldValue: map[string]interface{}{
+			"point": []interface{}{28.9768, 41.0054}, "maxDistance": 5000,
+		}},
+		Sort: []model.SortConfig{{ID: model.DistanceField, Order: 1}},
+	}
+	results, meta, err := database.List(ctx, db, "test", "venues", config)
+	assert.Nil(t, err)
+	assert.Equal(t, &model.Meta{Total: 3}, meta)
+	assert.Equal(t, []string{"Hagia Sophia", "Galata Tower", "Taksim Square"}, names(results))
+	assert.InDelta(t, 460, (*results[0])[model.DistanceField], 10)
+
+	config.Filter = &model.Filter{Operator: model.OperatorAnd, Filters: []model.Filter{
+		{Comparison: model.ComparisonEq, FieldId: "category", FieldValue: "museum"},
+		*config.Filter,
+	}}
+	results, _, err = database.List(ctx, db, "test", "venues", config)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Hagia Sophia", "Galata Tower"}, names(results))
+
+	config.Sort = []model.SortConfig{{ID: "name", Order: 1}}
+	for _, filter := range []model.Filter{
+		{Comparison: model.ComparisonWithinRadius, FieldId: "location", FieldValue: map[string]interface{}{
+			"center": []interface{}{28.9768, 41.0054}, "radius": 3000,
+		}},
+		{Comparison: model.ComparisonWithinBox, FieldId: "location", FieldValue: []interface{}{
+			[]interface{}{28.97, 41.0}, []interface{}{28.99, 41.03},
+		}},
+		{Comparison: model.ComparisonWithinPolygon, FieldId: "location", FieldValue: []interface{}{
+			[]interface{}{28.96, 41.0}, []interface{}{29.0, 41.0}, []interface{}{28.98, 41.04},
+		}},
+	} {
+		config.Filter = &filter
+		results, _, err = database.List(ctx, db, "test", "venues", config)
+		assert.Nil(t, err, filter.Comparison)
+		assert.Equal(t, []string{"Galata Tower", "Hagia Sophia"}, names(results), filter.Comparison)
+	}
+}
+
+func TestObjectAndArrayFields(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	for _, order := range []bson.M{
+		{"number": "A1", "address": bson.M{"city": "Istanbul"}, "tags": bson.A{"gift", "express"}, "scores": bson.A{2, 9},
+			"lines": bson.A{bson.M{"product": "pen", "price": 3, "quantity": 10}, bson.M{"product": "book", "price": 25, "quantity": 1}}},
+		{"number": "A2", "address": bson.M{"city": "Ankara"}, "tags": bson.A{"express"}, "scores": bson.A{5},
+			"lines": bson.A{bson.M{"product": "lamp", "price": 40, "quantity": 2}}},
+		{"number": "A3", "address": bson.M{"city": "Izmir"}, "tags": bson.A{}, "scores": bson.A{},
+			"lines": bson.A{}},
+	} {
+		_, _ = db.Create(ctx, "test", "orders", order)
+	}
+
+	config := &model.QueryConfig{
+		Fields: []model.Field{
+			model.New(model.FieldTypeText, "number"),
+			model.NewObject("address", []model.Field{model.New(model.FieldTypeText, "city")}).ToField(),
+			model.NewArray("tags", model.New(model.FieldTypeText, "")).ToField(),
+			model.NewArray("scores", model.NewNumber("", model.NumberFormatInteger).ToField()).ToField(),
+			model.NewArray("lines", model.NewObject("", []model.Field{
+				model.New(model.FieldTypeText, "product"),
+				model.New(model.FieldTypeNumber, "price"),
+				model.New(model.FieldTypeNumber, "quantity"),
+			}).ToField()).ToField(),
+		},
+		Sort: []model.SortConfig{{ID: "number", Order: 1}},
+	}
+	numbers := func(filter model.Filter) []string {
+		config.Filter = &filter
+		assert.Nil(t, filter.Validate(config), filter)
+		results, _, err := database.List(ctx, db, "test", "orders", config)
+		assert.Nil(t, err, filter)
+		numbers := make([]string, len(results))
+		for i, result := range results {
+			numbers[i] = result.GetString("number")
+		}
+		return numbers
+	}
+
+	assert.Equal(t, []string{"A2"}, numbers(model.Filter{Comparison: model.ComparisonEq, FieldId: "address.city", FieldValue: "ankara"}))
+	assert.Equal(t, []string{"A1", "A2"}, numbers(model.Filter{Comparison: model.ComparisonEq, FieldId: "tags", FieldValue: "express"}))
+	assert.Equal(t, []string{"A1"}, numbers(model.Filter{Comparison: model.ComparisonAll, FieldId: "tags", FieldValue: []interface{}{"express", "gift"}}))
+	assert.Equal(t, []string{"A1", "A2"}, numbers(model.Filter{Comparison: model.ComparisonAny, FieldId: "tags", FieldValue: []interface{}{"gift", "express"}}))
+	assert.Equal(t, []string{"A3"}, numbers(model.Filter{Comparison: model.ComparisonSize, FieldId: "lines", FieldValue: 0}))
+
+	// any of the elements matches the comparisons of the elements
+	assert.Equal(t, []string{"A1", "A2"}, numbers(model.Filter{Comparison: model.ComparisonGt, FieldId: "lines.price", FieldValue: "20"}))
+	assert.Equal(t, []string{"A1"}, numbers(model.Filter{Comparison: model.ComparisonEq, FieldId: "lines.0.product", FieldValue: "pen"}))
+
+	// the bounds of between must match the same element unlike the separate comparisons
+	assert.Equal(t, []string{"A2"}, numbers(model.Filter{Comparison: model.ComparisonBetween, FieldId: "scores", FieldValue: []interface{}{4, 6}}))
+
+	// an element must match all the filters of elem-match
+	assert.Equal(t, []string{"A2"}, numbers(model.Filter{Comparison: model.ComparisonElemMatch, FieldId: "lines", FieldValue: map[string]interface{}{
+		"operator": "and",
+		"filters": []interface{}{
+			map[string]interface{}{"comparison": "gt", "id": "price", "value": float64(20)},
+			map[string]interface{}{"comparison": "gte", "id": "quantity", "value": float64(2)},
+		},
+	}}))
+	assert.Equal(t, []string{"A1", "A2"}, numbers(model.Filter{Operator: model.OperatorAnd, Filters: []model.Filter{
+		{Comparison: model.ComparisonGt, FieldId: "lines.price", FieldValue: 20},
+		{Comparison: model.ComparisonGte, FieldId: "lines.quantity", FieldValue: 2},
+	}}))
+}
+
+func TestPatch(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	fields := []model.Field{
+		model.New(model.FieldTypeText, "name"),
+		model.New(model.FieldTypeText, "city"),
+		model.NewMultiSelect("tags", []model.SelectOption{{Value: "math"}, {Value: "poetry"}, {Value: "music"}}).ToField(),
+	}
+	id, err := db.Create(ctx, "test", "users", bson.M{"name": "Ada", "city": "London", "tags": bson.A{"math", "poetry"}})
+	assert.Nil(t, err)
+
+	patch, err := model.ParsePatch(model.ContentTypeJSONPatch, []byte(`[
+		{ "op": "remove", "path": "/tags/1" },
+		{ "op": "add", "path": "/tags/-", "value": "music" },
+		{ "op": "remove", "path": "/city" }
+	]`))
+	assert.Nil(t, err)
+	patched, err := database.Patch(ctx, db, "test", "users", *id, fields, patch)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"math", "music"}, (*patched)["tags"])
+
+	var user bson.M
+	assert.Nil(t, db.Get(ctx, "test", "users", id.Hex(), &user))
+	assert.Equal(t, bson.A{"math", "music"}, user["tags"])
+	assert.Nil(t, user["city"])
+
+	_, err = database.Patch(ctx, db, "test", "users", *id, fields, model.MergePatch{"tags": bson.A{"chess"}})
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{"tags:invalid-option:chess"}), err)
+	_, err = database.Patch(ctx, db, "test", "users", primitive.NewObjectID(), fields, model.MergePatch{"name": "Ada"})
+	assert.Equal(t, mongo.ErrNoDocuments, err)
+}
+
+// countingStorage counts the Find calls of the storage and fails the ones of the
+// failing collection.
+type countingStorage struct {
+	database.Storage
+	finds   int
+	failing string
+}
+
+func (s *countingStorage) Find(ctx context.Context, databaseName, collectionName string, query bson.M, queryOptions database.FindOptions, appender database.Appender) error {
+	s.finds++
+	if collectionName == s.failing {
+		return errors.New("find failed")
+	}
+	return s.Storage.Find(ctx, databaseName, collectionName, query, queryOptions, appender)
+}
+
+func TestLoader(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	teamID, _ := db.Create(ctx, "test", "teams", bson.M{"name": "Core"})
+	adaID, _ := db.Create(ctx, "test", "users", bson.M{"name": "Ada", "team": model.DBRef{Ref: "teams", ID: *teamID}})
+	bobID, _ := db.Create(ctx, "test", "users", bson.M{"name": "Bob", "team": *teamID})
+	for _, author := range []primitive.ObjectID{*adaID, *bobID, *adaID} {
+		_, err := db.Create(ctx, "test", "posts", bson.M{
+			"author":   model.DBRef{Ref: "users", ID: author},
+			"watchers": bson.A{model.DBRef{Ref: "users", ID: *bobID}, model.DBRef{Ref: "users", ID: primitive.NewObjectID()}},
+			"meta":     bson.M{"editor": *adaID},
+		})
+		assert.Nil(t, err)
+	}
+
+	userFields := []model.Field{model.NewReference("team", "teams", true).ToField()}
+	fields := []model.Field{
+		model.NewReference("author", "users", true).SetFields(userFields).ToField(),
+		model.NewReference("watchers", "users", false).ToField(),
+		model.NewObject("meta", []model.Field{model.NewReference("editor", "users", true).ToField()}).ToField(),
+	}
+	var posts []*model.DataModel
+	assert.Nil(t, db.Find(ctx, "test", "posts", bson.M{}, database.FindOptions{}, func(cur *mongo.Cursor) error {
+		var post model.DataModel
+		posts = append(posts, &post)
+		return cur.Decode(&post)
+	}))
+
+	storage := &countingStorage{Storage: db}
+	loader := database.NewLoader(storage, "test")
+	assert.Nil(t, loader.Populate(ctx, posts, fields, 2))
+	// the users of the first level and the teams of the second level
+	assert.Equal(t, 2, storage.finds)
+
+	first := *posts[0]
+	assert.Equal(t, "Ada", first["author"].(model.DataModel)["name"])
+	assert.Equal(t, "Core", first["author"].(model.DataModel)["team"].(model.DataModel)["name"])
+	assert.Equal(t, "Core", (*posts[1])["author"].(model.DataModel)["team"].(model.DataModel)["name"])
+	assert.Len(t, first["watchers"], 1)
+	assert.Equal(t, "Bob", first["watchers"].(bson.A)[0].(model.DataModel)["name"])
+	assert.Equal(t, "Ada", first["meta"].(model.DataModel)["editor"].(model.DataModel)["name"])
+
+	// the cached documents are not loaded again
+	docs, err := loader.Load(ctx, []model.DBRef{{Ref: "users", ID: *bobID}, {Ref: "teams", ID: *teamID}})
+	assert.Nil(t, err)
+	assert.Equal(t, "Bob", docs[0]["name"])
+	assert.Equal(t, 2, storage.finds)
+
+	assert.Equal(t, core.NewError(http.StatusBadRequest, "max-depth-exceeded:3"), loader.Populate(ctx, posts, fields, 4))
+
+	// none of the documents of a failed call are cached
+	storage = &countingStorage{Storage: db, failing: "users"}
+	loader = database.NewLoader(storage, "test")
+	refs := []model.DBRef{{Ref: "users", ID: *adaID}, {Ref: "teams", ID: *teamID}}
+	_, err = loader.Load(ctx, refs)
+	assert.EqualError(t, err, "find failed")
+	storage.failing = ""
+	docs, err = loader.Load(ctx, refs)
+	assert.Nil(t, err)
+	assert.Equal(t, "Core", docs[1]["name"])
+	assert.Equal(t, 4, storage.finds)
+}
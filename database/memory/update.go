@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// applyUpdate applies the update operators to the document.
+func applyUpdate(doc bson.M, update bson.M) error {
+	if len(update) == 0 {
+		return fmt.Errorf("update document must have at least one element")
+	}
+
+	for operator, value := range update {
+		if !strings.HasPrefix(operator, "$") {
+			return fmt.Errorf("update document requires atomic operators")
+		}
+		fields, isDocument := value.(bson.M)
+		if !isDocument {
+			return fmt.Errorf("modifiers for %s must be a document", operator)
+		}
+
+		for path, operand := range fields {
+			if path == "_id" {
+				return fmt.Errorf("performing an update on the path '_id' would modify the immutable field '_id'")
+			}
+			if err := applyOperator(doc, operator, path, operand); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func applyOperator(doc bson.M, operator, path string, operand interface{}) error {
+	switch operator {
+	case "$set":
+		return setPath(doc, path, operand)
+	case "$setOnInsert":
+		// the documents are never inserted by an update
+		return nil
+	case "$unset":
+		unsetPath(doc, path)
+		return nil
+	case "$inc":
+		current, _ := getPath(doc, path)
+		sum, err := add(current, operand)
+		if err != nil {
+			return err
+		}
+		return setPath(doc, path, sum)
+	case "$currentDate":
+		return setPath(doc, path, primitive.NewDateTimeFromTime(time.Now()))
+	case "$push", "$addToSet":
+		current, has := getPath(doc, path)
+		array, isArray := current.(bson.A)
+		if has && current != nil && !isArray {
+			return fmt.Errorf("the field '%s' must be an array", path)
+		}
+
+		items := bson.A{operand}
+		if modifiers, hasModifiers := operand.(bson.M); hasModifiers {
+			if each, hasEach := modifiers["$each"].(bson.A); hasEach {
+				items = each
+			}
+		}
+
+		matcher := match.New(match.Options{})
+		for _, item := range items {
+			if operator == "$addToSet" && contains(array, item, matcher) {
+				continue
+			}
+			array = append(array, item)
+		}
+		return setPath(doc, path, array)
+	case "$pull":
+		current, _ := getPath(doc, path)
+		array, isArray := current.(bson.A)
+		if !isArray {
+			return nil
+		}
+
+		matcher := match.New(match.Options{})
+		kept := bson.A{}
+		for _, item := range array {
+			if !pulls(item, operand, matcher) {
+				kept = append(kept, item)
+			}
+		}
+		return setPath(doc, path, kept)
+	}
+	return fmt.Errorf("unknown modifier: %s", operator)
+}
+
+func contains(array bson.A, value interface{}, matcher *match.Matcher) bool {
+	for _, item := range array {
+		if matcher.Compare(item, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// pulls reports whether the item is removed by the $pull condition, which is either
+// a value, an operator document or a query on the fields of the item.
+func pulls(item, condition interface{}, matcher *match.Matcher) bool {
+	if conditionDocument, isDocument := condition.(bson.M); isDocument {
+		isQuery := true
+		for key := range conditionDocument {
+			if strings.HasPrefix(key, "$") {
+				isQuery = false
+			}
+		}
+		if isQuery {
+			if _, isItemDocument := item.(bson.M); isItemDocument {
+				matched, _ := matcher.Match(condition, item)
+				return matched
+			}
+			return false
+		}
+		matched, _ := matcher.Match(bson.M{"item": condition}, bson.M{"item": item})
+		return matched
+	}
+	return matcher.Compare(item, condition) == 0
+}
+
+// add adds the numbers by keeping the type of the operands like the $inc operator.
+func add(current, operand interface{}) (interface{}, error) {
+	if current == nil {
+		current = int32(0)
+	}
+
+	switch a := current.(type) {
+	case int32:
+		if b, isInt32 := operand.(int32); isInt32 {
+			sum := int64(a) + int64(b)
+			if sum == int64(int32(sum)) {
+				return int32(sum), nil
+			}
+			return sum, nil
+		}
+		if b, isInt64 := operand.(int64); isInt64 {
+			return int64(a) + b, nil
+		}
+	case int64:
+		switch b := operand.(type) {
+		case int32:
+			return a + int64(b), nil
+		case int64:
+			return a + b, nil
+		}
+	}
+
+	floatA, isNumberA := toFloat64(current)
+	floatB, isNumberB := toFloat64(operand)
+	if !isNumberA || !isNumberB {
+		return nil, fmt.Errorf("cannot apply $inc to a value of non-numeric type")
+	}
+	return floatA + floatB, nil
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case primitive.Decimal128:
+		f, _, err := big.ParseFloat(v.String(), 10, 64, big.ToNearestEven)
+		if err != nil {
+			return 0, false
+		}
+		result, _ := f.Float64()
+		return result, true
+	}
+	return 0, false
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+
+	"github.com/devingen/api-core/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Patch applies the JSON Patch or the JSON Merge Patch to the document with the id
+// and returns the patched document. The patched document is checked against the
+// fields before the update is written. See model.PatchDataModel.
+//
+// The document is read and updated in two steps that are not atomic, so the last
+// write wins: the test operations are checked against the document that is read,
+// and the paths that the patch sets, including the whole arrays whose elements are
+// inserted or removed, overwrite the changes that are made between the two steps.
+// The patches that must not overwrite concurrent changes should be serialized by
+// the caller.
+func (s *Database) Patch(ctx context.Context, databaseName, collectionName string, id primitive.ObjectID, fields []model.Field, patch model.Patch) (*model.DataModel, error) {
+	return Patch(ctx, s, databaseName, collectionName, id, fields, patch)
+}
+
+// Patch applies the patch to the document on the storage. See Database.Patch.
+func Patch(ctx context.Context, storage Storage, databaseName, collectionName string, id primitive.ObjectID, fields []model.Field, patch model.Patch) (*model.DataModel, error) {
+	var dm model.DataModel
+	if err := storage.Get(ctx, databaseName, collectionName, id.Hex(), &dm); err != nil {
+		return nil, err
+	}
+
+	patched, update, err := model.PatchDataModel(dm, patch, fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(update) == 0 {
+		return &patched, nil
+	}
+
+	var before model.DataModel
+	if err := storage.Update(ctx, databaseName, collectionName, id, &before, update); err != nil {
+		return nil, err
+	}
+	return &patched, nil
+}
@@ -0,0 +1,344 @@
+package sqldb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/devingen/api-core/internal/match"
+	"github.com/devingen/api-core/model"
+)
+
+var sqlOperators = map[model.Comparison]string{
+	model.ComparisonEq:  "=",
+	model.ComparisonNe:  "<>",
+	model.ComparisonLt:  "<",
+	model.ComparisonLte: "<=",
+	model.ComparisonGt:  ">",
+	model.ComparisonGte: ">=",
+}
+
+var columnNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// compiler compiles the query config into SQL by collecting the arguments.
+type compiler struct {
+	dialect Dialect
+	config  *model.QueryConfig
+	args    []interface{}
+}
+
+func (c *compiler) arg(value interface{}) string {
+	c.args = append(c.args, c.dialect.BindValue(value))
+	return c.dialect.Placeholder(len(c.args))
+}
+
+func (c *compiler) column(name string) (string, error) {
+	if !columnNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid column name %q, nested fields are not supported", name)
+	}
+	return c.dialect.Quote(name), nil
+}
+
+// Where compiles the filter into a parameterised condition for the WHERE clause.
+// The fields of the config are used to find the types of the filtered columns like
+// ToMatchQuery does.
+func Where(dialect Dialect, filter *model.Filter, config *model.QueryConfig) (string, []interface{}, error) {
+	c := &compiler{dialect: dialect, config: config}
+	condition, err := c.filter(*filter)
+	return condition, c.args, err
+}
+
+// OrderBy compiles the sort configs into the terms of the ORDER BY clause.
+func OrderBy(dialect Dialect, sort []model.SortConfig) (string, error) {
+	c := &compiler{dialect: dialect}
+	return c.orderBy(sort)
+}
+
+// Select compiles the query config into a parameterised SELECT query of the table.
+func Select(dialect Dialect, table string, config *model.QueryConfig) (string, []interface{}, error) {
+	if config.After != "" || config.Before != "" {
+		return "", nil, fmt.Errorf("cursor pagination is not supported")
+	}
+	if config.Search != nil {
+		return "", nil, fmt.Errorf("full-text search is not supported")
+	}
+
+	c := &compiler{dialect: dialect, config: config}
+	tableName, err := c.column(table)
+	if err != nil {
+		return "", nil, err
+	}
+
+	columns := "*"
+	if len(config.Select) > 0 {
+		selected := []string{dialect.Quote("_id")}
+		for _, path := range config.Select {
+			if path == "_id" {
+				continue
+			}
+			column, err := c.column(path)
+			if err != nil {
+				return "", nil, err
+			}
+			selected = append(selected, column)
+		}
+		columns = strings.Join(selected, ", ")
+	}
+
+	query := "SELECT " + columns + " FROM " + tableName
+	if config.Filter != nil {
+		condition, err := c.filter(*config.Filter)
+		if err != nil {
+			return "", nil, err
+		}
+		query += " WHERE " + condition
+	}
+	if len(config.Sort) > 0 {
+		orderBy, err := c.orderBy(config.Sort)
+		if err != nil {
+			return "", nil, err
+		}
+		query += " ORDER BY " + orderBy
+	}
+	query += dialect.LimitOffset(config.Limit, config.Skip)
+	return query, c.args, nil
+}
+
+func (c *compiler) orderBy(sort []model.SortConfig) (string, error) {
+	terms := make([]string, len(sort))
+	for i, s := range sort {
+		column, err := c.column(s.ID)
+		if err != nil {
+			return "", err
+		}
+		terms[i] = c.dialect.OrderBy(column, s.Order < 0)
+	}
+	return strings.Join(terms, ", "), nil
+}
+
+// filter compiles the filter with the same branches as ToMatchQuery.
+func (c *compiler) filter(f model.Filter) (string, error) {
+	if f.Filters != nil {
+		var operator string
+		switch f.Operator {
+		case model.OperatorAnd:
+			operator = " AND "
+		case model.OperatorOr:
+			operator = " OR "
+		default:
+			return "", fmt.Errorf("invalid operator %q", f.Operator)
+		}
+		if len(f.Filters) == 0 {
+			return "", fmt.Errorf("%s filter must have at least one filter", f.Operator)
+		}
+
+		conditions := make([]string, len(f.Filters))
+		for i, filter := range f.Filters {
+			condition, err := c.filter(filter)
+			if err != nil {
+				return "", err
+			}
+			conditions[i] = condition
+		}
+		return "(" + strings.Join(conditions, operator) + ")", nil
+	}
+
+	column, err := c.column(f.FieldId)
+	if err != nil {
+		return "", err
+	}
+
+	field := c.config.GetField(f.FieldId)
+	if field != nil && !supportsComparison(field.GetType(), f.Comparison) {
+		// ex: the select fields are stored as text but they can't be searched
+		return "", fmt.Errorf("%s comparison is not supported by %s field %q", f.Comparison, field.GetType(), f.FieldId)
+	}
+
+	switch f.Comparison {
+	case model.ComparisonNotEmpty:
+		return column + " IS NOT NULL", nil
+	case model.ComparisonEmpty, model.ComparisonIsNull:
+		// the columns can't be missing, so the empty and null values are the same
+		return column + " IS NULL", nil
+	case model.ComparisonStartsWith, model.ComparisonEndsWith:
+		return c.like(column, f.Comparison, f.FieldValue)
+	}
+
+	if field == nil {
+		if f.Comparison == model.ComparisonContain {
+			return c.like(column, model.ComparisonContain, f.FieldValue)
+		}
+		if f.Comparison == model.ComparisonEqMongoOID {
+			return c.compare(column, model.ComparisonEq, f.FieldValue)
+		}
+		return c.compare(column, f.Comparison, f.FieldValue)
+	}
+	if field.GetType() == model.FieldTypeFormula || field.GetType() == model.FieldTypeRollup ||
+		field.GetType() == model.FieldTypeMultiSelect || field.GetType() == model.FieldTypeObject ||
+		field.GetType() == model.FieldTypeArray {
+		return "", fmt.Errorf("%s field %q is not supported", field.GetType(), f.FieldId)
+	}
+
+	// the registered types are compiled like the built-in type whose values they store
+	switch storedAs(field.GetType()) {
+	case model.FieldTypeText:
+		if f.Comparison == model.ComparisonContain {
+			return c.like(column, model.ComparisonContain, f.FieldValue)
+		}
+		if f.Comparison == model.ComparisonNcontain {
+			return c.like(column, model.ComparisonNcontain, f.FieldValue)
+		}
+		return c.compare(column, f.Comparison, f.FieldValue)
+	case model.FieldTypeNumber:
+		value, err := toNumber(f.FieldValue, model.NumberFromField(*field).GetFormat())
+		if err != nil {
+			return "", err
+		}
+		return c.compare(column, f.Comparison, value)
+	case model.FieldTypeBoolean:
+		return c.compare(column, f.Comparison, f.FieldValue)
+	case model.FieldTypeDate:
+		if start, end, isRange := f.DateRange(c.config); isRange {
+			if f.Comparison == model.ComparisonDateNeDay {
+				return "(" + column + " < " + c.arg(start) + " OR " + column + " > " + c.arg(end) + ")", nil
+			}
+			return column + " BETWEEN " + c.arg(start) + " AND " + c.arg(end), nil
+		}
+		if f.Comparison == model.ComparisonBetween {
+			items, _ := match.AsArray(f.FieldValue)
+			times := make([]interface{}, len(items))
+			for i, item := range items {
+				value, _ := item.(string)
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					return "", fmt.Errorf("invalid date %v", item)
+				}
+				times[i] = t
+			}
+			return c.compare(column, f.Comparison, times)
+		}
+
+		if value, isString := f.FieldValue.(string); isString {
+			if t, err := time.Parse(time.RFC3339, value); err == nil {
+				return c.compare(column, f.Comparison, t)
+			}
+		}
+	}
+	if f.Comparison == model.ComparisonNe {
+		return c.compare(column, model.ComparisonNe, f.FieldValue)
+	}
+	return c.like(column, model.ComparisonContain, f.FieldValue)
+}
+
+// supportsComparison returns true if the comparison is one of the Comparisons of the
+// definition of the field type, or if the type doesn't define its comparisons.
+func supportsComparison(fieldType model.FieldType, comparison model.Comparison) bool {
+	definition, _ := model.GetFieldTypeDefinition(fieldType)
+	if definition.Comparisons == nil {
+		return true
+	}
+	for _, c := range definition.Comparisons {
+		if c == comparison {
+			return true
+		}
+	}
+	return false
+}
+
+// compare compiles the comparison of the column with the value. The null values are
+// handled like MongoDB does: eq null matches the nulls and ne matches the nulls.
+func (c *compiler) compare(column string, comparison model.Comparison, value interface{}) (string, error) {
+	if comparison == model.ComparisonIn || comparison == model.ComparisonNin {
+		items, isArray := match.AsArray(value)
+		if !isArray {
+			return "", fmt.Errorf("%s comparison requires an array value", comparison)
+		}
+		if len(items) == 0 {
+			if comparison == model.ComparisonNin {
+				return "1 = 1", nil
+			}
+			return "1 = 0", nil
+		}
+		placeholders := make([]string, len(items))
+		for i, item := range items {
+			placeholders[i] = c.arg(item)
+		}
+		if comparison == model.ComparisonNin {
+			return "(" + column + " IS NULL OR " + column + " NOT IN (" + strings.Join(placeholders, ", ") + "))", nil
+		}
+		return column + " IN (" + strings.Join(placeholders, ", ") + ")", nil
+	}
+	if comparison == model.ComparisonBetween {
+		items, isArray := match.AsArray(value)
+		if !isArray || len(items) != 2 {
+			return "", fmt.Errorf("between comparison requires an array of two values")
+		}
+		return column + " BETWEEN " + c.arg(items[0]) + " AND " + c.arg(items[1]), nil
+	}
+
+	operator, isSupported := sqlOperators[comparison]
+	if !isSupported {
+		return "", fmt.Errorf("unsupported comparison %q", comparison)
+	}
+
+	if value == nil {
+		switch comparison {
+		case model.ComparisonEq:
+			return column + " IS NULL", nil
+		case model.ComparisonNe:
+			return column + " IS NOT NULL", nil
+		}
+		return "1 = 0", nil
+	}
+	if comparison == model.ComparisonNe {
+		return "(" + column + " IS NULL OR " + column + " <> " + c.arg(value) + ")", nil
+	}
+	return column + " " + operator + " " + c.arg(value), nil
+}
+
+// likePatterns contains the LIKE patterns of the text comparisons.
+var likePatterns = map[model.Comparison]string{
+	model.ComparisonContain:    "%%%s%%",
+	model.ComparisonNcontain:   "%%%s%%",
+	model.ComparisonStartsWith: "%s%%",
+	model.ComparisonEndsWith:   "%%%s",
+}
+
+// like compiles the case-insensitive text search of the comparison.
+func (c *compiler) like(column string, comparison model.Comparison, value interface{}) (string, error) {
+	text, isString := value.(string)
+	if !isString {
+		return "", fmt.Errorf("%s comparison requires a text value", comparison)
+	}
+
+	condition := c.dialect.ContainsInsensitive(column, c.arg(fmt.Sprintf(likePatterns[comparison], escapeLike(text))))
+	if comparison == model.ComparisonNcontain {
+		return "NOT (" + condition + ")", nil
+	}
+	return condition, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// toNumber converts the value or the items of the array value into numbers like
+// ToMatchQuery does.
+func toNumber(value interface{}, format model.NumberFormat) (interface{}, error) {
+	if items, isArray := match.AsArray(value); isArray {
+		numbers := make([]interface{}, len(items))
+		for i, item := range items {
+			n, err := toNumber(item, format)
+			if err != nil {
+				return nil, err
+			}
+			numbers[i] = n
+		}
+		return numbers, nil
+	}
+	if n, isNumber := model.ParseNumber(value, format); isNumber {
+		return n, nil
+	}
+	return nil, fmt.Errorf("invalid number %v", value)
+}
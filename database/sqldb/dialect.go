@@ -0,0 +1,209 @@
+package sqldb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devingen/api-core/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Dialect contains the differences of the SQL databases.
+type Dialect interface {
+	// Placeholder returns the placeholder of the argument at the 1-based index.
+	Placeholder(index int) string
+
+	// Quote quotes the identifier of a table or column.
+	Quote(identifier string) string
+
+	// ContainsInsensitive returns the condition that checks whether the column
+	// matches the LIKE pattern case-insensitively.
+	ContainsInsensitive(column, placeholder string) string
+
+	// OrderBy returns the ORDER BY term of the column.
+	OrderBy(column string, descending bool) string
+
+	// LimitOffset returns the LIMIT and OFFSET clauses. Zero values are omitted.
+	LimitOffset(limit, skip int) string
+
+	// ColumnType returns the column type that stores the values of the field type.
+	ColumnType(fieldType model.FieldType) (string, error)
+
+	// BindValue converts the value to the type that is stored in the column.
+	BindValue(value interface{}) interface{}
+
+	// ScanValue converts the value read from the column into the type of the field.
+	ScanValue(columnType string, value interface{}) interface{}
+}
+
+var (
+	// SQLite stores the dates as UTC text that sorts chronologically and the
+	// booleans as integers.
+	SQLite Dialect = sqlite{}
+
+	// Postgres uses the native types of PostgreSQL.
+	Postgres Dialect = postgres{}
+)
+
+// sqliteTimeFormat is a fixed-width format so the dates can be compared as text.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+type sqlite struct{}
+
+func (sqlite) Placeholder(index int) string {
+	return "?"
+}
+
+func (sqlite) Quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func (sqlite) ContainsInsensitive(column, placeholder string) string {
+	// LIKE is case-insensitive in SQLite
+	return column + " LIKE " + placeholder + ` ESCAPE '\'`
+}
+
+func (sqlite) OrderBy(column string, descending bool) string {
+	if descending {
+		return column + " DESC"
+	}
+	return column + " ASC"
+}
+
+func (sqlite) LimitOffset(limit, skip int) string {
+	clause := ""
+	if limit > 0 {
+		clause = " LIMIT " + strconv.Itoa(limit)
+	} else if skip > 0 {
+		// OFFSET requires a LIMIT in SQLite
+		clause = " LIMIT -1"
+	}
+	if skip > 0 {
+		clause += " OFFSET " + strconv.Itoa(skip)
+	}
+	return clause
+}
+
+func (sqlite) ColumnType(fieldType model.FieldType) (string, error) {
+	switch fieldType {
+	case model.FieldTypeText:
+		return "TEXT", nil
+	case model.FieldTypeNumber:
+		return "REAL", nil
+	case model.FieldTypeBoolean:
+		return "BOOLEAN", nil
+	case model.FieldTypeDate:
+		return "TIMESTAMP", nil
+	}
+	return "", fmt.Errorf("field type %s cannot be stored in a column", fieldType)
+}
+
+func (sqlite) BindValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(sqliteTimeFormat)
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	return bindCommonValue(value, sqlite{})
+}
+
+func (sqlite) ScanValue(columnType string, value interface{}) interface{} {
+	switch strings.ToUpper(columnType) {
+	case "BOOLEAN":
+		if i, isInt := value.(int64); isInt {
+			return i != 0
+		}
+	case "TIMESTAMP":
+		if s, isString := value.(string); isString {
+			if t, err := time.Parse(sqliteTimeFormat, s); err == nil {
+				return t
+			}
+		}
+		if t, isTime := value.(time.Time); isTime {
+			return t.UTC()
+		}
+	}
+	return scanCommonValue(value)
+}
+
+type postgres struct{}
+
+func (postgres) Placeholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+
+func (postgres) Quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func (postgres) ContainsInsensitive(column, placeholder string) string {
+	return column + " ILIKE " + placeholder + ` ESCAPE '\'`
+}
+
+func (postgres) OrderBy(column string, descending bool) string {
+	// sort the nulls like MongoDB does, they are the smallest values
+	if descending {
+		return column + " DESC NULLS LAST"
+	}
+	return column + " ASC NULLS FIRST"
+}
+
+func (postgres) LimitOffset(limit, skip int) string {
+	clause := ""
+	if limit > 0 {
+		clause = " LIMIT " + strconv.Itoa(limit)
+	}
+	if skip > 0 {
+		clause += " OFFSET " + strconv.Itoa(skip)
+	}
+	return clause
+}
+
+func (postgres) ColumnType(fieldType model.FieldType) (string, error) {
+	switch fieldType {
+	case model.FieldTypeText:
+		return "TEXT", nil
+	case model.FieldTypeNumber:
+		return "DOUBLE PRECISION", nil
+	case model.FieldTypeBoolean:
+		return "BOOLEAN", nil
+	case model.FieldTypeDate:
+		return "TIMESTAMPTZ", nil
+	}
+	return "", fmt.Errorf("field type %s cannot be stored in a column", fieldType)
+}
+
+func (postgres) BindValue(value interface{}) interface{} {
+	return bindCommonValue(value, postgres{})
+}
+
+func (postgres) ScanValue(columnType string, value interface{}) interface{} {
+	return scanCommonValue(value)
+}
+
+func bindCommonValue(value interface{}, dialect Dialect) interface{} {
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.DateTime:
+		return dialect.BindValue(v.Time())
+	case primitive.Decimal128:
+		return v.String()
+	case int32:
+		return int64(v)
+	}
+	return value
+}
+
+func scanCommonValue(value interface{}) interface{} {
+	if b, isBytes := value.([]byte); isBytes {
+		return string(b)
+	}
+	return value
+}
@@ -0,0 +1,334 @@
+// Package sqldb stores the documents in the tables of a SQL database and queries
+// them with the filters and query configs of the model package.
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/devingen/api-core/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	_ "modernc.org/sqlite"
+)
+
+// Database keeps the documents in the tables whose columns are the fields of the
+// documents. The _id column keeps the hex of the ObjectID of the document.
+type Database struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+func New(db *sql.DB, dialect Dialect) *Database {
+	return &Database{
+		DB:      db,
+		Dialect: dialect,
+	}
+}
+
+// OpenSQLite opens the SQLite database at the path. Use ":memory:" for a database
+// that lives as long as the returned Database, which is handy for the tests.
+func OpenSQLite(dataSourceName string) (*Database, error) {
+	db, err := sql.Open("sqlite", dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer and each connection of ":memory:" has its own database
+	db.SetMaxOpenConns(1)
+	return New(db, SQLite), nil
+}
+
+// CreateTable creates the table with a column for each field if it doesn't exist.
+func (s *Database) CreateTable(ctx context.Context, table string, fields []model.Field) error {
+	c := &compiler{dialect: s.Dialect}
+	tableName, err := c.column(table)
+	if err != nil {
+		return err
+	}
+
+	columns := []string{c.dialect.Quote("_id") + " TEXT PRIMARY KEY"}
+	for _, field := range fields {
+		column, err := c.column(field.GetID())
+		if err != nil {
+			return err
+		}
+		columnType, err := s.Dialect.ColumnType(storedAs(field.GetType()))
+		if err != nil {
+			return err
+		}
+		columns = append(columns, column+" "+columnType)
+	}
+
+	_, err = s.DB.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+tableName+" ("+strings.Join(columns, ", ")+")")
+	return err
+}
+
+// storedAs returns the built-in type whose values the fields of the type store, or the
+// type itself if it's not registered with one. See model.FieldTypeDefinition.StoredAs.
+func storedAs(fieldType model.FieldType) model.FieldType {
+	if definition, _ := model.GetFieldTypeDefinition(fieldType); definition.StoredAs != "" {
+		return definition.StoredAs
+	}
+	return fieldType
+}
+
+func (s *Database) Get(ctx context.Context, table, id string, item interface{}) error {
+	oID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	return s.FindOne(ctx, table, &model.QueryConfig{
+		Filter: &model.Filter{Comparison: model.ComparisonEq, FieldId: "_id", FieldValue: oID},
+	}, item)
+}
+
+// FindOne decodes the first document that matches the query config into the item.
+// It returns sql.ErrNoRows if there isn't any.
+func (s *Database) FindOne(ctx context.Context, table string, config *model.QueryConfig, item interface{}) error {
+	configWithLimit := *config
+	configWithLimit.Limit = 1
+
+	results, err := s.Find(ctx, table, &configWithLimit)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return sql.ErrNoRows
+	}
+	return decode(*results[0], item)
+}
+
+// Find returns the documents that match the filter of the query config with its
+// sort, skip and limit.
+func (s *Database) Find(ctx context.Context, table string, config *model.QueryConfig) ([]*model.DataModel, error) {
+	query, args, err := Select(s.Dialect, table, config)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.DataModel, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(columnTypes))
+		pointers := make([]interface{}, len(columnTypes))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		data := model.DataModel{}
+		for i, columnType := range columnTypes {
+			if values[i] == nil {
+				continue
+			}
+			data[columnType.Name()] = s.Dialect.ScanValue(columnType.DatabaseTypeName(), values[i])
+		}
+		if id, isString := data["_id"].(string); isString {
+			if oID, err := primitive.ObjectIDFromHex(id); err == nil {
+				data["_id"] = oID
+			}
+		}
+		result = append(result, &data)
+	}
+	return result, rows.Err()
+}
+
+// Create inserts the item into the table. The fields of the item are converted with
+// their bson tags like they are in MongoDB. An _id is generated if it's missing.
+func (s *Database) Create(ctx context.Context, table string, item interface{}) (*primitive.ObjectID, error) {
+	doc, err := toDocument(item)
+	if err != nil {
+		return nil, err
+	}
+	if _, hasID := doc["_id"]; !hasID {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	id, isObjectID := doc["_id"].(primitive.ObjectID)
+	if !isObjectID {
+		return nil, fmt.Errorf("_id of the item must be an ObjectID")
+	}
+
+	c := &compiler{dialect: s.Dialect}
+	tableName, err := c.column(table)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(doc))
+	placeholders := make([]string, 0, len(doc))
+	for _, key := range sortedKeys(doc) {
+		column, err := c.column(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := assertScalar(key, doc[key]); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+		placeholders = append(placeholders, c.arg(doc[key]))
+	}
+
+	query := "INSERT INTO " + tableName + " (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+	if _, err := s.DB.ExecContext(ctx, query, c.args...); err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// Update sets the columns of the document to the values of the data. The data can
+// be the new values of the columns or an update document with $set and $unset like
+// the one passed to Database.Update. It returns sql.ErrNoRows if there isn't such
+// a document.
+func (s *Database) Update(ctx context.Context, table, id string, data interface{}) error {
+	oID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	doc, err := toDocument(data)
+	if err != nil {
+		return err
+	}
+	values, err := updatedValues(doc)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("update document must have at least one field")
+	}
+
+	c := &compiler{dialect: s.Dialect}
+	tableName, err := c.column(table)
+	if err != nil {
+		return err
+	}
+
+	assignments := make([]string, 0, len(values))
+	for _, key := range sortedKeys(values) {
+		if key == "_id" {
+			return fmt.Errorf("_id of the document cannot be updated")
+		}
+		column, err := c.column(key)
+		if err != nil {
+			return err
+		}
+		if err := assertScalar(key, values[key]); err != nil {
+			return err
+		}
+		assignments = append(assignments, column+" = "+c.arg(values[key]))
+	}
+
+	query := "UPDATE " + tableName + " SET " + strings.Join(assignments, ", ") + " WHERE " + c.dialect.Quote("_id") + " = " + c.arg(oID)
+	result, err := s.DB.ExecContext(ctx, query, c.args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete deletes the document and returns the number of deleted documents.
+func (s *Database) Delete(ctx context.Context, table, id string) (int64, error) {
+	oID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, err
+	}
+
+	c := &compiler{dialect: s.Dialect}
+	tableName, err := c.column(table)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM "+tableName+" WHERE "+c.dialect.Quote("_id")+" = "+c.arg(oID), c.args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// updatedValues returns the new values of the columns from the update document.
+// The unset columns are set to null.
+func updatedValues(doc bson.M) (bson.M, error) {
+	values := bson.M{}
+	for key, value := range doc {
+		if !strings.HasPrefix(key, "$") {
+			values[key] = value
+			continue
+		}
+
+		fields, isDocument := value.(bson.M)
+		if !isDocument {
+			return nil, fmt.Errorf("modifiers for %s must be a document", key)
+		}
+		for field, fieldValue := range fields {
+			switch key {
+			case "$set":
+				values[field] = fieldValue
+			case "$unset":
+				values[field] = nil
+			default:
+				return nil, fmt.Errorf("unsupported update operator %s", key)
+			}
+		}
+	}
+	return values, nil
+}
+
+func assertScalar(key string, value interface{}) error {
+	switch value.(type) {
+	case bson.M, bson.A, bson.D:
+		return fmt.Errorf("the value of %s must be a scalar to be stored in a column", key)
+	}
+	return nil
+}
+
+func toDocument(value interface{}) (bson.M, error) {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	err = bson.Unmarshal(data, &doc)
+	return doc, err
+}
+
+func decode(doc model.DataModel, item interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, item)
+}
+
+func sortedKeys(doc bson.M) []string {
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,153 @@
+package sqldb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devingen/api-core/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var fields = []model.Field{
+	model.New(model.FieldTypeText, "name"),
+	model.New(model.FieldTypeNumber, "price"),
+	model.New(model.FieldTypeBoolean, "active"),
+	model.New(model.FieldTypeDate, "createdAt"),
+}
+
+func TestSelectPostgres(t *testing.T) {
+	query, args, err := Select(Postgres, "products", &model.QueryConfig{
+		Fields: fields,
+		Filter: &model.Filter{Operator: model.OperatorOr, Filters: []model.Filter{
+			{Comparison: model.ComparisonContain, FieldId: "name", FieldValue: "50%_off"},
+			{Comparison: model.ComparisonGte, FieldId: "price", FieldValue: "9.99"},
+			{Comparison: model.ComparisonNe, FieldId: "active", FieldValue: true},
+		}},
+		Sort:  []model.SortConfig{{ID: "price", Order: -1}},
+		Limit: 10,
+		Skip:  20,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `SELECT * FROM "products" WHERE ("name" ILIKE $1 ESCAPE '\' OR "price" >= $2 OR ("active" IS NULL OR "active" <> $3)) ORDER BY "price" DESC NULLS LAST LIMIT 10 OFFSET 20`, query)
+	assert.Equal(t, []interface{}{`%50\%\_off%`, 9.99, true}, args)
+
+	query, _, err = Select(Postgres, "products", &model.QueryConfig{Fields: fields, Select: []string{"name", "price"}})
+	assert.Nil(t, err)
+	assert.Equal(t, `SELECT "_id", "name", "price" FROM "products"`, query)
+
+	_, _, err = Select(Postgres, "products", &model.QueryConfig{
+		Filter: &model.Filter{Comparison: model.ComparisonEq, FieldId: "owner.name", FieldValue: "x"},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestSelectRegisteredType(t *testing.T) {
+	// the email type is a copy of the text type
+	email, _ := model.GetFieldTypeDefinition(model.FieldTypeText)
+	model.RegisterFieldType("test-sql-email", email)
+	t.Cleanup(func() { model.UnregisterFieldType("test-sql-email") })
+
+	query, args, err := Select(Postgres, "users", &model.QueryConfig{
+		Fields: []model.Field{model.New("test-sql-email", "email")},
+		Filter: &model.Filter{Comparison: model.ComparisonEq, FieldId: "email", FieldValue: "ada@example.com"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `SELECT * FROM "users" WHERE "email" = $1`, query)
+	assert.Equal(t, []interface{}{"ada@example.com"}, args)
+
+	columnType, err := Postgres.ColumnType(storedAs("test-sql-email"))
+	assert.Nil(t, err)
+	assert.Equal(t, "TEXT", columnType)
+}
+
+func TestSQLite(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(":memory:")
+	assert.Nil(t, err)
+	assert.Nil(t, db.CreateTable(ctx, "products", fields))
+
+	now := time.Now()
+	id, err := db.Create(ctx, "products", bson.M{"name": "Apple", "price": 1.5, "active": true, "createdAt": now})
+	assert.Nil(t, err)
+	_, err = db.Create(ctx, "products", bson.M{"name": "Banana", "price": 0.25, "createdAt": now.AddDate(-2, 0, 0)})
+	assert.Nil(t, err)
+	_, err = db.Create(ctx, "products", bson.M{"name": "Cherry", "price": 10})
+	assert.Nil(t, err)
+
+	find := func(filter model.Filter) []string {
+		results, err := db.Find(ctx, "products", &model.QueryConfig{
+			Fields: fields,
+			Filter: &filter,
+			Sort:   []model.SortConfig{{ID: "name", Order: 1}},
+		})
+		assert.Nil(t, err)
+		names := make([]string, len(results))
+		for i, result := range results {
+			names[i] = result.GetString("name")
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"Banana"}, find(model.Filter{Comparison: model.ComparisonContain, FieldId: "name", FieldValue: "AN"}))
+	assert.Equal(t, []string{"Apple", "Cherry"}, find(model.Filter{Comparison: model.ComparisonGt, FieldId: "price", FieldValue: 1}))
+	assert.Equal(t, []string{"Banana", "Cherry"}, find(model.Filter{Comparison: model.ComparisonNe, FieldId: "active", FieldValue: true}))
+	assert.Equal(t, []string{"Apple"}, find(model.Filter{Comparison: model.ComparisonDateThisYear, FieldId: "createdAt"}))
+	assert.Equal(t, []string{"Cherry"}, find(model.Filter{Comparison: model.ComparisonEmpty, FieldId: "createdAt"}))
+	assert.Equal(t, []string{"Apple", "Banana"}, find(model.Filter{Comparison: model.ComparisonBetween, FieldId: "price", FieldValue: []interface{}{0.25, "1.5"}}))
+	assert.Equal(t, []string{"Banana", "Cherry"}, find(model.Filter{Comparison: model.ComparisonNin, FieldId: "name", FieldValue: []interface{}{"Apple"}}))
+	assert.Equal(t, []string{"Cherry"}, find(model.Filter{Comparison: model.ComparisonStartsWith, FieldId: "name", FieldValue: "ch"}))
+	assert.Equal(t, []string{"Apple"}, find(model.Filter{Comparison: model.ComparisonEndsWith, FieldId: "name", FieldValue: "LE"}))
+
+	assert.Nil(t, db.Update(ctx, "products", id.Hex(), bson.M{"$set": bson.M{"price": 2}, "$unset": bson.M{"active": ""}}))
+
+	var product struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		Price     float64            `bson:"price"`
+		Active    *bool              `bson:"active"`
+		CreatedAt time.Time          `bson:"createdAt"`
+	}
+	assert.Nil(t, db.Get(ctx, "products", id.Hex(), &product))
+	assert.Equal(t, *id, product.ID)
+	assert.Equal(t, 2.0, product.Price)
+	assert.Nil(t, product.Active)
+	assert.Equal(t, now.UnixMilli(), product.CreatedAt.UnixMilli())
+
+	deleted, err := db.Delete(ctx, "products", id.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
+
+func TestSQLiteSelect(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(":memory:")
+	assert.Nil(t, err)
+	tasks := []model.Field{
+		model.New(model.FieldTypeText, "title"),
+		model.NewSelect("status", []model.SelectOption{{Value: "open"}, {Value: "done"}}).ToField(),
+	}
+	assert.Nil(t, db.CreateTable(ctx, "tasks", tasks))
+
+	_, err = db.Create(ctx, "tasks", bson.M{"title": "Write", "status": "open"})
+	assert.Nil(t, err)
+	_, err = db.Create(ctx, "tasks", bson.M{"title": "Read", "status": "done"})
+	assert.Nil(t, err)
+
+	results, err := db.Find(ctx, "tasks", &model.QueryConfig{
+		Fields: tasks,
+		Filter: &model.Filter{Comparison: model.ComparisonIn, FieldId: "status", FieldValue: []interface{}{"open"}},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "Write", results[0].GetString("title"))
+	assert.Equal(t, "open", results[0].GetString("status"))
+
+	// the select values are stored as text but they can't be searched
+	_, err = db.Find(ctx, "tasks", &model.QueryConfig{
+		Fields: tasks,
+		Filter: &model.Filter{Comparison: model.ComparisonContain, FieldId: "status", FieldValue: "o"},
+	})
+	assert.NotNil(t, err)
+}
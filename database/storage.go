@@ -0,0 +1,24 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Storage contains the data access methods of Database. The controllers and services
+// that depend on Storage instead of Database can be tested without a MongoDB server
+// by using the in-memory implementation in the memory package.
+type Storage interface {
+	Aggregate(ctx context.Context, databaseName, collectionName string, condition []bson.M, appender Appender) error
+	Get(ctx context.Context, databaseName, collectionName, id string, item interface{}) error
+	Create(ctx context.Context, databaseName, collectionName string, item interface{}) (*primitive.ObjectID, error)
+	Update(ctx context.Context, databaseName, collectionName string, id primitive.ObjectID, result interface{}, data interface{}) error
+	Delete(ctx context.Context, databaseName, collectionName string, id string) (*mongo.DeleteResult, error)
+	FindOne(ctx context.Context, databaseName, collectionName string, query bson.M, item interface{}) error
+	Find(ctx context.Context, databaseName, collectionName string, query bson.M, queryOptions FindOptions, appender Appender) error
+}
+
+var _ Storage = (*Database)(nil)
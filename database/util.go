@@ -3,15 +3,17 @@ package database
 import (
 	"context"
 
+	"github.com/devingen/api-core/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type appender func(cur *mongo.Cursor) error
+// Appender is called for each document of the cursor returned by the queries.
+type Appender func(cur *mongo.Cursor) error
 
-func (s *Database) Aggregate(ctx context.Context, databaseName, collectionName string, condition []bson.M, appender appender) error {
+func (s *Database) Aggregate(ctx context.Context, databaseName, collectionName string, condition []bson.M, appender Appender) error {
 	collection, err := s.ConnectToCollection(databaseName, collectionName)
 	if err != nil {
 		return err
@@ -59,6 +61,111 @@ func (s *Database) AggregateMap(ctx context.Context, databaseName, collectionNam
 	return result, err
 }
 
+// Query runs the aggregation pipeline generated from the query config and returns
+// the documents.
+func (s *Database) Query(ctx context.Context, databaseName, collectionName string, config *model.QueryConfig) ([]*model.DataModel, error) {
+
+	pipeline, err := config.ToPipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.DataModel, 0)
+	err = s.Aggregate(ctx, databaseName, collectionName, pipeline, func(cur *mongo.Cursor) error {
+		var data model.DataModel
+		err := cur.Decode(&data)
+		if err != nil {
+			return err
+		}
+		result = append(result, &data)
+		return nil
+	})
+	return result, err
+}
+
+// List runs the pipeline generated by QueryConfig.ToListPipeline and returns the page
+// of the documents with the total number of the documents that match the filter.
+func (s *Database) List(ctx context.Context, databaseName, collectionName string, config *model.QueryConfig) ([]*model.DataModel, *model.Meta, error) {
+	return List(ctx, s, databaseName, collectionName, config)
+}
+
+// List runs the list pipeline of the config on the storage. See Database.List.
+func List(ctx context.Context, storage Storage, databaseName, collectionName string, config *model.QueryConfig) ([]*model.DataModel, *model.Meta, error) {
+	pipeline, err := config.ToListPipeline()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result model.ListResult
+	err = storage.Aggregate(ctx, databaseName, collectionName, pipeline, func(cur *mongo.Cursor) error {
+		return cur.Decode(&result)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.Results == nil {
+		result.Results = make([]*model.DataModel, 0)
+	}
+	return result.Results, result.GetMeta(), nil
+}
+
+// Group runs the pipeline generated by QueryConfig.ToGroupPipeline and returns the
+// groups of the documents that match the filter.
+func (s *Database) Group(ctx context.Context, databaseName, collectionName string, config *model.QueryConfig, group model.GroupConfig) ([]*model.Group, error) {
+	return Group(ctx, s, databaseName, collectionName, config, group)
+}
+
+// Group runs the group pipeline of the config on the storage. See Database.Group.
+func Group(ctx context.Context, storage Storage, databaseName, collectionName string, config *model.QueryConfig, group model.GroupConfig) ([]*model.Group, error) {
+	pipeline, err := config.ToGroupPipeline(group)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Group, 0)
+	err = storage.Aggregate(ctx, databaseName, collectionName, pipeline, func(cur *mongo.Cursor) error {
+		var g model.Group
+		err := cur.Decode(&g)
+		if err != nil {
+			return err
+		}
+		result = append(result, &g)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Facets runs the pipeline generated by QueryConfig.ToFacetPipeline and returns the
+// groups of each facet by the names of the facets in a single query.
+func (s *Database) Facets(ctx context.Context, databaseName, collectionName string, config *model.QueryConfig, facets map[string]model.GroupConfig) (map[string][]*model.Group, error) {
+	return Facets(ctx, s, databaseName, collectionName, config, facets)
+}
+
+// Facets runs the facet pipeline of the config on the storage. See Database.Facets.
+func Facets(ctx context.Context, storage Storage, databaseName, collectionName string, config *model.QueryConfig, facets map[string]model.GroupConfig) (map[string][]*model.Group, error) {
+	pipeline, err := config.ToFacetPipeline(facets)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string][]*model.Group{}
+	err = storage.Aggregate(ctx, databaseName, collectionName, pipeline, func(cur *mongo.Cursor) error {
+		return cur.Decode(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range facets {
+		if result[name] == nil {
+			result[name] = make([]*model.Group, 0)
+		}
+	}
+	return result, nil
+}
+
 func (s *Database) Get(ctx context.Context, databaseName, collectionName, id string, item interface{}) error {
 	oID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -125,7 +232,7 @@ type FindOptions struct {
 	Skip  int64
 }
 
-func (s *Database) Find(ctx context.Context, databaseName, collectionName string, query bson.M, queryOptions FindOptions, appender appender) error {
+func (s *Database) Find(ctx context.Context, databaseName, collectionName string, query bson.M, queryOptions FindOptions, appender Appender) error {
 	collection, err := s.ConnectToCollection(databaseName, collectionName)
 	if err != nil {
 		return err
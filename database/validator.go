@@ -0,0 +1,38 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/devingen/api-core/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SetValidator installs the validator of the fields on the collection with
+// collMod, so the writes that don't go through model.Validate, such as the ones of
+// the scripts, are rejected if the documents are invalid. The existing documents are
+// not checked. The policy is the one that the documents are validated with, so the
+// unknown keys are rejected only if it rejects them. The collection is created with
+// the validator if it doesn't exist.
+func (s *Database) SetValidator(ctx context.Context, databaseName, collectionName string, fields []model.Field, policy model.UnknownFieldPolicy) error {
+	validator := model.MongoValidator(fields, policy)
+	db := s.Client.Database(databaseName)
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: collectionName},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: "strict"},
+		{Key: "validationAction", Value: "error"},
+	}).Err()
+
+	// NamespaceNotFound
+	var commandError mongo.CommandError
+	if !errors.As(err, &commandError) || commandError.Code != 26 {
+		return err
+	}
+	return db.CreateCollection(ctx, collectionName, options.CreateCollection().
+		SetValidator(validator).
+		SetValidationLevel("strict").
+		SetValidationAction("error"))
+}
@@ -1,9 +1,16 @@
 package dto
 
-import "time"
+import (
+	"time"
+
+	"github.com/devingen/api-core/model"
+)
 
 type GetListResponse struct {
-	Results interface{} `json:"results"`
+	Results    interface{} `json:"results"`
+	Meta       *model.Meta `json:"meta,omitempty"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	PrevCursor string      `json:"prevCursor,omitempty"`
 }
 
 type UpdateEntryResponse struct {
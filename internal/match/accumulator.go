@@ -0,0 +1,73 @@
+package match
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Accumulate computes the $group accumulator over the values of a group, which are
+// the values of the accumulator's expression for the documents of the group. The
+// nulls and the missing values are skipped except by $first and $last. $sum, $avg,
+// $min, $max, $first, $last, $push and $addToSet are supported.
+func (m *Matcher) Accumulate(operator string, values []interface{}) (interface{}, error) {
+	switch operator {
+	case "$sum", "$avg":
+		numbers := bson.A{}
+		for _, value := range values {
+			if _, _, _, isNumber := numberValue(value); isNumber {
+				numbers = append(numbers, value)
+			}
+		}
+		if len(numbers) == 0 {
+			if operator == "$sum" {
+				return int64(0), nil
+			}
+			return nil, nil
+		}
+		sum, err := arithmetic("$add", numbers)
+		if err != nil || operator == "$sum" {
+			return sum, err
+		}
+		return arithmetic("$divide", bson.A{sum, int64(len(numbers))})
+	case "$min", "$max":
+		var result interface{}
+		for _, value := range values {
+			if value == nil {
+				continue
+			}
+			c := m.Compare(value, result)
+			if result == nil || (operator == "$min" && c < 0) || (operator == "$max" && c > 0) {
+				result = value
+			}
+		}
+		return result, nil
+	case "$first", "$last":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		if operator == "$first" {
+			return values[0], nil
+		}
+		return values[len(values)-1], nil
+	case "$push", "$addToSet":
+		result := bson.A{}
+		for _, value := range values {
+			if value == nil || (operator == "$addToSet" && m.containsValue(result, value)) {
+				continue
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unknown group operator %s", operator)
+}
+
+func (m *Matcher) containsValue(values bson.A, value interface{}) bool {
+	for _, v := range values {
+		if m.Compare(v, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,557 @@
+package match
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Evaluate evaluates the aggregation expression for the document. The field paths,
+// $$ROOT, $$NOW, literals and the arithmetic, string, comparison, boolean,
+// conditional and date difference operators are supported. The numbers are
+// evaluated as int64 or float64, so the Decimal128 values lose their precision.
+func (m *Matcher) Evaluate(expression interface{}, doc interface{}) (interface{}, error) {
+	switch e := expression.(type) {
+	case string:
+		switch {
+		case e == "$$ROOT":
+			return doc, nil
+		case e == "$$NOW":
+			return m.now(), nil
+		case strings.HasPrefix(e, "$$"):
+			return nil, fmt.Errorf("unsupported variable %s", e)
+		case strings.HasPrefix(e, "$"):
+			return fieldPath(doc, strings.Split(e[1:], ".")), nil
+		}
+		return e, nil
+	}
+
+	if array, isArray := AsArray(expression); isArray {
+		result := make(bson.A, len(array))
+		for i, item := range array {
+			value, err := m.Evaluate(item, doc)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = value
+		}
+		return result, nil
+	}
+
+	object, isDocument := AsDocument(expression)
+	if !isDocument {
+		return expression, nil
+	}
+
+	for key, operand := range object {
+		if !strings.HasPrefix(key, "$") {
+			continue
+		}
+		if len(object) != 1 {
+			return nil, fmt.Errorf("an expression specification must contain exactly one field")
+		}
+		return m.evaluateOperator(key, operand, doc)
+	}
+
+	result := bson.M{}
+	for key, value := range object {
+		evaluated, err := m.Evaluate(value, doc)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = evaluated
+	}
+	return result, nil
+}
+
+func (m *Matcher) now() time.Time {
+	if m.clock != nil {
+		return m.clock()
+	}
+	return time.Now()
+}
+
+func (m *Matcher) evaluateOperator(operator string, operand interface{}, doc interface{}) (interface{}, error) {
+	switch operator {
+	case "$literal":
+		return operand, nil
+	case "$cond":
+		return m.evaluateCondition(operand, doc)
+	case "$dateDiff":
+		return m.evaluateDateDiff(operand, doc)
+	case "$dateTrunc":
+		return m.evaluateDateTrunc(operand, doc)
+	case "$meta":
+		if operand != "textScore" {
+			return nil, fmt.Errorf("unsupported $meta %v", operand)
+		}
+		return m.textScore(doc), nil
+	}
+
+	// the operand is either the array of the arguments or the only argument
+	value, err := m.Evaluate(operand, doc)
+	if err != nil {
+		return nil, err
+	}
+	args := bson.A{value}
+	if _, isArray := AsArray(operand); isArray {
+		args = value.(bson.A)
+	}
+
+	switch operator {
+	case "$ifNull":
+		for _, arg := range args {
+			if arg != nil {
+				return arg, nil
+			}
+		}
+		return nil, nil
+	case "$first", "$last", "$size":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expression %s takes exactly 1 argument", operator)
+		}
+		array, isArgArray := AsArray(args[0])
+		if operator == "$size" {
+			if !isArgArray {
+				return nil, fmt.Errorf("the argument to $size must be an array")
+			}
+			return int32(len(array)), nil
+		}
+		if !isArgArray || len(array) == 0 {
+			return nil, nil
+		}
+		if operator == "$first" {
+			return array[0], nil
+		}
+		return array[len(array)-1], nil
+	case "$add", "$subtract", "$multiply", "$divide", "$mod":
+		return arithmetic(operator, args)
+	case "$abs", "$floor", "$ceil", "$round":
+		return rounding(operator, args)
+	case "$concat":
+		var builder strings.Builder
+		for _, arg := range args {
+			if arg == nil {
+				return nil, nil
+			}
+			s, isString := arg.(string)
+			if !isString {
+				return nil, fmt.Errorf("$concat only supports strings, not %T", arg)
+			}
+			builder.WriteString(s)
+		}
+		return builder.String(), nil
+	case "$toLower", "$toUpper":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expression %s takes exactly 1 argument", operator)
+		}
+		s := ""
+		if args[0] != nil {
+			s = fmt.Sprint(args[0])
+		}
+		if operator == "$toLower" {
+			return strings.ToLower(s), nil
+		}
+		return strings.ToUpper(s), nil
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$cmp":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expression %s takes exactly 2 arguments", operator)
+		}
+		result := m.comparer.compare(args[0], args[1])
+		switch operator {
+		case "$eq":
+			return result == 0, nil
+		case "$ne":
+			return result != 0, nil
+		case "$gt":
+			return result > 0, nil
+		case "$gte":
+			return result >= 0, nil
+		case "$lt":
+			return result < 0, nil
+		case "$lte":
+			return result <= 0, nil
+		}
+		return int32(sign(result)), nil
+	case "$and":
+		for _, arg := range args {
+			if !truthy(arg) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$or":
+		for _, arg := range args {
+			if truthy(arg) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$not":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expression $not takes exactly 1 argument")
+		}
+		return !truthy(args[0]), nil
+	}
+	return nil, fmt.Errorf("unsupported expression operator %s", operator)
+}
+
+// evaluateCondition evaluates the $cond expression in the array or document form.
+// The branch that isn't taken is not evaluated.
+func (m *Matcher) evaluateCondition(operand interface{}, doc interface{}) (interface{}, error) {
+	var condition, then, otherwise interface{}
+	if array, isArray := AsArray(operand); isArray && len(array) == 3 {
+		condition, then, otherwise = array[0], array[1], array[2]
+	} else if object, isDocument := AsDocument(operand); isDocument {
+		condition, then, otherwise = object["if"], object["then"], object["else"]
+	} else {
+		return nil, fmt.Errorf("$cond needs an array of 3 items or a document")
+	}
+
+	value, err := m.Evaluate(condition, doc)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(value) {
+		return m.Evaluate(then, doc)
+	}
+	return m.Evaluate(otherwise, doc)
+}
+
+// evaluateDateDiff counts the unit boundaries between the dates in UTC like MongoDB.
+func (m *Matcher) evaluateDateDiff(operand interface{}, doc interface{}) (interface{}, error) {
+	object, isDocument := AsDocument(operand)
+	if !isDocument {
+		return nil, fmt.Errorf("$dateDiff needs a document")
+	}
+	values := make(map[string]interface{}, 3)
+	for _, key := range []string{"startDate", "endDate", "unit"} {
+		value, err := m.Evaluate(object[key], doc)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	if values["startDate"] == nil || values["endDate"] == nil {
+		return nil, nil
+	}
+	if rank(values["startDate"]) != rankDate || rank(values["endDate"]) != rankDate {
+		return nil, fmt.Errorf("$dateDiff requires dates")
+	}
+
+	start, end := toTime(values["startDate"]).UTC(), toTime(values["endDate"]).UTC()
+	unit, _ := values["unit"].(string)
+	months := func(t time.Time) int64 { return int64(t.Year())*12 + int64(t.Month()) - 1 }
+	switch unit {
+	case "year":
+		return int64(end.Year() - start.Year()), nil
+	case "quarter":
+		return months(end)/3 - months(start)/3, nil
+	case "month":
+		return months(end) - months(start), nil
+	case "week":
+		// the weeks start on Sunday
+		return floorDiv(daysSinceEpoch(end)+4, 7) - floorDiv(daysSinceEpoch(start)+4, 7), nil
+	case "day":
+		return daysSinceEpoch(end) - daysSinceEpoch(start), nil
+	}
+
+	units := map[string]time.Duration{
+		"hour": time.Hour, "minute": time.Minute, "second": time.Second, "millisecond": time.Millisecond,
+	}
+	duration, isUnit := units[unit]
+	if !isUnit {
+		return nil, fmt.Errorf("$dateDiff has an invalid unit %v", values["unit"])
+	}
+	return floorDiv(end.UnixMilli(), duration.Milliseconds()) - floorDiv(start.UnixMilli(), duration.Milliseconds()), nil
+}
+
+// evaluateDateTrunc truncates the date to the start of the unit in the time zone. The
+// weeks start on Sunday unless startOfWeek is set. binSize is not supported.
+func (m *Matcher) evaluateDateTrunc(operand interface{}, doc interface{}) (interface{}, error) {
+	object, isDocument := AsDocument(operand)
+	if !isDocument {
+		return nil, fmt.Errorf("$dateTrunc needs a document")
+	}
+	if _, hasBinSize := object["binSize"]; hasBinSize {
+		return nil, fmt.Errorf("$dateTrunc with binSize is not supported")
+	}
+	values := make(map[string]interface{}, 4)
+	for _, key := range []string{"date", "unit", "timezone", "startOfWeek"} {
+		value, err := m.Evaluate(object[key], doc)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	if values["date"] == nil {
+		return nil, nil
+	}
+	if rank(values["date"]) != rankDate {
+		return nil, fmt.Errorf("$dateTrunc requires a date")
+	}
+
+	loc := time.UTC
+	if timezone, isString := values["timezone"].(string); isString {
+		var err error
+		if loc, err = toLocation(timezone); err != nil {
+			return nil, fmt.Errorf("$dateTrunc has an unrecognized time zone %s", timezone)
+		}
+	}
+	t := toTime(values["date"]).In(loc)
+	year, month, day := t.Date()
+
+	var result time.Time
+	switch unit, _ := values["unit"].(string); unit {
+	case "year":
+		result = time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	case "quarter":
+		result = time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, loc)
+	case "month":
+		result = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	case "week":
+		weekStart := time.Sunday
+		if name, isString := values["startOfWeek"].(string); isString {
+			weekStart = -1
+			for d := time.Sunday; d <= time.Saturday; d++ {
+				if strings.EqualFold(name, d.String()) || strings.EqualFold(name, d.String()[:3]) {
+					weekStart = d
+				}
+			}
+			if weekStart < 0 {
+				return nil, fmt.Errorf("$dateTrunc has an invalid startOfWeek %s", name)
+			}
+		}
+		result = time.Date(year, month, day-(int(t.Weekday()-weekStart)+7)%7, 0, 0, 0, 0, loc)
+	case "day":
+		result = time.Date(year, month, day, 0, 0, 0, 0, loc)
+	case "hour", "minute", "second":
+		units := map[string]time.Duration{"hour": time.Hour, "minute": time.Minute, "second": time.Second}
+		result = t.Truncate(units[unit])
+	default:
+		return nil, fmt.Errorf("$dateTrunc has an invalid unit %v", values["unit"])
+	}
+	return result.UTC(), nil
+}
+
+// toLocation returns the time zone of an Olson time zone identifier or a UTC offset
+// such as +03:00, +0300 or +03.
+func toLocation(timezone string) (*time.Location, error) {
+	if strings.HasPrefix(timezone, "+") || strings.HasPrefix(timezone, "-") {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, timezone); err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(timezone, offset), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid offset %s", timezone)
+	}
+	return time.LoadLocation(timezone)
+}
+
+func daysSinceEpoch(t time.Time) int64 {
+	return floorDiv(t.Unix(), 24*60*60)
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// arithmetic evaluates the arithmetic operators. The result is an int64 if all the
+// numbers are integers, a float64 otherwise. The dates can be added milliseconds to
+// and subtracted from each other like MongoDB.
+func arithmetic(operator string, args bson.A) (interface{}, error) {
+	for _, arg := range args {
+		if arg == nil {
+			return nil, nil
+		}
+	}
+	if operator != "$add" && operator != "$multiply" && len(args) != 2 {
+		return nil, fmt.Errorf("expression %s takes exactly 2 arguments", operator)
+	}
+
+	var date *time.Time
+	integers := make([]int64, 0, len(args))
+	floats := make([]float64, 0, len(args))
+	isInteger := true
+	for i, arg := range args {
+		if rank(arg) == rankDate {
+			t := toTime(arg)
+			if (operator == "$add" && date == nil) || (operator == "$subtract" && i == 0) {
+				date = &t
+				continue
+			}
+			if operator == "$subtract" && date != nil {
+				return date.Sub(t).Milliseconds(), nil
+			}
+			return nil, fmt.Errorf("%s doesn't support the date %v", operator, arg)
+		}
+
+		i, f, isIntegerArg, isNumber := numberValue(arg)
+		if !isNumber {
+			return nil, fmt.Errorf("%s only supports numeric types, not %T", operator, arg)
+		}
+		isInteger = isInteger && isIntegerArg
+		integers = append(integers, i)
+		floats = append(floats, f)
+	}
+
+	if date != nil {
+		total := 0.0
+		for _, f := range floats {
+			total += f
+		}
+		if operator == "$subtract" {
+			total = -total
+		}
+		return date.Add(time.Duration(math.Round(total)) * time.Millisecond), nil
+	}
+
+	switch operator {
+	case "$add", "$multiply":
+		intResult, floatResult := integers[0], floats[0]
+		for i := 1; i < len(floats); i++ {
+			if operator == "$add" {
+				intResult, floatResult = intResult+integers[i], floatResult+floats[i]
+			} else {
+				intResult, floatResult = intResult*integers[i], floatResult*floats[i]
+			}
+		}
+		if isInteger {
+			return intResult, nil
+		}
+		return floatResult, nil
+	case "$subtract":
+		if isInteger {
+			return integers[0] - integers[1], nil
+		}
+		return floats[0] - floats[1], nil
+	case "$divide":
+		if floats[1] == 0 {
+			return nil, fmt.Errorf("can't $divide by zero")
+		}
+		return floats[0] / floats[1], nil
+	default:
+		if floats[1] == 0 {
+			return nil, fmt.Errorf("can't $mod by zero")
+		}
+		if isInteger {
+			return integers[0] % integers[1], nil
+		}
+		return math.Mod(floats[0], floats[1]), nil
+	}
+}
+
+// rounding evaluates $abs, $floor, $ceil and $round. $round rounds half to even to
+// the decimal place like MongoDB.
+func rounding(operator string, args bson.A) (interface{}, error) {
+	if len(args) == 0 || args[0] == nil {
+		return nil, nil
+	}
+	i, f, isInteger, isNumber := numberValue(args[0])
+	if !isNumber {
+		return nil, fmt.Errorf("%s only supports numeric types, not %T", operator, args[0])
+	}
+
+	switch operator {
+	case "$abs":
+		if isInteger {
+			if i < 0 {
+				return -i, nil
+			}
+			return i, nil
+		}
+		return math.Abs(f), nil
+	case "$floor", "$ceil":
+		if isInteger {
+			return i, nil
+		}
+		if operator == "$floor" {
+			return math.Floor(f), nil
+		}
+		return math.Ceil(f), nil
+	}
+
+	place := int64(0)
+	if len(args) > 1 {
+		p, _, isIntegerPlace, _ := numberValue(args[1])
+		if !isIntegerPlace {
+			return nil, fmt.Errorf("$round requires an integer place")
+		}
+		place = p
+	}
+	if isInteger && place >= 0 {
+		return i, nil
+	}
+	scale := math.Pow(10, float64(place))
+	return math.RoundToEven(f*scale) / scale, nil
+}
+
+// numberValue returns the number as an int64 and a float64.
+func numberValue(value interface{}) (int64, float64, bool, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), float64(v), true, true
+	case int32:
+		return int64(v), float64(v), true, true
+	case int64:
+		return v, float64(v), true, true
+	case float32:
+		return int64(v), float64(v), false, true
+	case float64:
+		return int64(v), v, false, true
+	case primitive.Decimal128:
+		n, isNumber := toNumber(v)
+		if !isNumber || n.nan {
+			return 0, math.NaN(), false, isNumber
+		}
+		f, _ := n.value.Float64()
+		return int64(f), f, false, true
+	}
+	return 0, 0, false, false
+}
+
+// fieldPath returns the value of the field path expression. The arrays on the path
+// result in an array of the values of their items.
+func fieldPath(value interface{}, parts []string) interface{} {
+	if len(parts) == 0 {
+		return value
+	}
+
+	if doc, isDocument := AsDocument(value); isDocument {
+		return fieldPath(doc[parts[0]], parts[1:])
+	}
+	if array, isArray := AsArray(value); isArray {
+		result := bson.A{}
+		for _, item := range array {
+			_, isDocument := AsDocument(item)
+			_, isArray := AsArray(item)
+			if !isDocument && !isArray {
+				continue
+			}
+			if itemValue := fieldPath(item, parts); itemValue != nil {
+				result = append(result, itemValue)
+			}
+		}
+		return result
+	}
+	return nil
+}
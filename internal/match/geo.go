@@ -0,0 +1,228 @@
+package match
+
+import (
+	"fmt"
+	"math"
+)
+
+// EarthRadius is the radius of the Earth in meters that MongoDB uses to convert the
+// distances into radians.
+const EarthRadius = 6378100.0
+
+// GeoPoint is a position in longitude and latitude.
+type GeoPoint struct {
+	Lng float64
+	Lat float64
+}
+
+// GeoPoints returns the positions of a GeoJSON Point, LineString, Polygon or
+// MultiPoint or a legacy coordinate pair. The first ring of a polygon is used.
+func GeoPoints(value interface{}) ([]GeoPoint, bool) {
+	if point, isPoint := toGeoPoint(value); isPoint {
+		return []GeoPoint{point}, true
+	}
+	doc, isDocument := AsDocument(value)
+	if !isDocument {
+		return nil, false
+	}
+	coordinates := doc["coordinates"]
+	switch doc["type"] {
+	case "Point":
+		point, isPoint := toGeoPoint(coordinates)
+		return []GeoPoint{point}, isPoint
+	case "LineString", "MultiPoint":
+		return toGeoPoints(coordinates)
+	case "Polygon":
+		rings, isArray := AsArray(coordinates)
+		if !isArray || len(rings) == 0 {
+			return nil, false
+		}
+		return toGeoPoints(rings[0])
+	}
+	return nil, false
+}
+
+func toGeoPoint(value interface{}) (GeoPoint, bool) {
+	coordinates, isArray := AsArray(value)
+	if !isArray || len(coordinates) != 2 {
+		return GeoPoint{}, false
+	}
+	_, lng, _, isLngNumber := numberValue(coordinates[0])
+	_, lat, _, isLatNumber := numberValue(coordinates[1])
+	return GeoPoint{Lng: lng, Lat: lat}, isLngNumber && isLatNumber
+}
+
+func toGeoPoints(value interface{}) ([]GeoPoint, bool) {
+	items, isArray := AsArray(value)
+	if !isArray || len(items) == 0 {
+		return nil, false
+	}
+	points := make([]GeoPoint, len(items))
+	for i, item := range items {
+		point, isPoint := toGeoPoint(item)
+		if !isPoint {
+			return nil, false
+		}
+		points[i] = point
+	}
+	return points, true
+}
+
+// GeoDistance returns the distance in meters on the sphere between the point and the
+// nearest position of the GeoJSON value. The distance is 0 if the value is a polygon
+// that contains the point.
+func GeoDistance(value interface{}, point GeoPoint) (float64, bool) {
+	points, isGeo := GeoPoints(value)
+	if !isGeo {
+		return 0, false
+	}
+	if doc, _ := AsDocument(value); doc["type"] == "Polygon" && inPolygon(point, points) {
+		return 0, true
+	}
+	distance := math.Inf(1)
+	for _, p := range points {
+		distance = math.Min(distance, haversine(p, point))
+	}
+	return distance, true
+}
+
+func haversine(a, b GeoPoint) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	dLat := toRadians(b.Lat - a.Lat)
+	dLng := toRadians(b.Lng - a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(a.Lat))*math.Cos(toRadians(b.Lat))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// inPolygon reports whether the point is in the ring by ray casting. The edges are
+// straight lines of longitude and latitude, so the result may differ from MongoDB
+// near the edges of the large polygons.
+func inPolygon(point GeoPoint, ring []GeoPoint) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > point.Lat) != (b.Lat > point.Lat) &&
+			point.Lng < (b.Lng-a.Lng)*(point.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// matchGeoWithin reports whether any of the values is within the shape of the
+// $geoWithin operand, which is either a GeoJSON polygon in $geometry, a circle in
+// $centerSphere or a legacy $box. All the positions of the values must be within it.
+func matchGeoWithin(values []interface{}, operand interface{}) (bool, error) {
+	shape, isDocument := AsDocument(operand)
+	if !isDocument || len(shape) != 1 {
+		return false, fmt.Errorf("$geoWithin needs a shape")
+	}
+
+	var within func(point GeoPoint) bool
+	switch {
+	case shape["$geometry"] != nil:
+		geometry, _ := AsDocument(shape["$geometry"])
+		ring, isGeo := GeoPoints(geometry)
+		if !isGeo || geometry["type"] != "Polygon" || len(ring) < 4 {
+			return false, fmt.Errorf("$geoWithin needs a Polygon in $geometry")
+		}
+		within = func(point GeoPoint) bool { return inPolygon(point, ring) }
+	case shape["$centerSphere"] != nil:
+		circle, isArray := AsArray(shape["$centerSphere"])
+		if !isArray || len(circle) != 2 {
+			return false, fmt.Errorf("$centerSphere needs a center and a radius")
+		}
+		center, isPoint := toGeoPoint(circle[0])
+		_, radius, _, isNumber := numberValue(circle[1])
+		if !isPoint || !isNumber {
+			return false, fmt.Errorf("$centerSphere needs a center and a radius")
+		}
+		within = func(point GeoPoint) bool { return haversine(center, point) <= radius*EarthRadius }
+	case shape["$box"] != nil:
+		corners, isBox := toGeoPoints(shape["$box"])
+		if !isBox || len(corners) != 2 {
+			return false, fmt.Errorf("$box needs two corners")
+		}
+		within = func(point GeoPoint) bool {
+			return point.Lng >= corners[0].Lng && point.Lng <= corners[1].Lng &&
+				point.Lat >= corners[0].Lat && point.Lat <= corners[1].Lat
+		}
+	default:
+		return false, fmt.Errorf("unsupported $geoWithin shape")
+	}
+
+	for _, value := range values {
+		points, isGeo := GeoPoints(value)
+		if !isGeo {
+			continue
+		}
+		all := true
+		for _, point := range points {
+			all = all && within(point)
+		}
+		if all {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NearQuery is a parsed $nearSphere query or the options of $geoNear.
+type NearQuery struct {
+	Point       GeoPoint
+	MinDistance float64
+	MaxDistance float64
+}
+
+// ParseNearQuery parses the GeoJSON form of $nearSphere and the near, minDistance and
+// maxDistance options of $geoNear. The distances are in meters.
+func ParseNearQuery(point interface{}, minDistance, maxDistance interface{}) (*NearQuery, error) {
+	geometry, _ := AsDocument(point)
+	points, isGeo := GeoPoints(point)
+	if !isGeo || geometry["type"] != "Point" {
+		return nil, fmt.Errorf("near needs a GeoJSON Point")
+	}
+	q := &NearQuery{Point: points[0], MaxDistance: math.Inf(1)}
+	for _, distance := range []struct {
+		value  interface{}
+		target *float64
+	}{{minDistance, &q.MinDistance}, {maxDistance, &q.MaxDistance}} {
+		if distance.value == nil {
+			continue
+		}
+		_, f, _, isNumber := numberValue(distance.value)
+		if !isNumber {
+			return nil, fmt.Errorf("the distances must be numbers")
+		}
+		*distance.target = f
+	}
+	return q, nil
+}
+
+// Distance returns the distance of the value to the point of the query and reports
+// whether it's in the distance range.
+func (q *NearQuery) Distance(values []interface{}) (float64, bool) {
+	distance, found := math.Inf(1), false
+	for _, value := range values {
+		if d, isGeo := GeoDistance(value, q.Point); isGeo && d < distance {
+			distance, found = d, true
+		}
+	}
+	return distance, found && distance >= q.MinDistance && distance <= q.MaxDistance
+}
+
+// matchNearSphere reports whether any of the values is in the distance range of the
+// $nearSphere operand. The documents are not sorted by distance unlike MongoDB.
+func matchNearSphere(values []interface{}, operand interface{}) (bool, error) {
+	options, isDocument := AsDocument(operand)
+	if !isDocument {
+		return false, fmt.Errorf("$nearSphere needs a $geometry")
+	}
+	q, err := ParseNearQuery(options["$geometry"], options["$minDistance"], options["$maxDistance"])
+	if err != nil {
+		return false, err
+	}
+	_, matched := q.Distance(values)
+	return matched, nil
+}
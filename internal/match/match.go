@@ -0,0 +1,448 @@
+// Package match evaluates MongoDB query documents against in-memory documents so
+// the queries generated by the model package can run without a database.
+package match
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dlclark/regexp2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Options configures how the values are compared.
+type Options struct {
+	// CaseInsensitive compares the strings like the case-insensitive collation
+	// (locale "en", strength 2) that Database.Aggregate uses.
+	CaseInsensitive bool
+
+	// Now is the clock of $$NOW in the expressions. time.Now is used if it's nil.
+	Now func() time.Time
+}
+
+// Matcher evaluates queries with the same options. It's not safe for concurrent use.
+type Matcher struct {
+	comparer   *comparer
+	clock      func() time.Time
+	textScores map[uintptr]float64
+}
+
+func New(options Options) *Matcher {
+	return &Matcher{comparer: newComparer(options.CaseInsensitive), clock: options.Now}
+}
+
+// Document reports whether the document matches the query.
+func Document(query, doc interface{}, options Options) (bool, error) {
+	return New(options).Match(query, doc)
+}
+
+// Compare compares the values in the BSON comparison order.
+func (m *Matcher) Compare(a, b interface{}) int {
+	return m.comparer.compare(a, b)
+}
+
+// Match reports whether the document matches the query. The query operators that
+// are not supported return an error like an invalid query does in MongoDB.
+func (m *Matcher) Match(query, doc interface{}) (bool, error) {
+	conditions, isDocument := AsDocument(query)
+	if !isDocument {
+		return false, fmt.Errorf("query must be a document, got %T", query)
+	}
+
+	for key, condition := range conditions {
+		var matched bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			matched, err = m.matchLogical(key, condition, doc)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported query operator %s", key)
+			}
+			matched, err = m.matchField(lookup(doc, strings.Split(key, "."), false), condition)
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (m *Matcher) matchLogical(operator string, condition, doc interface{}) (bool, error) {
+	queries, isArray := AsArray(condition)
+	if !isArray || len(queries) == 0 {
+		return false, fmt.Errorf("%s must be a nonempty array", operator)
+	}
+
+	for _, query := range queries {
+		matched, err := m.Match(query, doc)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case operator == "$and" && !matched:
+			return false, nil
+		case operator == "$or" && matched:
+			return true, nil
+		case operator == "$nor" && matched:
+			return false, nil
+		}
+	}
+	return operator != "$or", nil
+}
+
+// operators returns the condition as an operator document like { "$gt": 5 }.
+func operators(condition interface{}) (map[string]interface{}, bool) {
+	doc, isDocument := AsDocument(condition)
+	if !isDocument || len(doc) == 0 {
+		return nil, false
+	}
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// matchField reports whether the values found at the path of a field match the
+// condition, which is either an operator document or a value to be equal to.
+func (m *Matcher) matchField(values []interface{}, condition interface{}) (bool, error) {
+	ops, isOperators := operators(condition)
+	if !isOperators {
+		return m.equalsAny(values, condition), nil
+	}
+
+	for operator, operand := range ops {
+		matched, err := m.matchOperator(operator, operand, ops, values)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (m *Matcher) matchOperator(operator string, operand interface{}, ops map[string]interface{}, values []interface{}) (bool, error) {
+	switch operator {
+	case "$eq":
+		return m.equalsAny(values, operand), nil
+	case "$ne":
+		return !m.equalsAny(values, operand), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return m.compareAny(operator, values, operand), nil
+	case "$in", "$nin":
+		items, isArray := AsArray(operand)
+		if !isArray {
+			return false, fmt.Errorf("%s needs an array", operator)
+		}
+		in := false
+		for _, item := range items {
+			if m.equalsAny(values, item) {
+				in = true
+				break
+			}
+		}
+		return in == (operator == "$in"), nil
+	case "$exists":
+		return hasValue(values) == truthy(operand), nil
+	case "$regex":
+		options, _ := ops["$options"].(string)
+		regex, err := toRegex(operand, options)
+		if err != nil {
+			return false, err
+		}
+		return m.anyValue(values, func(value interface{}) bool { return matchRegex(regex, value) }), nil
+	case "$options":
+		if _, hasRegex := ops["$regex"]; !hasRegex {
+			return false, fmt.Errorf("$options needs a $regex")
+		}
+		return true, nil
+	case "$not":
+		if _, isOperators := operators(operand); !isOperators {
+			if _, isRegex := operand.(primitive.Regex); !isRegex {
+				return false, fmt.Errorf("$not needs a regex or a document")
+			}
+		}
+		matched, err := m.matchField(values, operand)
+		return !matched, err
+	case "$size":
+		size, isNumber := toNumber(operand)
+		if !isNumber || size.nan || !size.value.IsInt() {
+			return false, fmt.Errorf("$size needs a whole number")
+		}
+		for _, value := range values {
+			if array, isArray := AsArray(value); isArray && size.value.Cmp(toFloat(len(array))) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$all":
+		items, isArray := AsArray(operand)
+		if !isArray {
+			return false, fmt.Errorf("$all needs an array")
+		}
+		if len(items) == 0 {
+			return false, nil
+		}
+		for _, item := range items {
+			if !m.equalsAny(values, item) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$type":
+		types, isArray := AsArray(operand)
+		if !isArray {
+			types = []interface{}{operand}
+		}
+		for _, t := range types {
+			typeRank, isKnown := typeRanks[fmt.Sprint(t)]
+			if !isKnown {
+				return false, fmt.Errorf("unknown type %v", t)
+			}
+			if m.anyValue(values, func(value interface{}) bool { return rank(value) == typeRank }) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$geoWithin":
+		return matchGeoWithin(values, operand)
+	case "$nearSphere":
+		return matchNearSphere(values, operand)
+	case "$elemMatch":
+		if _, isDocument := AsDocument(operand); !isDocument {
+			return false, fmt.Errorf("$elemMatch needs a document")
+		}
+		return m.matchElement(values, operand)
+	}
+	return false, fmt.Errorf("unsupported query operator %s", operator)
+}
+
+// matchElement reports whether any item of the arrays matches the condition,
+// which is either an operator document or a query on the fields of the items. The
+// logical operators like $and are queries on the fields of the items.
+func (m *Matcher) matchElement(values []interface{}, condition interface{}) (bool, error) {
+	ops, isOperators := operators(condition)
+	for operator := range ops {
+		if operator == "$and" || operator == "$or" || operator == "$nor" {
+			isOperators = false
+		}
+	}
+	for _, value := range values {
+		array, isArray := AsArray(value)
+		if !isArray {
+			continue
+		}
+		for _, item := range array {
+			var matched bool
+			var err error
+			if isOperators {
+				matched, err = m.matchField([]interface{}{item}, condition)
+			} else if _, isDocument := AsDocument(item); isDocument {
+				matched, err = m.Match(condition, item)
+			}
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// anyValue reports whether any of the values or an item of the values that are
+// arrays satisfies the predicate.
+func (m *Matcher) anyValue(values []interface{}, predicate func(value interface{}) bool) bool {
+	for _, value := range values {
+		if _, isUndefined := value.(undefined); isUndefined {
+			continue
+		}
+		if predicate(value) {
+			return true
+		}
+		if array, isArray := AsArray(value); isArray {
+			for _, item := range array {
+				if predicate(item) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (m *Matcher) equalsAny(values []interface{}, operand interface{}) bool {
+	if regex, isRegex := operand.(primitive.Regex); isRegex {
+		compiled, err := toRegex(regex, "")
+		if err != nil {
+			return false
+		}
+		return m.anyValue(values, func(value interface{}) bool {
+			return matchRegex(compiled, value) || m.comparer.equal(value, operand)
+		})
+	}
+
+	if rank(operand) == rankNull {
+		// null matches the missing fields as well
+		if !hasValue(values) {
+			return true
+		}
+		for _, value := range values {
+			if _, isUndefined := value.(undefined); isUndefined {
+				return true
+			}
+		}
+	}
+	return m.anyValue(values, func(value interface{}) bool { return m.comparer.equal(value, operand) })
+}
+
+func (m *Matcher) compareAny(operator string, values []interface{}, operand interface{}) bool {
+	if rank(operand) == rankNull {
+		// only the equality is meaningful for null
+		if operator == "$gte" || operator == "$lte" {
+			return m.equalsAny(values, operand)
+		}
+		return false
+	}
+
+	return m.anyValue(values, func(value interface{}) bool {
+		if rank(value) != rank(operand) || rank(value) == rankNull {
+			return false
+		}
+		result := m.comparer.compare(value, operand)
+		switch operator {
+		case "$gt":
+			return result > 0
+		case "$gte":
+			return result >= 0
+		case "$lt":
+			return result < 0
+		default:
+			return result <= 0
+		}
+	})
+}
+
+func hasValue(values []interface{}) bool {
+	for _, value := range values {
+		if _, isUndefined := value.(undefined); !isUndefined {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	if n, isNumber := toNumber(value); isNumber {
+		return n.nan || n.value.Sign() != 0
+	}
+	return true
+}
+
+// MaxCachedRegexes is the number of the compiled regular expressions that are kept
+// for the next evaluations. The patterns of the regex comparisons come from the
+// clients, so the least recently used ones are evicted.
+const MaxCachedRegexes = 256
+
+var regexCache = &regexLRU{items: map[string]*list.Element{}, order: list.New()}
+
+// regexLRU is the cache of the compiled regular expressions by their options and
+// patterns. It's safe for concurrent use.
+type regexLRU struct {
+	mutex sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+type regexEntry struct {
+	key   string
+	regex *regexp2.Regexp
+}
+
+func (c *regexLRU) load(key string) (*regexp2.Regexp, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, has := c.items[key]
+	if !has {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*regexEntry).regex, true
+}
+
+func (c *regexLRU) store(key string, regex *regexp2.Regexp) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, has := c.items[key]; has {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.order.PushFront(&regexEntry{key: key, regex: regex})
+	if c.order.Len() > MaxCachedRegexes {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*regexEntry).key)
+	}
+}
+
+// toRegex compiles the MongoDB regular expression with the PCRE compatible engine
+// since the queries may contain lookarounds that the regexp package doesn't support.
+func toRegex(value interface{}, options string) (*regexp2.Regexp, error) {
+	var pattern string
+	switch v := value.(type) {
+	case string:
+		pattern = v
+	case primitive.Regex:
+		pattern = v.Pattern
+		if options == "" {
+			options = v.Options
+		}
+	default:
+		return nil, fmt.Errorf("$regex needs a string, got %T", value)
+	}
+
+	key := options + "/" + pattern
+	if cached, has := regexCache.load(key); has {
+		return cached, nil
+	}
+
+	var flags regexp2.RegexOptions
+	for _, option := range options {
+		switch option {
+		case 'i':
+			flags |= regexp2.IgnoreCase
+		case 'm':
+			flags |= regexp2.Multiline
+		case 's':
+			flags |= regexp2.Singleline
+		case 'x':
+			flags |= regexp2.IgnorePatternWhitespace
+		default:
+			return nil, fmt.Errorf("invalid regex option %c", option)
+		}
+	}
+
+	regex, err := regexp2.Compile(pattern, flags)
+	if err != nil {
+		return nil, err
+	}
+	regex.MatchTimeout = time.Second
+	regexCache.store(key, regex)
+	return regex, nil
+}
+
+func matchRegex(regex *regexp2.Regexp, value interface{}) bool {
+	if rank(value) != rankString {
+		return false
+	}
+	matched, err := regex.MatchString(toString(value))
+	return err == nil && matched
+}
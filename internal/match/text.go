@@ -0,0 +1,129 @@
+package match
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// TextSearch is a parsed $text query.
+type TextSearch struct {
+	terms              []string
+	phrases            []string
+	negated            []string
+	caseSensitive      bool
+	diacriticSensitive bool
+}
+
+// ParseTextSearch parses the operand of $text. The terms are separated by the spaces
+// and the punctuation, the phrases are in double quotes and the negated terms start
+// with a hyphen-minus. $language is accepted but the stemming and the stop words are
+// not supported.
+func ParseTextSearch(operand interface{}) (*TextSearch, bool) {
+	options, isDocument := AsDocument(operand)
+	if !isDocument {
+		return nil, false
+	}
+	search, isString := options["$search"].(string)
+	if !isString {
+		return nil, false
+	}
+	t := &TextSearch{}
+	t.caseSensitive, _ = options["$caseSensitive"].(bool)
+	t.diacriticSensitive, _ = options["$diacriticSensitive"].(bool)
+
+	for i, part := range strings.Split(search, `"`) {
+		if i%2 == 1 {
+			if phrase := strings.TrimSpace(part); phrase != "" {
+				t.phrases = append(t.phrases, t.normalize(phrase))
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			negated := strings.HasPrefix(field, "-")
+			for _, word := range words(t.normalize(field)) {
+				if negated {
+					t.negated = append(t.negated, word)
+				} else {
+					t.terms = append(t.terms, word)
+				}
+			}
+		}
+	}
+	return t, true
+}
+
+// Score returns the relevance score of the indexed values of a document and reports
+// whether the document matches the search. The document matches if it contains any
+// of the terms, all the phrases and none of the negated terms. The score is the
+// number of the occurrences of the terms and the phrases, so it orders the documents
+// like MongoDB roughly, but its values are different.
+func (t *TextSearch) Score(values []string) (float64, bool) {
+	occurrences := map[string]int{}
+	phrases := 0
+	matchedPhrases := map[string]bool{}
+	for _, value := range values {
+		value = t.normalize(value)
+		for _, word := range words(value) {
+			occurrences[word]++
+		}
+		for _, phrase := range t.phrases {
+			if n := strings.Count(value, phrase); n > 0 {
+				phrases += n
+				matchedPhrases[phrase] = true
+			}
+		}
+	}
+
+	for _, word := range t.negated {
+		if occurrences[word] > 0 {
+			return 0, false
+		}
+	}
+	if len(matchedPhrases) < len(t.phrases) {
+		return 0, false
+	}
+	score := float64(phrases)
+	for _, term := range t.terms {
+		score += float64(occurrences[term])
+	}
+	return score, score > 0
+}
+
+func (t *TextSearch) normalize(s string) string {
+	if !t.caseSensitive {
+		s = strings.ToLower(s)
+	}
+	if !t.diacriticSensitive {
+		s, _, _ = transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), s)
+	}
+	return s
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SetTextScore sets the text score of the document, which is the value of
+// { $meta: "textScore" } in the expressions that are evaluated for the document.
+func (m *Matcher) SetTextScore(doc map[string]interface{}, score float64) {
+	if m.textScores == nil {
+		m.textScores = map[uintptr]float64{}
+	}
+	m.textScores[reflect.ValueOf(doc).Pointer()] = score
+}
+
+func (m *Matcher) textScore(doc interface{}) interface{} {
+	if rv := reflect.ValueOf(doc); rv.Kind() == reflect.Map {
+		if score, has := m.textScores[rv.Pointer()]; has {
+			return score
+		}
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+package match
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// undefined is returned by Lookup for the items of an array that don't have the
+// looked up field. It only matches the null equality like it does in MongoDB.
+type undefined struct{}
+
+// AsDocument returns the value as a map if it's a document. bson.M, bson.D and the
+// types based on map[string]interface{} such as model.DataModel are documents.
+func AsDocument(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, false
+	case map[string]interface{}:
+		return v, true
+	case primitive.M:
+		return v, true
+	case primitive.D:
+		doc := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			doc[e.Key] = e.Value
+		}
+		return doc, true
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, false
+		}
+		return AsDocument(rv.Elem().Interface())
+	}
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	if rv.Type().ConvertibleTo(documentType) {
+		return rv.Convert(documentType).Interface().(map[string]interface{}), true
+	}
+	doc := make(map[string]interface{}, rv.Len())
+	for _, key := range rv.MapKeys() {
+		doc[key.String()] = rv.MapIndex(key).Interface()
+	}
+	return doc, true
+}
+
+var documentType = reflect.TypeOf(map[string]interface{}{})
+
+// AsArray returns the value as a slice if it's an array. Byte slices are binary
+// values and bson.D is a document, so they are not arrays. The Go arrays such as
+// ObjectID are single values as well.
+func AsArray(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case nil, []byte, primitive.D:
+		return nil, false
+	case []interface{}:
+		return v, true
+	case primitive.A:
+		return v, true
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	array := make([]interface{}, rv.Len())
+	for i := range array {
+		array[i] = rv.Index(i).Interface()
+	}
+	return array, true
+}
+
+// Lookup returns the values found at the dotted path of the document. The arrays
+// on the path are traversed like MongoDB does: a numeric part is used as an index
+// and the other parts are looked up in each document of the array.
+func Lookup(doc interface{}, path string) []interface{} {
+	values := make([]interface{}, 0)
+	for _, value := range lookup(doc, strings.Split(path, "."), false) {
+		if _, isUndefined := value.(undefined); !isUndefined {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func lookup(value interface{}, parts []string, inArray bool) []interface{} {
+	if len(parts) == 0 {
+		return []interface{}{value}
+	}
+
+	if doc, isDocument := AsDocument(value); isDocument {
+		child, has := doc[parts[0]]
+		if !has {
+			if inArray {
+				return []interface{}{undefined{}}
+			}
+			return nil
+		}
+		return lookup(child, parts[1:], false)
+	}
+
+	array, isArray := AsArray(value)
+	if !isArray {
+		if inArray {
+			return []interface{}{undefined{}}
+		}
+		return nil
+	}
+
+	values := make([]interface{}, 0)
+	if isIndex(parts[0]) {
+		index := 0
+		for _, c := range parts[0] {
+			index = index*10 + int(c-'0')
+		}
+		if index < len(array) {
+			values = append(values, lookup(array[index], parts[1:], false)...)
+		}
+	}
+	for _, item := range array {
+		if _, isDocument := AsDocument(item); isDocument {
+			values = append(values, lookup(item, parts, true)...)
+		}
+	}
+	return values
+}
+
+func isIndex(part string) bool {
+	if part == "" {
+		return false
+	}
+	for _, c := range part {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// type ranks in the BSON comparison order
+const (
+	rankNull = iota + 1
+	rankNumber
+	rankString
+	rankDocument
+	rankArray
+	rankBinary
+	rankObjectID
+	rankBoolean
+	rankDate
+	rankTimestamp
+	rankRegex
+	rankUnknown
+)
+
+// typeRanks contains the aliases and the numbers of the BSON types for $type. The
+// numeric types share the same rank, so they match each other.
+var typeRanks = map[string]int{
+	"null": rankNull, "10": rankNull,
+	"number": rankNumber, "double": rankNumber, "1": rankNumber, "int": rankNumber, "16": rankNumber,
+	"long": rankNumber, "18": rankNumber, "decimal": rankNumber, "19": rankNumber,
+	"string": rankString, "2": rankString,
+	"object": rankDocument, "3": rankDocument,
+	"array": rankArray, "4": rankArray,
+	"binData": rankBinary, "5": rankBinary,
+	"objectId": rankObjectID, "7": rankObjectID,
+	"bool": rankBoolean, "8": rankBoolean,
+	"date": rankDate, "9": rankDate,
+	"timestamp": rankTimestamp, "17": rankTimestamp,
+	"regex": rankRegex, "11": rankRegex,
+}
+
+func rank(value interface{}) int {
+	switch value.(type) {
+	case nil, undefined, primitive.Null, primitive.Undefined:
+		return rankNull
+	case string, primitive.Symbol:
+		return rankString
+	case []byte, primitive.Binary:
+		return rankBinary
+	case primitive.ObjectID:
+		return rankObjectID
+	case bool:
+		return rankBoolean
+	case time.Time, primitive.DateTime:
+		return rankDate
+	case primitive.Timestamp:
+		return rankTimestamp
+	case primitive.Regex:
+		return rankRegex
+	}
+	if _, isNumber := toNumber(value); isNumber {
+		return rankNumber
+	}
+	if _, isDocument := AsDocument(value); isDocument {
+		return rankDocument
+	}
+	if _, isArray := AsArray(value); isArray {
+		return rankArray
+	}
+	return rankUnknown
+}
+
+// number is a numeric value of any BSON or Go type. NaN is kept aside since it's
+// lower than all the other numbers in MongoDB and big.Float can't represent it.
+type number struct {
+	nan   bool
+	value *big.Float
+}
+
+func toNumber(value interface{}) (number, bool) {
+	switch v := value.(type) {
+	case int:
+		return number{value: new(big.Float).SetInt64(int64(v))}, true
+	case int8:
+		return number{value: new(big.Float).SetInt64(int64(v))}, true
+	case int16:
+		return number{value: new(big.Float).SetInt64(int64(v))}, true
+	case int32:
+		return number{value: new(big.Float).SetInt64(int64(v))}, true
+	case int64:
+		return number{value: new(big.Float).SetInt64(v)}, true
+	case uint:
+		return number{value: new(big.Float).SetUint64(uint64(v))}, true
+	case uint8:
+		return number{value: new(big.Float).SetUint64(uint64(v))}, true
+	case uint16:
+		return number{value: new(big.Float).SetUint64(uint64(v))}, true
+	case uint32:
+		return number{value: new(big.Float).SetUint64(uint64(v))}, true
+	case uint64:
+		return number{value: new(big.Float).SetUint64(v)}, true
+	case float32:
+		return floatNumber(float64(v)), true
+	case float64:
+		return floatNumber(v), true
+	case json.Number:
+		return stringNumber(string(v))
+	case primitive.Decimal128:
+		return stringNumber(v.String())
+	}
+	return number{}, false
+}
+
+func floatNumber(f float64) number {
+	if math.IsNaN(f) {
+		return number{nan: true}
+	}
+	return number{value: big.NewFloat(f)}
+}
+
+func stringNumber(s string) (number, bool) {
+	switch s {
+	case "NaN", "-NaN":
+		return number{nan: true}, true
+	case "Infinity", "+Infinity":
+		return number{value: new(big.Float).SetInf(false)}, true
+	case "-Infinity":
+		return number{value: new(big.Float).SetInf(true)}, true
+	}
+	f, _, err := big.ParseFloat(s, 10, 113, big.ToNearestEven)
+	if err != nil {
+		return number{}, false
+	}
+	return number{value: f}, true
+}
+
+func compareNumbers(a, b number) int {
+	switch {
+	case a.nan && b.nan:
+		return 0
+	case a.nan:
+		return -1
+	case b.nan:
+		return 1
+	}
+	return a.value.Cmp(b.value)
+}
+
+func toTime(value interface{}) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case primitive.DateTime:
+		return time.Unix(0, int64(v)*int64(time.Millisecond))
+	}
+	return time.Time{}
+}
+
+// comparer compares the values in the BSON comparison order. Strings are compared
+// with the case-insensitive collation if it's set.
+type comparer struct {
+	collator *collate.Collator
+}
+
+func newComparer(caseInsensitive bool) *comparer {
+	c := &comparer{}
+	if caseInsensitive {
+		c.collator = collate.New(language.English, collate.IgnoreCase)
+	}
+	return c
+}
+
+func (c *comparer) compare(a, b interface{}) int {
+	rankA, rankB := rank(a), rank(b)
+	if rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch rankA {
+	case rankNull:
+		return 0
+	case rankNumber:
+		numberA, _ := toNumber(a)
+		numberB, _ := toNumber(b)
+		return compareNumbers(numberA, numberB)
+	case rankString:
+		return c.compareStrings(toString(a), toString(b))
+	case rankDocument:
+		docA, _ := AsDocument(a)
+		docB, _ := AsDocument(b)
+		return c.compareDocuments(docA, docB)
+	case rankArray:
+		arrayA, _ := AsArray(a)
+		arrayB, _ := AsArray(b)
+		for i := 0; i < len(arrayA) && i < len(arrayB); i++ {
+			if result := c.compare(arrayA[i], arrayB[i]); result != 0 {
+				return result
+			}
+		}
+		return len(arrayA) - len(arrayB)
+	case rankBinary:
+		return bytes.Compare(toBytes(a), toBytes(b))
+	case rankObjectID:
+		idA, idB := a.(primitive.ObjectID), b.(primitive.ObjectID)
+		return bytes.Compare(idA[:], idB[:])
+	case rankBoolean:
+		boolA, boolB := a.(bool), b.(bool)
+		if boolA == boolB {
+			return 0
+		} else if boolA {
+			return 1
+		}
+		return -1
+	case rankDate:
+		return toTime(a).Compare(toTime(b))
+	case rankTimestamp:
+		tsA, tsB := a.(primitive.Timestamp), b.(primitive.Timestamp)
+		if tsA.T != tsB.T {
+			return int(tsA.T) - int(tsB.T)
+		}
+		return int(tsA.I) - int(tsB.I)
+	case rankRegex:
+		regexA, regexB := a.(primitive.Regex), b.(primitive.Regex)
+		return strings.Compare(regexA.Pattern+"/"+regexA.Options, regexB.Pattern+"/"+regexB.Options)
+	}
+	return 0
+}
+
+func (c *comparer) compareStrings(a, b string) int {
+	if c.collator != nil {
+		return c.collator.CompareString(a, b)
+	}
+	return strings.Compare(a, b)
+}
+
+// compareDocuments compares the fields of the documents in the order of their keys
+// since the maps don't keep the order of the fields.
+func (c *comparer) compareDocuments(a, b map[string]interface{}) int {
+	keysA, keysB := sortedKeys(a), sortedKeys(b)
+	for i := 0; i < len(keysA) && i < len(keysB); i++ {
+		if result := strings.Compare(keysA[i], keysB[i]); result != 0 {
+			return result
+		}
+		if result := c.compare(a[keysA[i]], b[keysB[i]]); result != 0 {
+			return result
+		}
+	}
+	return len(keysA) - len(keysB)
+}
+
+func (c *comparer) equal(a, b interface{}) bool {
+	return c.compare(a, b) == 0
+}
+
+func sortedKeys(doc map[string]interface{}) []string {
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case primitive.Symbol:
+		return string(v)
+	}
+	return ""
+}
+
+func toBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case primitive.Binary:
+		return v.Data
+	}
+	return nil
+}
+
+func toFloat(n int) *big.Float {
+	return new(big.Float).SetInt64(int64(n))
+}
@@ -1,5 +1,7 @@
 package model
 
+import "github.com/devingen/api-core/internal/match"
+
 type Field map[string]interface{}
 
 type FieldType string
@@ -15,6 +17,13 @@ const (
 	FieldTypeRelationReference FieldType = "relation-reference"
 	FieldTypeCollectionLookup  FieldType = "collection-lookup"
 	FieldTypeDataTransfer      FieldType = "data-transfer"
+	FieldTypeFormula           FieldType = "formula"
+	FieldTypeRollup            FieldType = "rollup"
+	FieldTypeGeo               FieldType = "geo"
+	FieldTypeSelect            FieldType = "select"
+	FieldTypeMultiSelect       FieldType = "multi-select"
+	FieldTypeObject            FieldType = "object"
+	FieldTypeArray             FieldType = "array"
 )
 
 func New(fieldType FieldType, id string) Field {
@@ -44,16 +53,33 @@ func (field Field) GetType() FieldType {
 	return fieldType
 }
 
+// GetValueType returns the type of the values of the field, which is the result type
+// for the formula and rollup fields and the type of the field for the others.
+func (field Field) GetValueType() FieldType {
+	switch field.GetType() {
+	case FieldTypeFormula:
+		return FormulaFromField(field).GetResultType()
+	case FieldTypeRollup:
+		return RollupFromField(field).GetResultType()
+	}
+	return field.GetType()
+}
+
+// GetFilter returns the filter that is either set as a Filter or decoded from JSON or
+// BSON as a document.
 func (field Field) GetFilter(name string) *Filter {
-	value, has := field.GetInterface(name).(*Filter)
-	if !has {
-		valueInterface, hasInterface := field.GetInterface(name).(map[string]interface{})
-		if !hasInterface {
-			return nil
-		}
-		return FilterFromMap(valueInterface)
+	switch value := field.GetInterface(name).(type) {
+	case *Filter:
+		return value
+	case Filter:
+		return &value
 	}
-	return value
+
+	valueInterface, hasInterface := match.AsDocument(field.GetInterface(name))
+	if !hasInterface {
+		return nil
+	}
+	return FilterFromMap(valueInterface)
 }
 
 func (field Field) SetString(key, value string) Field {
@@ -79,6 +105,11 @@ func (field Field) GetInt(key string) int {
 	switch v := field[key].(type) {
 	case int:
 		return v
+	case int32:
+		// int values are populated as int32 or int64 when it's decoded from BSON
+		return int(v)
+	case int64:
+		return int(v)
 	case float64:
 		// int values are populated as float64 when it's parsed from JSON
 		return int(v)
@@ -121,15 +152,22 @@ func (field Field) SetFieldsForKey(key string, fields []Field) Field {
 func (field Field) GetFieldsForKey(key string) []Field {
 	value, has := field.GetInterface(key).([]Field)
 	if !has {
-		valueInterface, hasInterface := field.GetInterface(key).([]interface{})
-		if !hasInterface {
-			return nil
-		}
-		fields := make([]Field, len(valueInterface))
-		for i, filterMap := range valueInterface {
-			fields[i] = filterMap.(map[string]interface{})
-		}
-		return fields
+		return fieldsFromArray(field.GetInterface(key))
 	}
 	return value
 }
+
+// fieldsFromArray converts the fields decoded from JSON or BSON into a Field list.
+func fieldsFromArray(value interface{}) []Field {
+	valueInterface, hasInterface := match.AsArray(value)
+	if !hasInterface {
+		return nil
+	}
+	fields := make([]Field, 0, len(valueInterface))
+	for _, fieldMap := range valueInterface {
+		if fieldMap, isMap := match.AsDocument(fieldMap); isMap {
+			fields = append(fields, fieldMap)
+		}
+	}
+	return fields
+}
@@ -1,5 +1,7 @@
 package model
 
+import "go.mongodb.org/mongo-driver/bson"
+
 type CollectionLookupField struct {
 	Field
 }
@@ -38,6 +40,31 @@ func (field CollectionLookupField) IsSingle() bool {
 	return field.Field.GetBool("isSingle")
 }
 
+// GetAs returns the name of the field that keeps the looked up documents.
+// It's the local field unless the field has an id.
+func (field CollectionLookupField) GetAs() string {
+	if id := field.Field.GetID(); id != "" {
+		return id
+	}
+	return field.GetLocalField()
+}
+
+// ToLookupStages generates the stages that populate the field with the documents
+// whose foreign field matches the local field. It doesn't have a filter, so the
+// config is not used.
+func (field CollectionLookupField) ToLookupStages(config *QueryConfig) []bson.M {
+	stages := []bson.M{{"$lookup": bson.M{
+		"from":         field.GetFrom(),
+		"localField":   field.GetLocalField(),
+		"foreignField": field.GetForeignField(),
+		"as":           field.GetAs(),
+	}}}
+	if field.IsSingle() {
+		stages = append(stages, unwindStage(field.GetAs()))
+	}
+	return stages
+}
+
 func (field CollectionLookupField) ToField() Field {
 	return field.Field
 }
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	core "github.com/devingen/api-core"
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CursorSecret is the key that the pagination cursors are signed with. It must be set
+// before the cursors are used, so the clients can't forge cursors to read the values
+// of the fields that they can't see. The cursors are rejected with a DVNError with
+// status 501 if it's not set.
+var CursorSecret []byte
+
+var errCursorSecretNotSet = core.NewError(http.StatusNotImplemented, "cursor-pagination-not-enabled")
+
+// Cursor is the position of a document in the list sorted by the sort configs. It
+// contains the values of the sort fields and the _id of the document.
+type Cursor struct {
+	Values []interface{}
+	ID     interface{}
+}
+
+// cursorPayload is the signed content of a cursor token. The sort configs are kept
+// to reject the cursors that are created for another sort.
+type cursorPayload struct {
+	Sort   string        `bson:"s"`
+	Values []interface{} `bson:"v"`
+	ID     interface{}   `bson:"id"`
+}
+
+// cursorSort returns the sort configs of the cursor pagination, which always end with
+// _id to make the order of the documents that have the same values unique.
+func cursorSort(sort []SortConfig) []SortConfig {
+	for _, s := range sort {
+		if s.ID == "_id" {
+			return sort
+		}
+	}
+	return append(append(make([]SortConfig, 0, len(sort)+1), sort...), SortConfig{ID: "_id", Order: 1})
+}
+
+func sortKey(sort []SortConfig) string {
+	keys := make([]string, len(sort))
+	for i, s := range sort {
+		keys[i] = s.ID + ":" + strconv.Itoa(sortDirection(s.Order))
+	}
+	return strings.Join(keys, ",")
+}
+
+func sortDirection(order int) int {
+	if order < 0 {
+		return -1
+	}
+	return 1
+}
+
+// EncodeCursor creates the signed cursor token of the data model for the sort configs.
+func EncodeCursor(sort []SortConfig, dm DataModel) (string, error) {
+	if len(CursorSecret) == 0 {
+		return "", errCursorSecretNotSet
+	}
+
+	payload := cursorPayload{Sort: sortKey(sort), Values: make([]interface{}, 0, len(sort)), ID: dm["_id"]}
+	for _, s := range sort {
+		if s.ID == "_id" {
+			continue
+		}
+		var value interface{}
+		if values := match.Lookup(dm, s.ID); len(values) > 0 {
+			value = values[0]
+		}
+		payload.Values = append(payload.Values, value)
+	}
+
+	data, err := bson.MarshalExtJSON(payload, true, false)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(signCursor(data)), nil
+}
+
+// DecodeCursor verifies the signature of the cursor token and returns the cursor. It
+// returns a DVNError with status 400 if the token is invalid or it's created for
+// another sort.
+func DecodeCursor(sort []SortConfig, token string) (*Cursor, error) {
+	if len(CursorSecret) == 0 {
+		return nil, errCursorSecretNotSet
+	}
+
+	invalidCursor := core.NewError(http.StatusBadRequest, "invalid-cursor")
+	encodedData, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, invalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encodedData)
+	if err != nil {
+		return nil, invalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, signCursor(data)) {
+		return nil, invalidCursor
+	}
+
+	var payload cursorPayload
+	if err := bson.UnmarshalExtJSON(data, true, &payload); err != nil {
+		return nil, invalidCursor
+	}
+	if payload.Sort != sortKey(sort) {
+		return nil, core.NewError(http.StatusBadRequest, "cursor-sort-mismatch")
+	}
+	return &Cursor{Values: payload.Values, ID: payload.ID}, nil
+}
+
+func signCursor(data []byte) []byte {
+	mac := hmac.New(sha256.New, CursorSecret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// ToMatchQuery generates the query that matches the documents that come after the
+// cursor in the order of the sort configs, or before the cursor if before is true.
+// The sort configs must be the ones that the cursor is created for.
+// Ex: { "$or": [ { "name": { "$gt": "x" } }, { "name": "x", "_id": { "$gt": id } } ] }
+func (c *Cursor) ToMatchQuery(sort []SortConfig, before bool) bson.M {
+	conditions := make([]bson.M, 0, len(sort))
+	equals := bson.M{}
+	values := c.Values
+	for _, s := range sort {
+		value := c.ID
+		if s.ID != "_id" && len(values) > 0 {
+			value, values = values[0], values[1:]
+		}
+
+		ascending := sortDirection(s.Order) > 0
+		if before {
+			ascending = !ascending
+		}
+		if condition := comesAfter(s.ID, value, ascending); condition != nil {
+			for id, equal := range equals {
+				condition[id] = equal
+			}
+			conditions = append(conditions, condition)
+		}
+		equals[s.ID] = value
+	}
+	if len(conditions) == 0 {
+		// there isn't any value after the nulls in the descending order
+		return bson.M{"_id": bson.M{"$in": bson.A{}}}
+	}
+	return bson.M{"$or": conditions}
+}
+
+// comesAfter returns the condition of the values that come after the value in the
+// order. The nulls and the missing values are the smallest values in MongoDB.
+func comesAfter(id string, value interface{}, ascending bool) bson.M {
+	if value == nil {
+		if ascending {
+			return bson.M{id: bson.M{"$ne": nil}}
+		}
+		return nil
+	}
+	if ascending {
+		return bson.M{id: bson.M{"$gt": value}}
+	}
+	return bson.M{"$or": []bson.M{
+		{id: bson.M{"$lt": value}},
+		{id: nil},
+	}}
+}
@@ -0,0 +1,296 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrPathNotFound is returned by the path accessors of DataModel if there isn't any
+// value at the path.
+var ErrPathNotFound = errors.New("path not found")
+
+// ErrInvalidType is returned by the path accessors of DataModel if the value at the
+// path or a value on the path can't be converted into the type.
+var ErrInvalidType = errors.New("invalid type")
+
+func invalidTypeError(path string, value interface{}, expected string) error {
+	return fmt.Errorf("%w: %s is %T, not %s", ErrInvalidType, path, value, expected)
+}
+
+// Get returns the value at the dotted path. The numeric parts are the indexes of the
+// arrays on the path. Ex: dm.Get("items.0.price")
+func (dm DataModel) Get(path string) (interface{}, error) {
+	var value interface{} = dm
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		if doc, isDocument := match.AsDocument(value); isDocument {
+			child, has := doc[part]
+			if !has {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+			}
+			value = child
+			continue
+		}
+		if array, isArray := match.AsArray(value); isArray {
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(array) {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+			}
+			value = array[index]
+			continue
+		}
+		return nil, invalidTypeError(strings.Join(parts[:i], "."), value, "a document or an array")
+	}
+	return value, nil
+}
+
+// Set sets the value at the dotted path. The missing documents on the path are
+// created, and the value is appended if the last part is the length of the array.
+// Ex: dm.Set("items.2", item) appends the item to an array of 2 items
+func (dm DataModel) Set(path string, value interface{}) error {
+	_, err := setAtPath(map[string]interface{}(dm), strings.Split(path, "."), "", value)
+	return err
+}
+
+// Delete deletes the value at the dotted path. The elements after a deleted element
+// of an array are shifted.
+func (dm DataModel) Delete(path string) error {
+	_, err := deleteAtPath(map[string]interface{}(dm), strings.Split(path, "."), "")
+	return err
+}
+
+// WritableDocument returns the map of the documents that are decoded from JSON or
+// BSON, which can be modified in place.
+func WritableDocument(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case DataModel:
+		return v, true
+	case map[string]interface{}:
+		return v, true
+	case primitive.M:
+		return v, true
+	}
+	return nil, false
+}
+
+// writableArray returns the elements of the arrays that can be modified and whether
+// the array is a primitive.A, so it can be converted back.
+func writableArray(value interface{}) ([]interface{}, bool, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, false, true
+	case primitive.A:
+		return v, true, true
+	}
+	return nil, false, false
+}
+
+// setAtPath sets the value in the container, which is a document or an array, and
+// returns the container since appending to an array creates a new one.
+func setAtPath(container interface{}, parts []string, parent string, value interface{}) (interface{}, error) {
+	part, path := parts[0], joinPath(parent, parts[0])
+	if doc, isDocument := WritableDocument(container); isDocument {
+		if len(parts) == 1 {
+			doc[part] = value
+			return doc, nil
+		}
+		child, has := doc[part]
+		if !has || child == nil {
+			child = map[string]interface{}{}
+		}
+		child, err := setAtPath(child, parts[1:], path, value)
+		if err != nil {
+			return nil, err
+		}
+		doc[part] = child
+		return doc, nil
+	}
+
+	array, isA, isArray := writableArray(container)
+	if !isArray {
+		return nil, invalidTypeError(parent, container, "a document or an array")
+	}
+	index, err := strconv.Atoi(part)
+	if err != nil || index < 0 || index > len(array) {
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+	}
+	if index == len(array) {
+		if len(parts) > 1 {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+		}
+		array = append(array, value)
+	} else if len(parts) == 1 {
+		array[index] = value
+	} else {
+		child, err := setAtPath(array[index], parts[1:], path, value)
+		if err != nil {
+			return nil, err
+		}
+		array[index] = child
+	}
+	if isA {
+		return primitive.A(array), nil
+	}
+	return array, nil
+}
+
+func deleteAtPath(container interface{}, parts []string, parent string) (interface{}, error) {
+	part, path := parts[0], joinPath(parent, parts[0])
+	if doc, isDocument := WritableDocument(container); isDocument {
+		child, has := doc[part]
+		if !has {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+		}
+		if len(parts) == 1 {
+			delete(doc, part)
+			return doc, nil
+		}
+		child, err := deleteAtPath(child, parts[1:], path)
+		if err != nil {
+			return nil, err
+		}
+		doc[part] = child
+		return doc, nil
+	}
+
+	array, isA, isArray := writableArray(container)
+	if !isArray {
+		return nil, invalidTypeError(parent, container, "a document or an array")
+	}
+	index, err := strconv.Atoi(part)
+	if err != nil || index < 0 || index >= len(array) {
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+	}
+	if len(parts) == 1 {
+		array = append(array[:index:index], array[index+1:]...)
+	} else {
+		child, err := deleteAtPath(array[index], parts[1:], path)
+		if err != nil {
+			return nil, err
+		}
+		array[index] = child
+	}
+	if isA {
+		return primitive.A(array), nil
+	}
+	return array, nil
+}
+
+func joinPath(parent, part string) string {
+	if parent == "" {
+		return part
+	}
+	return parent + "." + part
+}
+
+// GetTime returns the date at the path, which is either a time.Time, a BSON date or an
+// RFC3339 string.
+func (dm DataModel) GetTime(path string) (time.Time, error) {
+	value, err := dm.Get(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, isTime := toTime(value)
+	if !isTime {
+		return time.Time{}, invalidTypeError(path, value, "a date")
+	}
+	return t, nil
+}
+
+// GetInt returns the whole number at the path. The numbers are decoded as float64
+// from JSON and as int32 or int64 from BSON.
+func (dm DataModel) GetInt(path string) (int, error) {
+	value, err := dm.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	number, isNumber := ParseNumber(value, "")
+	if _, isString := value.(string); isString || !isNumber || !isInteger(number) {
+		return 0, invalidTypeError(path, value, "an integer")
+	}
+	if n, isInt := number.(int64); isInt {
+		return int(n), nil
+	}
+	// the large numbers are float64 and the decimals are Decimal128
+	f := numberToFloat(number)
+	if math.Abs(f) >= math.MaxInt64 {
+		return 0, invalidTypeError(path, value, "an integer")
+	}
+	return int(f), nil
+}
+
+// GetFloat returns the number at the path as float64. The Decimal128 numbers may lose
+// their precision.
+func (dm DataModel) GetFloat(path string) (float64, error) {
+	value, err := dm.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	number, isNumber := ParseNumber(value, "")
+	if _, isString := value.(string); isString || !isNumber {
+		return 0, invalidTypeError(path, value, "a number")
+	}
+	return numberToFloat(number), nil
+}
+
+// GetBool returns the boolean at the path.
+func (dm DataModel) GetBool(path string) (bool, error) {
+	value, err := dm.Get(path)
+	if err != nil {
+		return false, err
+	}
+	b, isBool := value.(bool)
+	if !isBool {
+		return false, invalidTypeError(path, value, "a boolean")
+	}
+	return b, nil
+}
+
+// GetObjectID returns the ObjectID at the path, which is either an ObjectID or its
+// hex string.
+func (dm DataModel) GetObjectID(path string) (primitive.ObjectID, error) {
+	value, err := dm.Get(path)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		return v, nil
+	case string:
+		if id, err := primitive.ObjectIDFromHex(v); err == nil {
+			return id, nil
+		}
+	}
+	return primitive.NilObjectID, invalidTypeError(path, value, "an ObjectID")
+}
+
+// GetStringSlice returns the strings of the array at the path.
+func (dm DataModel) GetStringSlice(path string) ([]string, error) {
+	value, err := dm.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if values, isStrings := value.([]string); isStrings {
+		return values, nil
+	}
+	items, isArray := match.AsArray(value)
+	if !isArray {
+		return nil, invalidTypeError(path, value, "an array")
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		s, isString := item.(string)
+		if !isString {
+			return nil, invalidTypeError(path+"."+strconv.Itoa(i), item, "a string")
+		}
+		values[i] = s
+	}
+	return values, nil
+}
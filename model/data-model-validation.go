@@ -0,0 +1,368 @@
+package model
+
+import (
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	core "github.com/devingen/api-core"
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UnknownFieldPolicy string
+
+const (
+	// UnknownFieldsReject reports the keys that are not in the fields.
+	UnknownFieldsReject UnknownFieldPolicy = "reject"
+
+	// UnknownFieldsStrip deletes the keys that are not in the fields.
+	UnknownFieldsStrip UnknownFieldPolicy = "strip"
+
+	// UnknownFieldsAllow keeps the keys that are not in the fields as they are.
+	UnknownFieldsAllow UnknownFieldPolicy = "allow"
+)
+
+// Validate checks the data model against the fields before it's written to the
+// database and rejects the keys that are not in the fields. See ValidateWithPolicy.
+func Validate(dm DataModel, fields []Field) error {
+	return ValidateWithPolicy(dm, fields, UnknownFieldsReject)
+}
+
+// ValidateWithPolicy checks the data model against the fields before it's written to
+// the database and converts the values decoded from JSON into the types that are
+// stored. The dates are parsed from RFC3339 strings, the numbers are converted like
+// ParseNumber does and the references are converted into DBRefs from the hex strings
+// of their ids. The default values of the fields are copied to the missing keys. The
+// keys that are not in the fields are handled by the policy, and the reserved keys
+// such as _id are always allowed.
+//
+// The fields can have the following rules in addition to their types:
+//   - required: the value can't be missing or null
+//   - min, max: the bounds of the numbers and the dates, which are RFC3339 strings
+//   - minLength, maxLength, pattern: the length and the regular expression of the texts
+//   - minItems, maxItems: the length of the arrays and the multi-select values, whose
+//     options can't be repeated
+//   - default: the value of the missing key
+//
+// It returns a DVNError with status 400 that contains a message for each invalid
+// value, which starts with the path of the value. Ex: "lines.0.price:min:0"
+func ValidateWithPolicy(dm DataModel, fields []Field, policy UnknownFieldPolicy) error {
+	messages := validateDocument(dm, fields, "", policy)
+	if len(messages) > 0 {
+		return core.NewErrors(http.StatusBadRequest, messages)
+	}
+	return nil
+}
+
+func validateDocument(doc map[string]interface{}, fields []Field, prefix string, policy UnknownFieldPolicy) []string {
+	messages := make([]string, 0)
+	known := map[string]bool{}
+	for _, field := range fields {
+		id := field.GetID()
+		known[id] = true
+		path := prefix + id
+
+		value, has := doc[id]
+		if !has && field.GetInterface("default") != nil {
+			// the documents and the arrays of the default are shared by the fields
+			value = copyValue(field.GetInterface("default"))
+			doc[id] = value
+		}
+		if value == nil {
+			if field.GetBool("required") {
+				messages = append(messages, path+":required")
+			}
+			continue
+		}
+		if isReadOnlyFieldType(field.GetType()) {
+			messages = append(messages, path+":read-only")
+			continue
+		}
+
+		coerced, fieldMessages := validateFieldValue(field, value, path, policy)
+		if len(fieldMessages) > 0 {
+			messages = append(messages, fieldMessages...)
+			continue
+		}
+		doc[id] = coerced
+	}
+
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		if !known[key] && !strings.HasPrefix(key, "_") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch policy {
+		case UnknownFieldsReject:
+			messages = append(messages, prefix+key+":unknown-field")
+		case UnknownFieldsStrip:
+			delete(doc, key)
+		}
+	}
+	return messages
+}
+
+// validateFieldValue returns the value converted into the type of the field by the
+// CoerceValue of the definition of its type and the messages if it's invalid. The
+// value is not null. The values of the other types are kept as they are.
+func validateFieldValue(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+	if definition, has := GetFieldTypeDefinition(field.GetType()); has && definition.CoerceValue != nil {
+		return definition.CoerceValue(field, value, path, policy)
+	}
+	return value, nil
+}
+
+func invalidTypeMessages(field Field, path string) []string {
+	return []string{path + ":invalid-type:" + string(field.GetType())}
+}
+
+func coerceText(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+	s, isString := value.(string)
+	if !isString {
+		return nil, invalidTypeMessages(field, path)
+	}
+	return s, validateText(field, s, path)
+}
+
+func coerceNumber(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+	if _, isString := value.(string); isString {
+		return nil, invalidTypeMessages(field, path)
+	}
+	format := NumberFromField(field).GetFormat()
+	number, isNumber := ParseNumber(value, format)
+	if !isNumber {
+		return nil, invalidTypeMessages(field, path)
+	}
+	if format == NumberFormatInteger && !isInteger(number) {
+		return nil, []string{path + ":invalid-type:integer"}
+	}
+	return number, validateBounds(field, path, func(bound interface{}) (int, bool) {
+		b, isNumber := ParseNumber(bound, "")
+		f, bf := numberToFloat(number), numberToFloat(b)
+		switch {
+		case f < bf:
+			return -1, isNumber
+		case f > bf:
+			return 1, isNumber
+		}
+		return 0, isNumber
+	})
+}
+
+func coerceBoolean(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+	if _, isBool := value.(bool); !isBool {
+		return nil, invalidTypeMessages(field, path)
+	}
+	return value, nil
+}
+
+func coerceDate(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+	t, isDate := toTime(value)
+	if !isDate {
+		return nil, invalidTypeMessages(field, path)
+	}
+	return t, validateBounds(field, path, func(bound interface{}) (int, bool) {
+		b, isDate := toTime(bound)
+		switch {
+		case t.Before(b):
+			return -1, isDate
+		case t.After(b):
+			return 1, isDate
+		}
+		return 0, isDate
+	})
+}
+
+func coerceSelect(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+	selectField := SelectFromField(field)
+	if selectField.IsMulti() {
+		items, isArray := match.AsArray(value)
+		if !isArray {
+			return nil, invalidTypeMessages(field, path)
+		}
+		if messages := validateItemCount(field, len(items), path); len(messages) > 0 {
+			return nil, messages
+		}
+		value = items
+	}
+	messages := make([]string, 0)
+	for _, invalid := range selectField.invalidValues(value, selectField.IsMulti()) {
+		messages = append(messages, path+":invalid-option:"+invalid)
+	}
+	if selectField.IsMulti() {
+		// the options are unique like the uniqueItems of the schema
+		seen := map[string]bool{}
+		for _, item := range value.([]interface{}) {
+			option, isString := item.(string)
+			if !isString {
+				continue
+			}
+			if seen[option] {
+				messages = append(messages, path+":duplicate-option:"+option)
+			}
+			seen[option] = true
+		}
+	}
+	return value, messages
+}
+
+func coerceGeo(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+	doc, isDocument := match.AsDocument(value)
+	if _, isGeo := match.GeoPoints(value); !isDocument || !isGeo {
+		return nil, invalidTypeMessages(field, path)
+	}
+	if shape := GeoFromField(field).GetShape(); shape != "" && !strings.EqualFold(stringValue(doc["type"]), string(shape)) {
+		return nil, []string{path + ":invalid-type:" + string(shape)}
+	}
+	return value, nil
+}
+
+func coerceObject(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+	doc, isDocument := match.AsDocument(value)
+	if !isDocument {
+		return nil, invalidTypeMessages(field, path)
+	}
+	return doc, validateDocument(doc, field.GetFields(), path+".", policy)
+}
+
+func coerceArray(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+	items, isArray := match.AsArray(value)
+	if !isArray {
+		return nil, invalidTypeMessages(field, path)
+	}
+	if messages := validateItemCount(field, len(items), path); len(messages) > 0 {
+		return nil, messages
+	}
+	itemsField := ArrayFromField(field).GetItems()
+	if itemsField == nil {
+		return items, nil
+	}
+	messages := make([]string, 0)
+	coerced := make([]interface{}, len(items))
+	for i, item := range items {
+		itemPath := path + "." + strconv.Itoa(i)
+		if item == nil {
+			if itemsField.GetBool("required") {
+				messages = append(messages, itemPath+":required")
+			}
+			continue
+		}
+		c, itemMessages := validateFieldValue(*itemsField, item, itemPath, policy)
+		messages = append(messages, itemMessages...)
+		coerced[i] = c
+	}
+	return coerced, messages
+}
+
+func validateText(field Field, s string, path string) []string {
+	messages := make([]string, 0)
+	length := utf8.RuneCountInString(s)
+	if field.GetInterface("minLength") != nil && length < field.GetInt("minLength") {
+		messages = append(messages, path+":min-length:"+strconv.Itoa(field.GetInt("minLength")))
+	}
+	if field.GetInterface("maxLength") != nil && length > field.GetInt("maxLength") {
+		messages = append(messages, path+":max-length:"+strconv.Itoa(field.GetInt("maxLength")))
+	}
+	if pattern := field.GetString("pattern"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil || !re.MatchString(s) {
+			messages = append(messages, path+":pattern")
+		}
+	}
+	return messages
+}
+
+// validateBounds checks the value against the min and max of the field with the
+// function, which returns -1, 0 or 1 if the value is less than, equal to or greater
+// than the bound, and false if the bound is invalid.
+func validateBounds(field Field, path string, compare func(bound interface{}) (int, bool)) []string {
+	messages := make([]string, 0)
+	for _, rule := range []string{"min", "max"} {
+		bound := field.GetInterface(rule)
+		if bound == nil {
+			continue
+		}
+		order, isValid := compare(bound)
+		if !isValid || (rule == "min" && order < 0) || (rule == "max" && order > 0) {
+			messages = append(messages, path+":"+rule+":"+stringValue(bound))
+		}
+	}
+	return messages
+}
+
+func validateItemCount(field Field, count int, path string) []string {
+	messages := make([]string, 0)
+	if field.GetInterface("minItems") != nil && count < field.GetInt("minItems") {
+		messages = append(messages, path+":min-items:"+strconv.Itoa(field.GetInt("minItems")))
+	}
+	if field.GetInterface("maxItems") != nil && count > field.GetInt("maxItems") {
+		messages = append(messages, path+":max-items:"+strconv.Itoa(field.GetInt("maxItems")))
+	}
+	return messages
+}
+
+// toReference converts the reference, or the array of the references if it's not a
+// single reference, into DBRefs. A reference is either the hex string of the id, an
+// ObjectID, a DBRef or a document that has the id in _id or $id.
+func toReference(field ReferenceField, value interface{}, path string) (interface{}, []string) {
+	if !field.IsSingle() {
+		items, isArray := match.AsArray(value)
+		if !isArray {
+			return nil, []string{path + ":invalid-type:" + string(FieldTypeReference)}
+		}
+		refs := make([]interface{}, len(items))
+		messages := make([]string, 0)
+		for i, item := range items {
+			ref, isRef := ToDBRef(field.GetOtherCollection(), item)
+			if !isRef {
+				messages = append(messages, path+"."+strconv.Itoa(i)+":invalid-type:"+string(FieldTypeReference))
+			}
+			refs[i] = ref
+		}
+		return refs, messages
+	}
+	ref, isRef := ToDBRef(field.GetOtherCollection(), value)
+	if !isRef {
+		return nil, []string{path + ":invalid-type:" + string(FieldTypeReference)}
+	}
+	return ref, nil
+}
+
+// toTime converts the dates of the Go and BSON types and the RFC3339 strings.
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case primitive.DateTime:
+		return v.Time(), true
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// numberToFloat converts a number parsed by ParseNumber into float64.
+func numberToFloat(number interface{}) float64 {
+	switch n := number.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(n.String(), 64)
+		if err == nil {
+			return f
+		}
+	}
+	return math.NaN()
+}
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	core "github.com/devingen/api-core"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValidate(t *testing.T) {
+	owner := NewReference("owner", "users", true).ToField()
+	owner["required"] = true
+	title := New(FieldTypeText, "title").SetInt("maxLength", 20).SetString("pattern", "^[A-Z]")
+	price := NewNumber("price", NumberFormatDecimal).ToField()
+	price["min"] = 0
+	quantity := NewNumber("quantity", NumberFormatInteger).ToField()
+	quantity["default"] = 1
+	dueDate := New(FieldTypeDate, "dueDate").SetString("min", "2020-01-01T00:00:00Z")
+	fields := []Field{
+		title, price, quantity, dueDate, owner,
+		New(FieldTypeBoolean, "paid"),
+		NewSelect("status", []SelectOption{{Value: "open"}, {Value: "closed"}}).ToField(),
+		NewMultiSelect("tags", []SelectOption{{Value: "new"}, {Value: "paid"}}).ToField(),
+		NewReference("watchers", "users", false).ToField(),
+		NewObject("address", []Field{New(FieldTypeText, "city")}).ToField(),
+		NewArray("lines", NewObject("", []Field{NewNumber("price", "").ToField().SetInt("min", 0)}).ToField()).ToField(),
+		NewRollup("total", "lines", "order", RollupFunctionSum, "price").ToField(),
+	}
+
+	var dm DataModel
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"_id": "5f1d7f3e2b7c4a0001a1b2c3",
+		"title": "Order",
+		"price": 9.99,
+		"dueDate": "2021-03-01T09:00:00+03:00",
+		"owner": "5f1d7f3e2b7c4a0001a1b2c4",
+		"paid": false,
+		"status": "open",
+		"watchers": ["5f1d7f3e2b7c4a0001a1b2c5", { "_id": "5f1d7f3e2b7c4a0001a1b2c6" }],
+		"address": { "city": "Istanbul" },
+		"lines": [{ "price": 4 }]
+	}`), &dm))
+	assert.Nil(t, Validate(dm, fields))
+
+	ownerID, _ := primitive.ObjectIDFromHex("5f1d7f3e2b7c4a0001a1b2c4")
+	watcherID, _ := primitive.ObjectIDFromHex("5f1d7f3e2b7c4a0001a1b2c6")
+	decimalPrice, _ := primitive.ParseDecimal128("9.99")
+	assert.Equal(t, decimalPrice, dm["price"])
+	assert.Equal(t, int64(1), dm["quantity"])
+	assert.Equal(t, time.Date(2021, 3, 1, 6, 0, 0, 0, time.UTC), dm["dueDate"].(time.Time).UTC())
+	assert.Equal(t, DBRef{Ref: "users", ID: ownerID}, dm["owner"])
+	assert.Equal(t, DBRef{Ref: "users", ID: watcherID}, dm["watchers"].([]interface{})[1])
+	assert.Equal(t, []interface{}{map[string]interface{}{"price": int64(4)}}, dm["lines"])
+	assert.Equal(t, "5f1d7f3e2b7c4a0001a1b2c3", dm["_id"])
+
+	invalid := DataModel{
+		"title":    "order with a long title",
+		"price":    -1,
+		"quantity": 1.5,
+		"dueDate":  "2019-12-31T23:59:59Z",
+		"paid":     "no",
+		"status":   "archived",
+		"tags":     []interface{}{"new", "paid", "new"},
+		"watchers": []interface{}{"me"},
+		"address":  map[string]interface{}{"city": 34, "zip": "34000"},
+		"lines":    []interface{}{map[string]interface{}{"price": -4}},
+		"total":    10,
+		"color":    "red",
+	}
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{
+		"title:max-length:20",
+		"title:pattern",
+		"price:min:0",
+		"quantity:invalid-type:integer",
+		"dueDate:min:2020-01-01T00:00:00Z",
+		"owner:required",
+		"paid:invalid-type:boolean",
+		"status:invalid-option:archived",
+		"tags:duplicate-option:new",
+		"watchers.0:invalid-type:reference",
+		"address.city:invalid-type:text",
+		"address.zip:unknown-field",
+		"lines.0.price:min:0",
+		"total:read-only",
+		"color:unknown-field",
+	}), Validate(invalid, fields))
+
+	stripped := DataModel{"title": "Order", "owner": ownerID, "color": "red"}
+	assert.Nil(t, ValidateWithPolicy(stripped, fields, UnknownFieldsStrip))
+	assert.Equal(t, DataModel{"title": "Order", "quantity": int64(1), "owner": DBRef{Ref: "users", ID: ownerID}}, stripped)
+}
+
+func TestValidateDateBounds(t *testing.T) {
+	// the bounds are compared in nanoseconds, which a float64 can't hold
+	fields := []Field{New(FieldTypeDate, "startsAt").
+		SetString("min", "2020-01-01T00:00:00.000000001Z").
+		SetString("max", "2020-01-01T00:00:00.000000003Z")}
+
+	assert.Nil(t, Validate(DataModel{"startsAt": "2020-01-01T00:00:00.000000002Z"}, fields))
+	assert.Equal(t,
+		core.NewErrors(http.StatusBadRequest, []string{"startsAt:min:2020-01-01T00:00:00.000000001Z"}),
+		Validate(DataModel{"startsAt": "2020-01-01T00:00:00Z"}, fields),
+	)
+	assert.Equal(t,
+		core.NewErrors(http.StatusBadRequest, []string{"startsAt:max:2020-01-01T00:00:00.000000003Z"}),
+		Validate(DataModel{"startsAt": "2020-01-01T00:00:00.000000004Z"}, fields),
+	)
+}
+
+func TestValidateObjectDefault(t *testing.T) {
+	settings := NewObject("settings", []Field{New(FieldTypeText, "theme"), New(FieldTypeText, "language")}).ToField()
+	settings["default"] = map[string]interface{}{"theme": "light"}
+	fields := []Field{settings}
+
+	first := DataModel{}
+	assert.Nil(t, Validate(first, fields))
+	first["settings"].(map[string]interface{})["theme"] = "dark"
+
+	second := DataModel{}
+	assert.Nil(t, Validate(second, fields))
+	assert.Equal(t, map[string]interface{}{"theme": "light"}, second["settings"])
+	assert.Equal(t, map[string]interface{}{"theme": "light"}, settings["default"])
+}
@@ -15,12 +15,22 @@ import (
 
 type DataModel map[string]interface{}
 
+// GetID returns the _id of the data model, which is the hex string of the ObjectID
+// if it's decoded from BSON.
 func (dm DataModel) GetID() string {
-	id, has := dm["_id"].(string)
-	if !has {
-		return ""
+	switch id := dm["_id"].(type) {
+	case string:
+		return id
+	case primitive.ObjectID:
+		return id.Hex()
 	}
-	return id
+	return ""
+}
+
+// Copy returns a deep copy of the data model, which doesn't share its documents and
+// arrays.
+func (dm DataModel) Copy() DataModel {
+	return copyValue(dm).(DataModel)
 }
 
 func (dm DataModel) GetFieldCount() int {
@@ -97,15 +107,7 @@ func (dm DataModel) SetFieldsForKey(key string, fields []Field) DataModel {
 func (dm DataModel) GetFieldsForKey(key string) []Field {
 	value, has := dm.GetInterface(key).([]Field)
 	if !has {
-		valueInterface, hasInterface := dm.GetInterface(key).([]interface{})
-		if !hasInterface {
-			return nil
-		}
-		fields := make([]Field, len(valueInterface))
-		for i, filterMap := range valueInterface {
-			fields[i] = filterMap.(map[string]interface{})
-		}
-		return fields
+		return fieldsFromArray(dm.GetInterface(key))
 	}
 	return value
 }
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDataModelGetID(t *testing.T) {
+	id := primitive.NewObjectID()
+	assert.Equal(t, id.Hex(), DataModel{"_id": id}.GetID())
+	assert.Equal(t, "5f1d7f3e2b7c4a0001a1b2c3", DataModel{"_id": "5f1d7f3e2b7c4a0001a1b2c3"}.GetID())
+	assert.Equal(t, "", DataModel{}.GetID())
+}
+
+func TestDataModelPathAccessors(t *testing.T) {
+	id := primitive.NewObjectID()
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	encoded, err := bson.Marshal(bson.M{
+		"_id":       id,
+		"createdAt": createdAt,
+		"items":     bson.A{bson.M{"price": 9.5, "quantity": int32(2)}},
+		"tags":      bson.A{"a", "b"},
+	})
+	assert.Nil(t, err)
+	var fromBSON DataModel
+	assert.Nil(t, bson.Unmarshal(encoded, &fromBSON))
+
+	var fromJSON DataModel
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"_id": "`+id.Hex()+`",
+		"createdAt": "2024-05-01T12:00:00Z",
+		"items": [{ "price": 9.5, "quantity": 2 }],
+		"tags": ["a", "b"]
+	}`), &fromJSON))
+
+	for _, dm := range []DataModel{fromBSON, fromJSON} {
+		objectID, err := dm.GetObjectID("_id")
+		assert.Nil(t, err)
+		assert.Equal(t, id, objectID)
+		at, err := dm.GetTime("createdAt")
+		assert.Nil(t, err)
+		assert.True(t, createdAt.Equal(at))
+		price, err := dm.GetFloat("items.0.price")
+		assert.Nil(t, err)
+		assert.Equal(t, 9.5, price)
+		quantity, err := dm.GetInt("items.0.quantity")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, quantity)
+		tags, err := dm.GetStringSlice("tags")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b"}, tags)
+	}
+
+	dm := fromJSON
+	_, err = dm.GetInt("items.0.price")
+	assert.True(t, errors.Is(err, ErrInvalidType))
+	_, err = dm.GetBool("items.0.price")
+	assert.EqualError(t, err, "invalid type: items.0.price is float64, not a boolean")
+	_, err = dm.Get("items.1.price")
+	assert.True(t, errors.Is(err, ErrPathNotFound))
+	_, err = dm.Get("tags.0.name")
+	assert.True(t, errors.Is(err, ErrInvalidType))
+
+	assert.Nil(t, dm.Set("items.0.price", 12))
+	assert.Nil(t, dm.Set("items.1", map[string]interface{}{"price": 3}))
+	assert.Nil(t, dm.Set("shipping.address.city", "Istanbul"))
+	assert.Nil(t, dm.Delete("tags.0"))
+	assert.Nil(t, dm.Delete("items.0.quantity"))
+	assert.True(t, errors.Is(dm.Set("items.3", 1), ErrPathNotFound))
+	assert.True(t, errors.Is(dm.Set("createdAt.day", 1), ErrInvalidType))
+	assert.True(t, errors.Is(dm.Delete("shipping.phone"), ErrPathNotFound))
+	assert.Equal(t, []interface{}{map[string]interface{}{"price": 12}, map[string]interface{}{"price": 3}}, dm["items"])
+	assert.Equal(t, map[string]interface{}{"address": map[string]interface{}{"city": "Istanbul"}}, dm["shipping"])
+	assert.Equal(t, []interface{}{"b"}, dm["tags"])
+}
@@ -1,9 +1,60 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type DBRef struct {
 	Ref      string             `bson:"_ref" json:"_ref"`
 	ID       primitive.ObjectID `bson:"_id" json:"_id"`
 	Database string             `bson:"_db" json:"_db"`
 }
+
+// ToDBRef converts the value of a reference to the collection into a DBRef. The value
+// is either a DBRef, an ObjectID or its hex string, or a document that has the id in
+// _id or $id and optionally the collection and the database in _ref and _db or $ref
+// and $db. The collection is used if the document doesn't have one. It's used by
+// Validate for the values that are written and by the loaders for the values that
+// are read, so both accept the same references.
+func ToDBRef(collection string, value interface{}) (DBRef, bool) {
+	ref := DBRef{Ref: collection}
+	switch v := value.(type) {
+	case DBRef:
+		return v, true
+	case *DBRef:
+		if v == nil {
+			return ref, false
+		}
+		return *v, true
+	case primitive.ObjectID:
+		ref.ID = v
+		return ref, true
+	case string:
+		id, err := primitive.ObjectIDFromHex(v)
+		ref.ID = id
+		return ref, err == nil
+	}
+	doc, isDocument := match.AsDocument(value)
+	if !isDocument {
+		return ref, false
+	}
+	for _, key := range []string{"_ref", "$ref"} {
+		if collection, isString := doc[key].(string); isString {
+			ref.Ref = collection
+		}
+	}
+	for _, key := range []string{"_db", "$db"} {
+		if database, isString := doc[key].(string); isString {
+			ref.Database = database
+		}
+	}
+	for _, key := range []string{"_id", "$id"} {
+		if doc[key] != nil {
+			idRef, isRef := ToDBRef(collection, doc[key])
+			ref.ID = idRef.ID
+			return ref, isRef
+		}
+	}
+	return ref, false
+}
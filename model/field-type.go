@@ -0,0 +1,238 @@
+package model
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FieldTypeDefinition defines how the fields of a type are queried, validated and
+// populated. The built-in types are registered by the package and the domain-specific
+// types such as phone or money can be registered with RegisterFieldType. The fields
+// whose types are not registered and the nil functions of a definition behave like
+// the fields that are not in the config.
+//
+// A type can reuse the definition of a built-in type by changing a copy of it.
+// Ex:
+//
+//	email, _ := model.GetFieldTypeDefinition(model.FieldTypeText)
+//	email.CoerceValue = coerceEmail
+//	model.RegisterFieldType("email", email)
+type FieldTypeDefinition struct {
+	// Comparisons are the comparisons that Filter.Validate accepts for the fields. The
+	// comparisons of the untyped fields are accepted if it's nil.
+	Comparisons []Comparison
+
+	// MatchQuery generates the $match query of the filter on the field. The comparisons
+	// that don't depend on the type, such as empty and starts-with, are compiled before
+	// it's called. The formula and rollup fields use the definition of their result
+	// type.
+	MatchQuery func(c Filter, field Field, config *QueryConfig) bson.M
+
+	// ValidateFilterValue returns the reasons why the value of the filter on the field
+	// is invalid, which are prefixed with the path of the filter by Filter.Validate.
+	// It's called after the shape of the value is checked for the comparisons such as
+	// in and between. Ex: "invalid-value:expected-number"
+	ValidateFilterValue func(c Filter, field Field) []string
+
+	// CoerceValue converts the value of the field, which is not null, into the type
+	// that is stored and returns the messages if it's invalid. It's called by Validate
+	// for the values of the data models. Ex: "price:min:0"
+	CoerceValue func(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string)
+
+	// LookupStages generates the stages that populate the field with the time zone and
+	// the clock of the config. See LookupStages.
+	LookupStages func(field Field, config *QueryConfig) []bson.M
+
+	// JSONSchema generates the schema of the values of the field without null for
+	// JSONSchema and MongoJSONSchema. The schema is empty if it's nil.
+	JSONSchema func(c JSONSchemaConverter, field Field) bson.M
+
+	// StoredAs is the built-in type whose values the fields store, which is one of
+	// text, number, boolean and date, such as FieldTypeText for an email or a select
+	// type. The backends that don't run the MatchQuery, such as the sqldb package,
+	// store the values and compile the filters on the fields like the ones of this
+	// type, with the Comparisons of the field's type.
+	StoredAs FieldType
+
+	// ReadOnly is true if the values of the fields are populated by the lookups or
+	// computed in the pipeline, so they can't be written.
+	ReadOnly bool
+
+	// HasInnerFields is true if the fields can be filtered and projected by the paths
+	// of their inner fields. Ex: { "id": "organisation.name" }
+	HasInnerFields bool
+}
+
+var (
+	fieldTypes      = map[FieldType]FieldTypeDefinition{}
+	fieldTypesMutex sync.RWMutex
+)
+
+// RegisterFieldType registers the definition of the field type. It replaces the
+// definition if the type is already registered, including the built-in types.
+func RegisterFieldType(fieldType FieldType, definition FieldTypeDefinition) {
+	fieldTypesMutex.Lock()
+	defer fieldTypesMutex.Unlock()
+	fieldTypes[fieldType] = definition
+}
+
+// UnregisterFieldType removes the definition of the field type, so its fields behave
+// like the ones whose types are not registered.
+func UnregisterFieldType(fieldType FieldType) {
+	fieldTypesMutex.Lock()
+	defer fieldTypesMutex.Unlock()
+	delete(fieldTypes, fieldType)
+}
+
+// GetFieldTypeDefinition returns the definition of the field type and whether it's
+// registered.
+func GetFieldTypeDefinition(fieldType FieldType) (FieldTypeDefinition, bool) {
+	fieldTypesMutex.RLock()
+	defer fieldTypesMutex.RUnlock()
+	definition, has := fieldTypes[fieldType]
+	return definition, has
+}
+
+func isReadOnlyFieldType(fieldType FieldType) bool {
+	definition, _ := GetFieldTypeDefinition(fieldType)
+	return definition.ReadOnly
+}
+
+func hasInnerFields(fieldType FieldType) bool {
+	definition, _ := GetFieldTypeDefinition(fieldType)
+	return definition.HasInnerFields
+}
+
+func init() {
+	RegisterFieldType(FieldTypeAny, FieldTypeDefinition{HasInnerFields: true})
+	RegisterFieldType(FieldTypeDataTransfer, FieldTypeDefinition{HasInnerFields: true})
+	RegisterFieldType(FieldTypeText, FieldTypeDefinition{
+		Comparisons: []Comparison{
+			ComparisonEq, ComparisonNe, ComparisonLt, ComparisonLte, ComparisonGt, ComparisonGte, ComparisonIn, ComparisonNin,
+			ComparisonContain, ComparisonNcontain, ComparisonStartsWith, ComparisonEndsWith, ComparisonRegex,
+			ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+		},
+		MatchQuery:          Filter.textMatchQuery,
+		ValidateFilterValue: Filter.validateTextValue,
+		CoerceValue:         coerceText,
+		JSONSchema:          JSONSchemaConverter.textSchema,
+		StoredAs:            FieldTypeText,
+	})
+	RegisterFieldType(FieldTypeNumber, FieldTypeDefinition{
+		Comparisons: []Comparison{
+			ComparisonEq, ComparisonNe, ComparisonLt, ComparisonLte, ComparisonGt, ComparisonGte, ComparisonIn, ComparisonNin,
+			ComparisonBetween, ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+		},
+		MatchQuery:          Filter.numberMatchQuery,
+		ValidateFilterValue: Filter.validateNumberValue,
+		CoerceValue:         coerceNumber,
+		JSONSchema:          JSONSchemaConverter.numberSchema,
+		StoredAs:            FieldTypeNumber,
+	})
+	RegisterFieldType(FieldTypeBoolean, FieldTypeDefinition{
+		Comparisons: []Comparison{
+			ComparisonEq, ComparisonNe, ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+		},
+		MatchQuery:          Filter.valueMatchQuery,
+		ValidateFilterValue: Filter.validateBooleanValue,
+		CoerceValue:         coerceBoolean,
+		JSONSchema:          JSONSchemaConverter.booleanSchema,
+		StoredAs:            FieldTypeBoolean,
+	})
+	RegisterFieldType(FieldTypeDate, FieldTypeDefinition{
+		Comparisons: append([]Comparison{
+			ComparisonEq, ComparisonNe, ComparisonLt, ComparisonLte, ComparisonGt, ComparisonGte,
+			ComparisonBetween, ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+		}, dateRangeComparisons...),
+		MatchQuery:          Filter.dateMatchQuery,
+		ValidateFilterValue: Filter.validateDateValue,
+		CoerceValue:         coerceDate,
+		JSONSchema:          JSONSchemaConverter.dateSchema,
+		StoredAs:            FieldTypeDate,
+	})
+	RegisterFieldType(FieldTypeSelect, FieldTypeDefinition{
+		Comparisons: []Comparison{
+			ComparisonEq, ComparisonNe, ComparisonIn, ComparisonNin, ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+		},
+		MatchQuery:          Filter.selectMatchQuery,
+		ValidateFilterValue: Filter.validateSelectValue,
+		CoerceValue:         coerceSelect,
+		JSONSchema:          JSONSchemaConverter.selectSchema,
+		StoredAs:            FieldTypeText,
+	})
+	RegisterFieldType(FieldTypeMultiSelect, FieldTypeDefinition{
+		Comparisons: []Comparison{
+			ComparisonEq, ComparisonNe, ComparisonIn, ComparisonNin, ComparisonAll, ComparisonAny, ComparisonSize,
+			ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+		},
+		MatchQuery:          Filter.selectMatchQuery,
+		ValidateFilterValue: Filter.validateSelectValue,
+		CoerceValue:         coerceSelect,
+		JSONSchema:          JSONSchemaConverter.selectSchema,
+	})
+	RegisterFieldType(FieldTypeGeo, FieldTypeDefinition{
+		Comparisons: append([]Comparison{
+			ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+		}, geoComparisons...),
+		CoerceValue: coerceGeo,
+		JSONSchema:  JSONSchemaConverter.geoSchema,
+	})
+	RegisterFieldType(FieldTypeObject, FieldTypeDefinition{
+		Comparisons: []Comparison{
+			ComparisonEq, ComparisonNe, ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+		},
+		CoerceValue: coerceObject,
+		JSONSchema:  JSONSchemaConverter.objectSchema,
+	})
+	RegisterFieldType(FieldTypeArray, FieldTypeDefinition{
+		MatchQuery:  Filter.arrayMatchQuery,
+		CoerceValue: coerceArray,
+		JSONSchema:  JSONSchemaConverter.arraySchema,
+	})
+	RegisterFieldType(FieldTypeReference, FieldTypeDefinition{
+		CoerceValue: func(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+			return toReference(ReferenceFromField(field), value, path)
+		},
+		LookupStages: func(field Field, config *QueryConfig) []bson.M {
+			return ReferenceFromField(field).ToLookupStages(config)
+		},
+		JSONSchema:     JSONSchemaConverter.referenceSchema,
+		HasInnerFields: true,
+	})
+	RegisterFieldType(FieldTypeReverseReference, FieldTypeDefinition{
+		LookupStages: func(field Field, config *QueryConfig) []bson.M {
+			return ReverseReferenceFromField(field).ToLookupStages(config)
+		},
+		JSONSchema:     JSONSchemaConverter.lookupSchema,
+		ReadOnly:       true,
+		HasInnerFields: true,
+	})
+	RegisterFieldType(FieldTypeRelationReference, FieldTypeDefinition{
+		LookupStages: func(field Field, config *QueryConfig) []bson.M {
+			return SingleRelationReferenceFromField(field).ToLookupStages(config)
+		},
+		JSONSchema:     JSONSchemaConverter.lookupSchema,
+		ReadOnly:       true,
+		HasInnerFields: true,
+	})
+	RegisterFieldType(FieldTypeCollectionLookup, FieldTypeDefinition{
+		LookupStages: func(field Field, config *QueryConfig) []bson.M {
+			return CollectionLookupFieldFromField(field).ToLookupStages(config)
+		},
+		JSONSchema:     JSONSchemaConverter.lookupSchema,
+		ReadOnly:       true,
+		HasInnerFields: true,
+	})
+	RegisterFieldType(FieldTypeFormula, FieldTypeDefinition{
+		JSONSchema: JSONSchemaConverter.computedSchema,
+		ReadOnly:   true,
+	})
+	RegisterFieldType(FieldTypeRollup, FieldTypeDefinition{
+		LookupStages: func(field Field, config *QueryConfig) []bson.M {
+			return RollupFromField(field).ToLookupStages(config)
+		},
+		JSONSchema: JSONSchemaConverter.computedSchema,
+		ReadOnly:   true,
+	})
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	core "github.com/devingen/api-core"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRegisterFieldType(t *testing.T) {
+	const fieldTypeEmail FieldType = "test-email"
+	const fieldTypeOwner FieldType = "test-owner"
+	defer func() {
+		UnregisterFieldType(fieldTypeEmail)
+		UnregisterFieldType(fieldTypeOwner)
+	}()
+
+	// the email type is a text type that stores the addresses in lowercase
+	email, _ := GetFieldTypeDefinition(FieldTypeText)
+	email.Comparisons = []Comparison{ComparisonEq, ComparisonEndsWith, ComparisonContain}
+	email.CoerceValue = func(field Field, value interface{}, path string, policy UnknownFieldPolicy) (interface{}, []string) {
+		s, isString := value.(string)
+		if !isString || !strings.Contains(s, "@") {
+			return nil, []string{path + ":invalid-type:email"}
+		}
+		return strings.ToLower(s), nil
+	}
+	RegisterFieldType(fieldTypeEmail, email)
+	RegisterFieldType(fieldTypeOwner, FieldTypeDefinition{
+		LookupStages: func(field Field, config *QueryConfig) []bson.M {
+			return []bson.M{{"$set": bson.M{field.GetID(): "$$USER"}}}
+		},
+		ReadOnly:       true,
+		HasInnerFields: true,
+	})
+
+	config := &QueryConfig{Fields: []Field{New(fieldTypeEmail, "email"), New(fieldTypeOwner, "owner")}}
+	filter := Filter{FieldId: "email", Comparison: ComparisonContain, FieldValue: "a.b"}
+	assert.Equal(t, bson.M{"email": bson.M{"$regex": `a\.b`, "$options": "i"}}, filter.ToMatchQuery(config))
+	assert.Nil(t, filter.Validate(config))
+	assert.Nil(t, Filter{FieldId: "owner.name", Comparison: ComparisonEq, FieldValue: "Ada"}.Validate(config))
+	assert.Equal(t,
+		core.NewErrors(http.StatusBadRequest, []string{"filter:invalid-comparison-for-field-type:ne:test-email"}),
+		Filter{FieldId: "email", Comparison: ComparisonNe, FieldValue: "a@b.c"}.Validate(config),
+	)
+	assert.Equal(t,
+		core.NewErrors(http.StatusBadRequest, []string{"filter:invalid-value:expected-text"}),
+		Filter{FieldId: "email", Comparison: ComparisonEq, FieldValue: 1}.Validate(config),
+	)
+	assert.Equal(t, []bson.M{{"$set": bson.M{"owner": "$$USER"}}}, LookupStages(config.Fields, config))
+	assert.Equal(t,
+		bson.M{"type": bson.A{"string", "null"}},
+		JSONSchema(config.Fields, UnknownFieldsAllow)["properties"].(bson.M)["email"],
+	)
+
+	dm := DataModel{"email": "Ada@Example.com"}
+	assert.Nil(t, Validate(dm, config.Fields))
+	assert.Equal(t, "ada@example.com", dm["email"])
+	assert.Equal(t,
+		core.NewErrors(http.StatusBadRequest, []string{"email:invalid-type:email", "owner:read-only"}),
+		Validate(DataModel{"email": "ada", "owner": "x"}, config.Fields),
+	)
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// UnmarshalJSON decodes the filter by keeping the type of the value. The integers are
+// decoded as int64 to keep their precision and the other numbers as float64.
+func (c *Filter) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var filterMap map[string]interface{}
+	if err := decoder.Decode(&filterMap); err != nil {
+		return err
+	}
+	*c = *FilterFromMap(filterMap)
+	return nil
+}
+
+// UnmarshalBSONValue decodes the filter stored in MongoDB, including the ones stored
+// with the field names (fieldid, fieldvalue) before the bson tags were added.
+func (c *Filter) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.Null || t == bsontype.Undefined {
+		*c = Filter{}
+		return nil
+	}
+
+	var filterMap bson.M
+	if err := (bson.RawValue{Type: t, Value: data}).Unmarshal(&filterMap); err != nil {
+		return err
+	}
+	*c = *FilterFromMap(filterMap)
+	return nil
+}
+
+// UnmarshalBSON decodes the filter when it's unmarshalled as a top level document.
+func (c *Filter) UnmarshalBSON(data []byte) error {
+	return c.UnmarshalBSONValue(bsontype.EmbeddedDocument, data)
+}
+
+// normalizeValue converts the JSON numbers, BSON arrays and BSON documents in the value
+// into the types that are used by the filters decoded from JSON.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, bool, float64, int64:
+		return v
+	case int32:
+		return int64(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	}
+
+	if doc, isDocument := match.AsDocument(value); isDocument {
+		normalized := make(map[string]interface{}, len(doc))
+		for key, item := range doc {
+			normalized[key] = normalizeValue(item)
+		}
+		return normalized
+	}
+	if array, isArray := match.AsArray(value); isArray {
+		normalized := make([]interface{}, len(array))
+		for i, item := range array {
+			normalized[i] = normalizeValue(item)
+		}
+		return normalized
+	}
+	return value
+}
@@ -0,0 +1,373 @@
+package model
+
+import (
+	"net/http"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+
+	core "github.com/devingen/api-core"
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxFilterDepth is the maximum number of nested filter groups that Validate accepts.
+var MaxFilterDepth = 5
+
+// MaxRegexLength is the maximum length of the patterns of the regex comparisons.
+var MaxRegexLength = 256
+
+var dateRangeComparisons = []Comparison{
+	ComparisonDateEqDay, ComparisonDateNeDay,
+	ComparisonDateNextYear, ComparisonDateNextMonth, ComparisonDateNextWeek,
+	ComparisonDateThisYear, ComparisonDateThisMonth, ComparisonDateThisWeek,
+	ComparisonDateLastYear, ComparisonDateLastMonth, ComparisonDateLastWeek,
+	DateNextNumberOfDays, DatePastNumberOfDays,
+}
+
+var geoComparisons = []Comparison{
+	ComparisonNear, ComparisonWithinBox, ComparisonWithinPolygon, ComparisonWithinRadius,
+}
+
+// untypedComparisons are the comparisons that ToMatchQuery supports for the fields
+// that are not in the config and the types that don't define their comparisons.
+var untypedComparisons = append([]Comparison{
+	ComparisonEqMongoOID, ComparisonEq, ComparisonNe, ComparisonLt, ComparisonLte, ComparisonGt, ComparisonGte,
+	ComparisonIn, ComparisonNin, ComparisonBetween, ComparisonContain, ComparisonStartsWith, ComparisonEndsWith,
+	ComparisonRegex, ComparisonAll, ComparisonSize, ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+}, geoComparisons...)
+
+// Validate checks the filter against the fields of the config before it's converted
+// into a query. It checks the operators of the groups and their depth, whether the
+// filtered fields exist, the comparisons are supported by the types of the fields and
+// the values have the right type. The existence of the fields is checked only if the
+// config has fields, and the rollup fields must have their rolled up field. The near
+// comparison can be used once, either as the filter itself or as a filter of its
+// top-level and group. It returns a DVNError with status 400 that contains a message
+// for each invalid filter. Ex: "filter.filters[1]:unknown-field:price"
+func (c Filter) Validate(config *QueryConfig) error {
+	messages := append(c.validate(config, "filter", 1), c.validateNear("filter")...)
+	if len(messages) > 0 {
+		return core.NewErrors(http.StatusBadRequest, messages)
+	}
+	return nil
+}
+
+func (c Filter) validate(config *QueryConfig, path string, depth int) []string {
+	if c.Filters != nil || c.Operator != "" {
+		return c.validateGroup(config, path, depth)
+	}
+
+	if c.FieldId == "" {
+		return []string{path + ":missing-id"}
+	}
+	if c.Comparison == "" {
+		return []string{path + ":missing-comparison"}
+	}
+
+	field := config.GetField(c.FieldId)
+	if field == nil && config != nil && len(config.Fields) > 0 && !isInnerFieldOfConfig(config, c.FieldId) {
+		return []string{path + ":unknown-field:" + c.FieldId}
+	}
+
+	if field != nil && field.GetType() == FieldTypeRollup && !RollupFromField(*field).HasRollupField() {
+		return []string{path + ":rollup-field-required:" + c.FieldId}
+	}
+
+	if field != nil && field.GetType() == FieldTypeArray {
+		if messages, isArrayComparison := c.validateArray(config, ArrayFromField(*field), path, depth); isArrayComparison {
+			return messages
+		}
+		// the other comparisons are validated against the field of the elements
+		field = ArrayFromField(*field).GetItems()
+	}
+
+	comparisons := untypedComparisons
+	fieldType := FieldType("")
+	if field != nil {
+		fieldType = field.GetValueType()
+		if definition, has := GetFieldTypeDefinition(fieldType); has && definition.Comparisons != nil {
+			comparisons = definition.Comparisons
+		}
+	}
+	if !containsComparison(comparisons, c.Comparison) {
+		if fieldType == "" {
+			return []string{path + ":invalid-comparison:" + string(c.Comparison)}
+		}
+		return []string{path + ":invalid-comparison-for-field-type:" + string(c.Comparison) + ":" + string(fieldType)}
+	}
+
+	if reasons := c.validateValue(field); len(reasons) > 0 {
+		return prefixReasons(path, reasons)
+	}
+	if c.Comparison == ComparisonRegex {
+		if reason := checkRegex(stringValue(c.FieldValue)); reason != "" {
+			return []string{path + ":invalid-regex:" + reason}
+		}
+	}
+	return nil
+}
+
+func (c Filter) validateGroup(config *QueryConfig, path string, depth int) []string {
+	if depth > MaxFilterDepth {
+		return []string{path + ":max-depth-exceeded:" + strconv.Itoa(MaxFilterDepth)}
+	}
+
+	messages := make([]string, 0)
+	if c.Operator != OperatorAnd && c.Operator != OperatorOr {
+		messages = append(messages, path+":invalid-operator:"+string(c.Operator))
+	}
+	if len(c.Filters) == 0 {
+		messages = append(messages, path+":empty-filters")
+	}
+	for i, filter := range c.Filters {
+		messages = append(messages, filter.validate(config, path+".filters["+strconv.Itoa(i)+"]", depth+1)...)
+	}
+	return messages
+}
+
+// validateValue returns the reasons why the value is invalid for the comparison and
+// the field, which is nil if it's not in the config. The values of the comparisons
+// that don't depend on the type are checked here and the others are checked by the
+// ValidateFilterValue of the definition of the type of the field.
+func (c Filter) validateValue(field *Field) []string {
+	invalidValue := func(expected string) []string {
+		return []string{"invalid-value:expected-" + expected}
+	}
+	switch c.Comparison {
+	case ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+		ComparisonDateNextYear, ComparisonDateNextMonth, ComparisonDateNextWeek,
+		ComparisonDateThisYear, ComparisonDateThisMonth, ComparisonDateThisWeek,
+		ComparisonDateLastYear, ComparisonDateLastMonth, ComparisonDateLastWeek:
+		return nil
+	case ComparisonContain, ComparisonNcontain, ComparisonStartsWith, ComparisonEndsWith, ComparisonRegex:
+		if _, isString := c.FieldValue.(string); !isString {
+			return invalidValue("text")
+		}
+		return nil
+	case ComparisonEqMongoOID:
+		if _, err := primitive.ObjectIDFromHex(stringValue(c.FieldValue)); err != nil {
+			return invalidValue("object-id")
+		}
+		return nil
+	case ComparisonIn, ComparisonNin, ComparisonAll, ComparisonAny:
+		if _, isArray := match.AsArray(c.FieldValue); !isArray {
+			return invalidValue("array")
+		}
+	case ComparisonBetween:
+		if _, _, isRange := c.rangeValues(); !isRange {
+			return invalidValue("range")
+		}
+	case ComparisonNear:
+		if _, _, _, isValid := c.nearValue(); !isValid {
+			return invalidValue("point-and-distance")
+		}
+		return nil
+	case ComparisonWithinBox, ComparisonWithinPolygon, ComparisonWithinRadius:
+		if _, isValid := c.geoShape(); !isValid {
+			return invalidValue(map[Comparison]string{
+				ComparisonWithinBox:     "box",
+				ComparisonWithinPolygon: "polygon",
+				ComparisonWithinRadius:  "circle",
+			}[c.Comparison])
+		}
+		return nil
+	case ComparisonSize, DateNextNumberOfDays, DatePastNumberOfDays:
+		if !isNonNegativeInteger(c.FieldValue) {
+			return invalidValue("non-negative-integer")
+		}
+		return nil
+	}
+
+	if field == nil {
+		return nil
+	}
+	if definition, has := GetFieldTypeDefinition(field.GetValueType()); has && definition.ValidateFilterValue != nil {
+		return definition.ValidateFilterValue(c, *field)
+	}
+	return nil
+}
+
+// comparedValues returns the values that are compared with the values of the field,
+// which are the bounds of the between comparison. The values of the comparisons of
+// the arrays such as in are not returned.
+func (c Filter) comparedValues() []interface{} {
+	switch c.Comparison {
+	case ComparisonBetween:
+		min, max, _ := c.rangeValues()
+		return []interface{}{min, max}
+	case ComparisonIn, ComparisonNin, ComparisonAll, ComparisonAny:
+		return nil
+	}
+	return []interface{}{c.FieldValue}
+}
+
+func (c Filter) validateTextValue(field Field) []string {
+	for _, value := range c.comparedValues() {
+		if _, isString := value.(string); !isString && value != nil {
+			return []string{"invalid-value:expected-text"}
+		}
+	}
+	return nil
+}
+
+func (c Filter) validateNumberValue(field Field) []string {
+	format := NumberFromField(field).GetFormat()
+	for _, value := range c.comparedValues() {
+		number, isNumber := ParseNumber(value, format)
+		if !isNumber {
+			return []string{"invalid-value:expected-number"}
+		}
+		if format == NumberFormatInteger && !isInteger(number) {
+			return []string{"invalid-value:expected-integer"}
+		}
+	}
+	return nil
+}
+
+func (c Filter) validateBooleanValue(field Field) []string {
+	for _, value := range c.comparedValues() {
+		if _, isBool := value.(bool); !isBool {
+			return []string{"invalid-value:expected-boolean"}
+		}
+	}
+	return nil
+}
+
+func (c Filter) validateDateValue(field Field) []string {
+	for _, value := range c.comparedValues() {
+		if _, err := time.Parse(time.RFC3339, stringValue(value)); err != nil {
+			return []string{"invalid-value:expected-date"}
+		}
+	}
+	return nil
+}
+
+// validateArray validates the comparisons of the arrays themselves and reports false
+// for the other comparisons. The filters of the elem-match comparison are validated
+// against the inner fields of the elements, which must be objects.
+func (c Filter) validateArray(config *QueryConfig, field ArrayField, path string, depth int) ([]string, bool) {
+	items := field.GetItems()
+	switch c.Comparison {
+	case ComparisonAll, ComparisonAny, ComparisonSize:
+		// the values are validated by the type of the elements
+		return prefixReasons(path, c.validateValue(items)), true
+	case ComparisonElemMatch:
+		fields, hasFields := elementFields(field.Field)
+		if !hasFields {
+			return []string{path + ":invalid-comparison-for-field-type:" + string(c.Comparison) + ":" + string(FieldTypeArray)}, true
+		}
+		elem := c.elemFilter()
+		if elem == nil {
+			return []string{path + ":invalid-value:expected-filter"}, true
+		}
+		return elem.validate(elemConfig(config, fields), path+".value", depth+1), true
+	}
+	return nil, false
+}
+
+// validateSelectValue returns the reasons of the filter values that are not the
+// options of the select field. Ex: "invalid-option:archived"
+func (c Filter) validateSelectValue(field Field) []string {
+	reasons := make([]string, 0)
+	switch c.Comparison {
+	case ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull, ComparisonSize:
+		return reasons
+	}
+	isArray := c.Comparison == ComparisonIn || c.Comparison == ComparisonNin ||
+		c.Comparison == ComparisonAll || c.Comparison == ComparisonAny
+	for _, value := range SelectFromField(field).invalidValues(c.FieldValue, isArray) {
+		reasons = append(reasons, "invalid-option:"+value)
+	}
+	return reasons
+}
+
+// isInnerFieldOfConfig reports whether the id is an inner field of a field that can
+// be filtered by its inner fields or a system field like _id.
+func isInnerFieldOfConfig(config *QueryConfig, id string) bool {
+	if strings.HasPrefix(id, "_") {
+		return true
+	}
+	dot := strings.Index(id, ".")
+	if dot == -1 {
+		return false
+	}
+	field := config.GetField(id[:dot])
+	if field == nil {
+		return false
+	}
+	if innerFields, hasInnerFields := elementFields(*field); hasInnerFields {
+		// the paths in the object fields are resolved by GetField if they have fields
+		return len(innerFields) == 0
+	}
+	return hasInnerFields(field.GetType())
+}
+
+// prefixReasons converts the reasons of ValidateFilterValue into the messages of the
+// filter with the path.
+func prefixReasons(path string, reasons []string) []string {
+	messages := make([]string, len(reasons))
+	for i, reason := range reasons {
+		messages[i] = path + ":" + reason
+	}
+	return messages
+}
+
+func containsComparison(comparisons []Comparison, comparison Comparison) bool {
+	for _, c := range comparisons {
+		if c == comparison {
+			return true
+		}
+	}
+	return false
+}
+
+func isNonNegativeInteger(value interface{}) bool {
+	switch v := value.(type) {
+	case int:
+		return v >= 0
+	case int32:
+		return v >= 0
+	case int64:
+		return v >= 0
+	case float64:
+		return v >= 0 && v == float64(int64(v))
+	case string:
+		n, err := strconv.Atoi(v)
+		return err == nil && n >= 0
+	}
+	return false
+}
+
+// checkRegex returns the reason if the pattern of a regex comparison is not safe to
+// run on the database. The patterns must be short, compile as RE2, which doesn't
+// support the constructs like backreferences, and must not have nested quantifiers
+// like (a+)+ that cause catastrophic backtracking in MongoDB.
+func checkRegex(pattern string) string {
+	if len(pattern) > MaxRegexLength {
+		return "too-long"
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "syntax"
+	}
+	if hasNestedQuantifier(re, false) {
+		return "nested-quantifier"
+	}
+	return ""
+}
+
+func hasNestedQuantifier(re *syntax.Regexp, inQuantifier bool) bool {
+	isQuantifier := re.Op == syntax.OpStar || re.Op == syntax.OpPlus ||
+		(re.Op == syntax.OpRepeat && (re.Max == -1 || re.Max > 1))
+	if isQuantifier && inQuantifier {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if hasNestedQuantifier(sub, inQuantifier || isQuantifier) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,12 @@
 package model
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 	"time"
 
+	"github.com/devingen/api-core/internal/match"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -33,6 +35,16 @@ const (
 	ComparisonDifferent  Comparison = "different"
 	ComparisonEmpty      Comparison = "empty"
 	ComparisonNotEmpty   Comparison = "nempty"
+	ComparisonBetween    Comparison = "between"
+	ComparisonNin        Comparison = "nin"
+	ComparisonStartsWith Comparison = "starts-with"
+	ComparisonEndsWith   Comparison = "ends-with"
+	ComparisonRegex      Comparison = "regex"
+	ComparisonAll        Comparison = "all"
+	ComparisonAny        Comparison = "any"
+	ComparisonElemMatch  Comparison = "elem-match"
+	ComparisonSize       Comparison = "size"
+	ComparisonIsNull     Comparison = "is-null"
 
 	ComparisonDateEqDay     Comparison = "date-eq-day"
 	ComparisonDateNeDay     Comparison = "date-ne-day"
@@ -47,15 +59,20 @@ const (
 	ComparisonDateLastWeek  Comparison = "date-last-week"
 	DateNextNumberOfDays    Comparison = "date-next-number-of-days"
 	DatePastNumberOfDays    Comparison = "date-past-number-of-days"
+
+	ComparisonNear          Comparison = "near"
+	ComparisonWithinBox     Comparison = "within-box"
+	ComparisonWithinPolygon Comparison = "within-polygon"
+	ComparisonWithinRadius  Comparison = "within-radius"
 )
 
 type Filter struct {
-	Comparison Comparison  `json:"comparison"`
-	FieldId    string      `json:"id"`
-	FieldValue interface{} `json:"value"`
+	Comparison Comparison  `bson:"comparison,omitempty" json:"comparison"`
+	FieldId    string      `bson:"id,omitempty" json:"id"`
+	FieldValue interface{} `bson:"value" json:"value"`
 
-	Filters  []Filter `json:"filters"`
-	Operator Operator `json:"operator"`
+	Filters  []Filter `bson:"filters,omitempty" json:"filters"`
+	Operator Operator `bson:"operator,omitempty" json:"operator"`
 }
 
 var specialDateConditions = map[Comparison]bool{
@@ -75,12 +92,16 @@ var specialDateConditions = map[Comparison]bool{
 }
 
 // nonNegativeIntFromValue converts various JSON-decoded numeric representations
-// (int, float64, string) into a non-negative int. If parsing fails, returns 0.
+// (int, int64, float64, string) into a non-negative int. If parsing fails, returns 0.
 func nonNegativeIntFromValue(v interface{}) int {
 	var n int
 	switch t := v.(type) {
 	case int:
 		n = t
+	case int32:
+		n = int(t)
+	case int64:
+		n = int(t)
 	case float64:
 		// numbers parsed from JSON may come as float64
 		n = int(t)
@@ -95,15 +116,31 @@ func nonNegativeIntFromValue(v interface{}) int {
 	return n
 }
 
-func getDateFilters(filter Filter) (start time.Time, end time.Time) {
-	base := time.Now()
-	loc := base.Location()
+// stringValue returns the value as a string without panicking for the other types.
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// getDateFilters computes the time range of the date comparison in the time zone of
+// the config, relative to the current time of the config's clock.
+func getDateFilters(filter Filter, config *QueryConfig) (start time.Time, end time.Time) {
+	loc := config.GetLocation()
+	base := config.GetNow().In(loc)
+	weekStart := config.GetWeekStart()
 	switch filter.Comparison {
-	case ComparisonDateEqDay:
-		t, err := time.Parse(time.RFC3339, filter.FieldValue.(string))
+	case ComparisonDateEqDay, ComparisonDateNeDay:
+		t, err := time.Parse(time.RFC3339, stringValue(filter.FieldValue))
 		if err == nil {
-			start = time.Date(base.Year(), base.Month(), t.Day(), 0, 0, 0, 0, loc)
-			end = time.Date(base.Year(), base.Month(), t.Day(), 23, 59, 59, 999999999, loc)
+			// the day is the one that the given moment falls into in the time zone
+			t = t.In(loc)
+			start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+			end = start.AddDate(0, 0, 1).Add(-time.Nanosecond)
 		}
 	case DateNextNumberOfDays:
 		// Compute range from start of today to end of the day after adding N days (inclusive)
@@ -118,71 +155,56 @@ func getDateFilters(filter Filter) (start time.Time, end time.Time) {
 		start = startOfToday.AddDate(0, 0, -daysInt)
 		end = startOfToday.AddDate(0, 0, 1).Add(-time.Nanosecond)
 	case ComparisonDateNextYear:
-		y := base.Year() + 1
-		start = time.Date(y, time.January, 1, 0, 0, 0, 0, loc)
-		end = time.Date(y, time.December, 31, 23, 59, 59, 999999999, loc)
+		start = time.Date(base.Year()+1, time.January, 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(1, 0, 0).Add(-time.Nanosecond)
 	case ComparisonDateNextMonth:
-		y, m := base.Year(), base.Month()
-		if m == time.December {
-			y++
-			m = time.January
-		} else {
-			m++
-		}
-		start = time.Date(y, m, 1, 0, 0, 0, 0, loc)
-		// end is the last moment of that next month
-		y2, m2 := y, m
-		if m2 == time.December {
-			y2++
-			m2 = time.January
-		} else {
-			m2++
-		}
-		end = time.Date(y2, m2, 1, 0, 0, 0, 0, loc).Add(-time.Nanosecond)
+		// time.Date normalizes the 13th month into the January of the next year
+		start = time.Date(base.Year(), base.Month()+1, 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 1, 0).Add(-time.Nanosecond)
 	case ComparisonDateNextWeek:
-		b := base.AddDate(0, 0, 7)
-		weekday := int(b.Weekday())
-		daysSinceMonday := (weekday + 6) % 7
-		weekStart := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -daysSinceMonday)
-		start = weekStart
-		end = weekStart.AddDate(0, 0, 7).Add(-time.Nanosecond)
+		start = startOfWeek(base, weekStart).AddDate(0, 0, 7)
+		end = start.AddDate(0, 0, 7).Add(-time.Nanosecond)
 	case ComparisonDateThisYear:
 		start = time.Date(base.Year(), time.January, 1, 0, 0, 0, 0, loc)
-		end = time.Date(base.Year(), time.December, 31, 23, 59, 59, 999999999, loc)
+		end = start.AddDate(1, 0, 0).Add(-time.Nanosecond)
 	case ComparisonDateThisMonth:
-		y, m := base.Year(), base.Month()
-		start = time.Date(y, m, 1, 0, 0, 0, 0, loc)
+		start = time.Date(base.Year(), base.Month(), 1, 0, 0, 0, 0, loc)
 		end = start.AddDate(0, 1, 0).Add(-time.Nanosecond)
 	case ComparisonDateThisWeek:
-		weekday := int(base.Weekday())
-		daysSinceMonday := (weekday + 6) % 7
-		weekStart := time.Date(base.Year(), base.Month(), base.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -daysSinceMonday)
-		start = weekStart
-		end = weekStart.AddDate(0, 0, 7).Add(-time.Nanosecond)
+		start = startOfWeek(base, weekStart)
+		end = start.AddDate(0, 0, 7).Add(-time.Nanosecond)
 	case ComparisonDateLastYear:
 		start = time.Date(base.Year()-1, time.January, 1, 0, 0, 0, 0, loc)
-		end = time.Date(base.Year()-1, time.December, 31, 23, 59, 59, 999999999, loc)
+		end = start.AddDate(1, 0, 0).Add(-time.Nanosecond)
 	case ComparisonDateLastMonth:
-		y, m := base.Year(), base.Month()
-		if m == time.January {
-			y--
-			m = time.December
-		} else {
-			m--
-		}
-		start = time.Date(y, m, 1, 0, 0, 0, 0, loc)
+		// time.Date normalizes the 0th month into the December of the previous year
+		start = time.Date(base.Year(), base.Month()-1, 1, 0, 0, 0, 0, loc)
 		end = start.AddDate(0, 1, 0).Add(-time.Nanosecond)
 	case ComparisonDateLastWeek:
-		b := base.AddDate(0, 0, -7)
-		weekday := int(b.Weekday())
-		daysSinceMonday := (weekday + 6) % 7
-		weekStart := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -daysSinceMonday)
-		start = weekStart
-		end = weekStart.AddDate(0, 0, 7).Add(-time.Nanosecond)
+		start = startOfWeek(base, weekStart).AddDate(0, 0, -7)
+		end = start.AddDate(0, 0, 7).Add(-time.Nanosecond)
 	}
 	return
 }
 
+// startOfWeek returns the beginning of the week that t is in, in the time zone of t.
+func startOfWeek(t time.Time, weekStart time.Weekday) time.Time {
+	daysSinceWeekStart := (int(t.Weekday()) - int(weekStart) + 7) % 7
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).AddDate(0, 0, -daysSinceWeekStart)
+}
+
+// DateRange returns the inclusive time range of the relative date comparisons such
+// as date-this-week, computed in the time zone of the config. The date-ne-day
+// comparison matches the dates out of the range. It returns false for the other
+// comparisons.
+func (c Filter) DateRange(config *QueryConfig) (start time.Time, end time.Time, ok bool) {
+	if !specialDateConditions[c.Comparison] {
+		return start, end, false
+	}
+	start, end = getDateFilters(c, config)
+	return start, end, true
+}
+
 func (c Filter) ToMatchQuery(config *QueryConfig) bson.M {
 	if c.Filters != nil {
 		conditions := make([]bson.M, len(c.Filters))
@@ -192,75 +214,238 @@ func (c Filter) ToMatchQuery(config *QueryConfig) bson.M {
 		return bson.M{"$" + string(c.Operator): conditions}
 	}
 
-	if c.Comparison == ComparisonNotEmpty {
+	switch c.Comparison {
+	case ComparisonNotEmpty:
 		return bson.M{c.FieldId: bson.M{"$exists": true}}
-	} else if c.Comparison == ComparisonEmpty {
+	case ComparisonEmpty:
 		return bson.M{c.FieldId: bson.M{"$exists": false}}
+	case ComparisonIsNull:
+		// unlike empty, it doesn't match the documents that don't have the field
+		return bson.M{c.FieldId: bson.M{"$type": "null"}}
+	case ComparisonSize:
+		return bson.M{c.FieldId: bson.M{"$size": nonNegativeIntFromValue(c.FieldValue)}}
+	case ComparisonAll:
+		return bson.M{c.FieldId: bson.M{"$all": c.FieldValue}}
+	case ComparisonStartsWith, ComparisonEndsWith, ComparisonRegex:
+		return bson.M{c.FieldId: c.regexCondition()}
+	case ComparisonNear, ComparisonWithinBox, ComparisonWithinPolygon, ComparisonWithinRadius:
+		return bson.M{c.FieldId: c.geoCondition()}
+	}
+
+	query := c.fieldMatchQuery(config.GetField(c.FieldId), config)
+	if condition, isRange := query[c.FieldId].(bson.M); isRange && len(query) == 1 && isRangeCondition(condition) && config != nil {
+		// the bounds must match the same element of the arrays on the path. Ex: "lines.price"
+		return elemMatchQuery(config.Fields, c.FieldId, condition)
+	}
+	return query
+}
+
+// isRangeCondition returns true if the condition has more than one bound, such as the
+// conditions of the between and the date range comparisons.
+func isRangeCondition(condition bson.M) bool {
+	if len(condition) < 2 {
+		return false
 	}
+	for operator := range condition {
+		switch operator {
+		case "$gt", "$gte", "$lt", "$lte":
+		default:
+			return false
+		}
+	}
+	return true
+}
 
-	field := config.GetField(c.FieldId)
+// fieldMatchQuery generates the query of the comparison with the MatchQuery of the
+// definition of the type of the field, which is nil if the field is not in the config.
+func (c Filter) fieldMatchQuery(field *Field, config *QueryConfig) bson.M {
 	if field == nil {
 		// it may be a filter for an inner field of a relation. Ex: { "fieldId": "organisation._id" }
 		if c.Comparison == ComparisonContain {
-			return bson.M{c.FieldId: bson.M{"$regex": regexp.QuoteMeta(c.FieldValue.(string)), "$options": "i"}}
+			return bson.M{c.FieldId: c.regexCondition()}
 		}
 		if c.Comparison == ComparisonEqMongoOID {
-			oid, _ := primitive.ObjectIDFromHex(c.FieldValue.(string))
+			oid, _ := primitive.ObjectIDFromHex(stringValue(c.FieldValue))
 			return bson.M{c.FieldId: bson.M{"$" + string(ComparisonEq): oid}}
 		}
-		return bson.M{c.FieldId: bson.M{"$" + string(c.Comparison): c.FieldValue}}
-	}
-	if field.GetType() == FieldTypeText {
-		if c.Comparison == ComparisonContain {
-			return bson.M{c.FieldId: bson.M{"$regex": regexp.QuoteMeta(c.FieldValue.(string)), "$options": "i"}}
-		}
-		if c.Comparison == ComparisonNcontain {
-			return bson.M{c.FieldId: bson.M{"$regex": "^((?!" + regexp.QuoteMeta(c.FieldValue.(string)) + ").)*$", "$options": "i"}}
+		if c.Comparison == ComparisonBetween {
+			min, max, _ := c.rangeValues()
+			return bson.M{c.FieldId: bson.M{"$gte": min, "$lte": max}}
 		}
 		return bson.M{c.FieldId: bson.M{"$" + string(c.Comparison): c.FieldValue}}
 	}
-	if field.GetType() == FieldTypeNumber {
-		numberValue := nonNegativeIntFromValue(c.FieldValue)
-		return bson.M{c.FieldId: bson.M{"$" + string(c.Comparison): numberValue}}
+	if definition, has := GetFieldTypeDefinition(field.GetValueType()); has && definition.MatchQuery != nil {
+		return definition.MatchQuery(c, *field, config)
 	}
-	if field.GetType() == FieldTypeBoolean {
+	return c.otherMatchQuery()
+}
+
+// otherMatchQuery generates the query of the comparisons that the type of the field
+// doesn't compile.
+func (c Filter) otherMatchQuery() bson.M {
+	if c.Comparison == ComparisonNe || c.Comparison == ComparisonNin {
 		return bson.M{c.FieldId: bson.M{"$" + string(c.Comparison): c.FieldValue}}
 	}
-	if field.GetType() == FieldTypeDate {
-		if c.Comparison == ComparisonDateNeDay {
-			t, err := time.Parse(time.RFC3339, c.FieldValue.(string))
-			if err == nil {
-				base := time.Now()
-				loc := base.Location()
-				start := time.Date(base.Year(), base.Month(), t.Day(), 0, 0, 0, 0, loc)
-				end := time.Date(base.Year(), base.Month(), t.Day(), 23, 59, 59, 999999999, loc)
-				return bson.M{
-					"$or": []bson.M{
-						{c.FieldId: bson.M{"$lt": start}}, // before start
-						{c.FieldId: bson.M{"$gt": end}},   // after end
-					},
-				}
-			}
+
+	// Who uses this default step?
+	return bson.M{c.FieldId: bson.M{"$regex": regexp.QuoteMeta(stringValue(c.FieldValue)), "$options": "i"}}
+}
+
+// valueMatchQuery compares the field with the value of the filter as it is.
+func (c Filter) valueMatchQuery(field Field, config *QueryConfig) bson.M {
+	return bson.M{c.FieldId: bson.M{"$" + string(c.Comparison): c.FieldValue}}
+}
+
+func (c Filter) textMatchQuery(field Field, config *QueryConfig) bson.M {
+	if c.Comparison == ComparisonContain || c.Comparison == ComparisonNcontain {
+		return bson.M{c.FieldId: c.regexCondition()}
+	}
+	return c.valueMatchQuery(field, config)
+}
+
+// numberMatchQuery compares the field with the values of the filter that are parsed
+// in the format of the field.
+func (c Filter) numberMatchQuery(field Field, config *QueryConfig) bson.M {
+	numberField := NumberFromField(field)
+	numberValue := func(value interface{}) interface{} {
+		// the invalid values, which are rejected by Validate, are compared as they are
+		if number, isNumber := numberField.ParseValue(value); isNumber {
+			return number
 		}
-		if specialDateConditions[c.Comparison] {
-			start, end := getDateFilters(c)
-			return bson.M{c.FieldId: bson.M{"$gte": start, "$lte": end}}
+		return value
+	}
+	if c.Comparison == ComparisonBetween {
+		min, max, _ := c.rangeValues()
+		return bson.M{c.FieldId: bson.M{"$gte": numberValue(min), "$lte": numberValue(max)}}
+	}
+	if c.Comparison == ComparisonIn || c.Comparison == ComparisonNin {
+		items, _ := match.AsArray(c.FieldValue)
+		numbers := make([]interface{}, len(items))
+		for i, item := range items {
+			numbers[i] = numberValue(item)
 		}
+		return bson.M{c.FieldId: bson.M{"$" + string(c.Comparison): numbers}}
+	}
+	return bson.M{c.FieldId: bson.M{"$" + string(c.Comparison): numberValue(c.FieldValue)}}
+}
 
-		// Fallback to standard single-date comparisons (eq, lt, lte, gt, gte, empty, nempty)
-		t, err := time.Parse(time.RFC3339, c.FieldValue.(string))
+func (c Filter) selectMatchQuery(field Field, config *QueryConfig) bson.M {
+	if c.Comparison == ComparisonAny {
+		// an array matches in if any of its elements is in the values
+		return bson.M{c.FieldId: bson.M{"$in": c.FieldValue}}
+	}
+	return c.valueMatchQuery(field, config)
+}
+
+func (c Filter) dateMatchQuery(field Field, config *QueryConfig) bson.M {
+	if c.Comparison == ComparisonDateNeDay {
+		_, err := time.Parse(time.RFC3339, stringValue(c.FieldValue))
 		if err == nil {
-			return bson.M{c.FieldId: bson.M{"$" + string(c.Comparison): t}}
+			start, end := getDateFilters(c, config)
+			return bson.M{
+				"$or": []bson.M{
+					{c.FieldId: bson.M{"$lt": start}}, // before start
+					{c.FieldId: bson.M{"$gt": end}},   // after end
+				},
+			}
 		}
 	}
-	if c.Comparison == ComparisonNe {
-		return bson.M{c.FieldId: bson.M{"$ne": c.FieldValue}}
+	if specialDateConditions[c.Comparison] {
+		start, end := getDateFilters(c, config)
+		return bson.M{c.FieldId: bson.M{"$gte": start, "$lte": end}}
+	}
+	if c.Comparison == ComparisonBetween {
+		min, max, _ := c.rangeValues()
+		start, _ := time.Parse(time.RFC3339, stringValue(min))
+		end, _ := time.Parse(time.RFC3339, stringValue(max))
+		return bson.M{c.FieldId: bson.M{"$gte": start, "$lte": end}}
 	}
 
-	// Who uses this default step?
-	return bson.M{c.FieldId: bson.M{"$regex": regexp.QuoteMeta(c.FieldValue.(string)), "$options": "i"}}
+	// Fallback to standard single-date comparisons (eq, lt, lte, gt, gte, empty, nempty)
+	t, err := time.Parse(time.RFC3339, stringValue(c.FieldValue))
+	if err == nil {
+		return bson.M{c.FieldId: bson.M{"$" + string(c.Comparison): t}}
+	}
+	return c.otherMatchQuery()
+}
+
+// arrayMatchQuery generates the queries of the arrays themselves. The other
+// comparisons match the arrays that have an element that matches them.
+func (c Filter) arrayMatchQuery(field Field, config *QueryConfig) bson.M {
+	items := ArrayFromField(field).GetItems()
+	switch c.Comparison {
+	case ComparisonAny:
+		return bson.M{c.FieldId: bson.M{"$in": c.FieldValue}}
+	case ComparisonElemMatch:
+		var fields []Field
+		if items != nil {
+			fields = items.GetFields()
+		}
+		elemQuery := bson.M{}
+		if elem := c.elemFilter(); elem != nil {
+			elemQuery = elem.ToMatchQuery(elemConfig(config, fields))
+		}
+		return bson.M{c.FieldId: bson.M{"$elemMatch": elemQuery}}
+	}
+	query := c.fieldMatchQuery(items, config)
+	if condition, isRange := query[c.FieldId].(bson.M); isRange && len(query) == 1 && isRangeCondition(condition) {
+		// the bounds must match the same element
+		return bson.M{c.FieldId: bson.M{"$elemMatch": condition}}
+	}
+	return query
 }
 
+// regexPattern returns the regular expression of the text comparisons. The value of
+// the regex comparison is used as is if it's safe, the others are escaped.
+func (c Filter) regexPattern() (string, bool) {
+	value := stringValue(c.FieldValue)
+	switch c.Comparison {
+	case ComparisonContain:
+		return regexp.QuoteMeta(value), true
+	case ComparisonNcontain:
+		return "^((?!" + regexp.QuoteMeta(value) + ").)*$", true
+	case ComparisonStartsWith:
+		return "^" + regexp.QuoteMeta(value), true
+	case ComparisonEndsWith:
+		return regexp.QuoteMeta(value) + "$", true
+	case ComparisonRegex:
+		return value, checkRegex(value) == ""
+	}
+	return "", false
+}
+
+// regexCondition returns the case-insensitive $regex condition of the text
+// comparisons. The unsafe regular expressions, which are rejected by Validate, don't
+// match any value.
+func (c Filter) regexCondition() bson.M {
+	pattern, isSafe := c.regexPattern()
+	if !isSafe {
+		return bson.M{"$in": bson.A{}}
+	}
+	return bson.M{"$regex": pattern, "$options": "i"}
+}
+
+// rangeValues returns the lower and upper bounds of the between comparison.
+func (c Filter) rangeValues() (min, max interface{}, ok bool) {
+	values, isArray := match.AsArray(c.FieldValue)
+	if !isArray || len(values) != 2 {
+		return nil, nil, false
+	}
+	return values[0], values[1], true
+}
+
+// Matches reports whether the data model matches the filter without querying the
+// database. The data model is evaluated against the query generated by ToMatchQuery
+// with the case-insensitive collation of Database.Aggregate, so the result is the
+// same as the one of the database. The filters that generate an invalid query
+// don't match any data model.
+func (c Filter) Matches(dm DataModel, config *QueryConfig) bool {
+	matched, err := match.Document(c.ToMatchQuery(config), dm, match.Options{CaseInsensitive: true})
+	return err == nil && matched
+}
+
+// ToFilterQuery generates the aggregation expression of the filter that can be used
+// as the cond of a $filter stage on the variable with the name.
 func (c Filter) ToFilterQuery(name string) bson.M {
 	if c.Filters != nil {
 		conditions := make([]bson.M, len(c.Filters))
@@ -269,24 +454,82 @@ func (c Filter) ToFilterQuery(name string) bson.M {
 		}
 		return bson.M{"$" + string(c.Operator): conditions}
 	}
-	return bson.M{"$" + string(c.Comparison): []interface{}{"$$" + name + "." + c.FieldId, c.FieldValue}}
+
+	path := "$$" + name + "." + c.FieldId
+	switch c.Comparison {
+	case ComparisonBetween:
+		min, max, _ := c.rangeValues()
+		return bson.M{"$and": []bson.M{
+			{"$gte": []interface{}{path, min}},
+			{"$lte": []interface{}{path, max}},
+		}}
+	case ComparisonNin:
+		return bson.M{"$not": []interface{}{bson.M{"$in": []interface{}{path, c.FieldValue}}}}
+	case ComparisonContain, ComparisonNcontain, ComparisonStartsWith, ComparisonEndsWith, ComparisonRegex:
+		pattern, isSafe := c.regexPattern()
+		if !isSafe {
+			return bson.M{"$literal": false}
+		}
+		return bson.M{"$regexMatch": bson.M{"input": path, "regex": pattern, "options": "i"}}
+	case ComparisonAll:
+		return bson.M{"$setIsSubset": []interface{}{c.FieldValue, bson.M{"$ifNull": []interface{}{path, bson.A{}}}}}
+	case ComparisonElemMatch:
+		elem := c.elemFilter()
+		if elem == nil {
+			return bson.M{"$literal": false}
+		}
+		elements := bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": []interface{}{path, bson.A{}}},
+			"as":    name + "Element",
+			"in":    elem.ToFilterQuery(name + "Element"),
+		}}
+		return bson.M{"$anyElementTrue": []interface{}{elements}}
+	case ComparisonAny:
+		intersection := bson.M{"$setIntersection": []interface{}{c.FieldValue, bson.M{"$ifNull": []interface{}{path, bson.A{}}}}}
+		return bson.M{"$gt": []interface{}{bson.M{"$size": intersection}, 0}}
+	case ComparisonSize:
+		size := bson.M{"$size": bson.M{"$ifNull": []interface{}{path, bson.A{}}}}
+		return bson.M{"$eq": []interface{}{size, nonNegativeIntFromValue(c.FieldValue)}}
+	case ComparisonIsNull:
+		// the missing fields are not equal to null in the expressions
+		return bson.M{"$eq": []interface{}{path, nil}}
+	case ComparisonNear, ComparisonWithinBox, ComparisonWithinPolygon, ComparisonWithinRadius:
+		// there aren't any geo expressions in the aggregation framework
+		return bson.M{"$literal": false}
+	}
+	return bson.M{"$" + string(c.Comparison): []interface{}{path, c.FieldValue}}
 }
 
+// FilterFromMap converts the filter decoded from JSON or BSON into a Filter. The
+// value is kept as is except the BSON arrays and documents, which are converted into
+// []interface{} and map[string]interface{} like they are decoded from JSON. It
+// doesn't panic for the malformed filters, they are caught by Validate.
 func FilterFromMap(data map[string]interface{}) *Filter {
-	filtersMap, hasFilters := data["filters"].([]interface{})
-	if hasFilters {
-		filters := make([]Filter, len(filtersMap))
-		for i, filterMap := range filtersMap {
-			filters[i] = *FilterFromMap(filterMap.(map[string]interface{}))
+	if filtersArray, hasFilters := match.AsArray(data["filters"]); hasFilters {
+		filters := make([]Filter, len(filtersArray))
+		for i, filterMap := range filtersArray {
+			if filterMap, isMap := match.AsDocument(filterMap); isMap {
+				filters[i] = *FilterFromMap(filterMap)
+			}
 		}
+		operator, _ := data["operator"].(string)
 		return &Filter{
 			Filters:  filters,
-			Operator: Operator(data["operator"].(string)),
+			Operator: Operator(operator),
 		}
 	}
+
+	comparison, _ := data["comparison"].(string)
+	id, hasID := data["id"].(string)
+	value, hasValue := data["value"]
+	if !hasID && !hasValue {
+		// filters stored before the bson tags were added
+		id, _ = data["fieldid"].(string)
+		value = data["fieldvalue"]
+	}
 	return &Filter{
-		Comparison: Comparison(data["comparison"].(string)),
-		FieldId:    data["id"].(string),
-		FieldValue: data["value"].(string),
+		Comparison: Comparison(comparison),
+		FieldId:    id,
+		FieldValue: normalizeValue(value),
 	}
 }
@@ -0,0 +1,564 @@
+package model
+
+import (
+	"encoding/json"
+
+	core "github.com/devingen/api-core"
+	"github.com/devingen/api-core/internal/match"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFilterMatches(t *testing.T) {
+	now := time.Now()
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeText, "name"),
+			New(FieldTypeNumber, "age"),
+			New(FieldTypeBoolean, "active"),
+			New(FieldTypeDate, "createdAt"),
+			NewNumber("balance", "").Field,
+			NewNumber("price", NumberFormatDecimal).Field,
+			NewNumber("discount", NumberFormatPercent).Field,
+		},
+	}
+	price, _ := primitive.ParseDecimal128("9.99")
+	dm := DataModel{
+		"_id":          primitive.NewObjectID(),
+		"name":         "Ada Lovelace",
+		"age":          36,
+		"active":       true,
+		"createdAt":    primitive.NewDateTimeFromTime(now),
+		"tags":         primitive.A{"math", "poetry"},
+		"organisation": DataModel{"name": "Analytical Engine"},
+		"notes":        "first line\nsecond line",
+		"deletedAt":    nil,
+		"balance":      -150.5,
+		"price":        price,
+		"discount":     0.15,
+	}
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		matches bool
+	}{
+		{"eq is case-insensitive", Filter{Comparison: ComparisonEq, FieldId: "name", FieldValue: "ada lovelace"}, true},
+		{"ne", Filter{Comparison: ComparisonNe, FieldId: "name", FieldValue: "Ada Lovelace"}, false},
+		{"contain", Filter{Comparison: ComparisonContain, FieldId: "name", FieldValue: "LOVE"}, true},
+		{"ncontain", Filter{Comparison: ComparisonNcontain, FieldId: "name", FieldValue: "love"}, false},
+		{"ncontain other", Filter{Comparison: ComparisonNcontain, FieldId: "name", FieldValue: "babbage"}, true},
+		{"ncontain multiline", Filter{Comparison: ComparisonNcontain, FieldId: "notes", FieldValue: "babbage"}, false},
+		{"empty", Filter{Comparison: ComparisonEmpty, FieldId: "email"}, true},
+		{"nempty", Filter{Comparison: ComparisonNotEmpty, FieldId: "name"}, true},
+		{"number gt", Filter{Comparison: ComparisonGt, FieldId: "age", FieldValue: "30"}, true},
+		{"number lte", Filter{Comparison: ComparisonLte, FieldId: "age", FieldValue: float64(35)}, false},
+		{"negative number", Filter{Comparison: ComparisonLt, FieldId: "balance", FieldValue: -100}, true},
+		{"float", Filter{Comparison: ComparisonGt, FieldId: "balance", FieldValue: "-150.75"}, true},
+		{"decimal", Filter{Comparison: ComparisonEq, FieldId: "price", FieldValue: 9.99}, true},
+		{"decimal lt", Filter{Comparison: ComparisonLt, FieldId: "price", FieldValue: "9.99"}, false},
+		{"percent", Filter{Comparison: ComparisonEq, FieldId: "discount", FieldValue: "15%"}, true},
+		{"boolean", Filter{Comparison: ComparisonEq, FieldId: "active", FieldValue: false}, false},
+		{"date this month", Filter{Comparison: ComparisonDateThisMonth, FieldId: "createdAt"}, true},
+		{"date last year", Filter{Comparison: ComparisonDateLastYear, FieldId: "createdAt"}, false},
+		{"date next days", Filter{Comparison: DateNextNumberOfDays, FieldId: "createdAt", FieldValue: 3}, true},
+		{"date lt", Filter{Comparison: ComparisonLt, FieldId: "createdAt", FieldValue: now.Add(time.Hour).Format(time.RFC3339)}, true},
+		{"array item", Filter{Comparison: ComparisonEq, FieldId: "tags", FieldValue: "poetry"}, true},
+		{"inner field", Filter{Comparison: ComparisonContain, FieldId: "organisation.name", FieldValue: "engine"}, true},
+		{"mongo oid", Filter{Comparison: ComparisonEqMongoOID, FieldId: "_id", FieldValue: dm["_id"].(primitive.ObjectID).Hex()}, true},
+		{"and", Filter{Operator: OperatorAnd, Filters: []Filter{
+			{Comparison: ComparisonContain, FieldId: "name", FieldValue: "ada"},
+			{Comparison: ComparisonGte, FieldId: "age", FieldValue: "40"},
+		}}, false},
+		{"or", Filter{Operator: OperatorOr, Filters: []Filter{
+			{Comparison: ComparisonContain, FieldId: "name", FieldValue: "ada"},
+			{Comparison: ComparisonGte, FieldId: "age", FieldValue: "40"},
+		}}, true},
+		{"between", Filter{Comparison: ComparisonBetween, FieldId: "age", FieldValue: []interface{}{"30", 36}}, true},
+		{"date between", Filter{Comparison: ComparisonBetween, FieldId: "createdAt", FieldValue: []interface{}{
+			now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339),
+		}}, true},
+		{"nin", Filter{Comparison: ComparisonNin, FieldId: "name", FieldValue: []interface{}{"ADA LOVELACE"}}, false},
+		{"number nin", Filter{Comparison: ComparisonNin, FieldId: "age", FieldValue: []interface{}{"35", 37}}, true},
+		{"starts-with", Filter{Comparison: ComparisonStartsWith, FieldId: "name", FieldValue: "ada"}, true},
+		{"ends-with", Filter{Comparison: ComparisonEndsWith, FieldId: "name", FieldValue: "ada"}, false},
+		{"regex", Filter{Comparison: ComparisonRegex, FieldId: "name", FieldValue: "^a.a\\s"}, true},
+		{"unsafe regex", Filter{Comparison: ComparisonRegex, FieldId: "name", FieldValue: "(a+)+$"}, false},
+		{"all", Filter{Comparison: ComparisonAll, FieldId: "tags", FieldValue: []interface{}{"poetry", "math"}}, true},
+		{"size", Filter{Comparison: ComparisonSize, FieldId: "tags", FieldValue: 3}, false},
+		{"is-null of missing field", Filter{Comparison: ComparisonIsNull, FieldId: "email"}, false},
+		{"is-null", Filter{Comparison: ComparisonIsNull, FieldId: "deletedAt"}, true},
+		{"invalid query", Filter{Comparison: ComparisonSimilar, FieldId: "tags", FieldValue: "math"}, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.matches, test.filter.Matches(dm, config), test.name)
+	}
+}
+
+// TestFilterMatchesGenerated evaluates random filters against random data models and
+// checks that Matches agrees with the query of ToMatchQuery, that the groups agree
+// with their filters and that the opposite comparisons never agree.
+func TestFilterMatchesGenerated(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeText, "name"),
+			New(FieldTypeNumber, "price"),
+			New(FieldTypeBoolean, "active"),
+			New(FieldTypeDate, "createdAt"),
+			NewSelect("status", []SelectOption{{Value: "open"}, {Value: "done"}}).ToField(),
+		},
+		Location: time.UTC,
+		Now:      func() time.Time { return now },
+	}
+	dates := []time.Time{now, now.AddDate(0, 0, -1), now.AddDate(0, -1, 0), now.AddDate(0, 0, 3), now.AddDate(1, 0, 0)}
+	stored := map[string][]interface{}{
+		"name":      {"Ada", "ada lovelace", "Grace", ""},
+		"price":     {0, 1.5, int64(10), -2},
+		"active":    {true, false},
+		"createdAt": {dates[0], primitive.NewDateTimeFromTime(dates[1]), dates[2], dates[3], dates[4]},
+		"status":    {"open", "done"},
+	}
+	filtered := map[string][]interface{}{
+		"name":   {"Ada", "ADA LOVELACE", "race", ""},
+		"price":  {0, 1.5, 10, "-2"},
+		"active": {true, false},
+		"status": {"open", "done"},
+	}
+	for _, date := range dates {
+		filtered["createdAt"] = append(filtered["createdAt"], date.Format(time.RFC3339))
+	}
+	opposites := map[Comparison]Comparison{
+		ComparisonEq:        ComparisonNe,
+		ComparisonIn:        ComparisonNin,
+		ComparisonEmpty:     ComparisonNotEmpty,
+		ComparisonDateEqDay: ComparisonDateNeDay,
+	}
+
+	r := rand.New(rand.NewSource(1))
+	pick := func(values []interface{}) interface{} {
+		return values[r.Intn(len(values))]
+	}
+	randomLeaf := func() Filter {
+		field := config.Fields[r.Intn(len(config.Fields))]
+		definition, _ := GetFieldTypeDefinition(field.GetType())
+		filter := Filter{FieldId: field.GetID(), Comparison: definition.Comparisons[r.Intn(len(definition.Comparisons))]}
+		values := filtered[field.GetID()]
+		switch filter.Comparison {
+		case ComparisonEmpty, ComparisonNotEmpty, ComparisonIsNull,
+			ComparisonDateNextYear, ComparisonDateNextMonth, ComparisonDateNextWeek,
+			ComparisonDateThisYear, ComparisonDateThisMonth, ComparisonDateThisWeek,
+			ComparisonDateLastYear, ComparisonDateLastMonth, ComparisonDateLastWeek:
+		case ComparisonIn, ComparisonNin, ComparisonBetween:
+			filter.FieldValue = []interface{}{pick(values), pick(values)}
+		case DateNextNumberOfDays, DatePastNumberOfDays:
+			filter.FieldValue = r.Intn(40)
+		default:
+			filter.FieldValue = pick(values)
+		}
+		return filter
+	}
+	var randomFilter func(depth int) Filter
+	randomFilter = func(depth int) Filter {
+		if depth == 0 || r.Intn(3) > 0 {
+			return randomLeaf()
+		}
+		group := Filter{Operator: OperatorAnd}
+		if r.Intn(2) == 0 {
+			group.Operator = OperatorOr
+		}
+		for i := r.Intn(3); i >= 0; i-- {
+			group.Filters = append(group.Filters, randomFilter(depth-1))
+		}
+		return group
+	}
+	randomDataModel := func() DataModel {
+		dm := DataModel{"_id": primitive.NewObjectID()}
+		for id, values := range stored {
+			switch r.Intn(4) {
+			case 0:
+			case 1:
+				dm[id] = nil
+			default:
+				dm[id] = pick(values)
+			}
+		}
+		return dm
+	}
+
+	checked, matchedCount := 0, 0
+	for i := 0; i < 2000; i++ {
+		filter := randomFilter(2)
+		if filter.Validate(config) != nil {
+			continue
+		}
+		checked++
+		query := filter.ToMatchQuery(config)
+		for j := 0; j < 10; j++ {
+			dm := randomDataModel()
+			matches := filter.Matches(dm, config)
+			matched, err := match.Document(query, dm, match.Options{CaseInsensitive: true})
+			if !assert.Nil(t, err, "%v", query) || !assert.Equal(t, matched, matches, "%+v %v", filter, dm) {
+				return
+			}
+			if matches {
+				matchedCount++
+			}
+
+			if filter.Filters != nil {
+				expected := filter.Operator == OperatorAnd
+				for _, f := range filter.Filters {
+					if f.Matches(dm, config) != expected {
+						expected = !expected
+						break
+					}
+				}
+				assert.Equal(t, expected, matches, "%+v %v", filter, dm)
+			} else if opposite, has := opposites[filter.Comparison]; has {
+				if filter.Comparison == ComparisonDateEqDay && dm[filter.FieldId] == nil {
+					// date-ne-day matches the dates outside the day, not the missing ones
+					continue
+				}
+				negated := filter
+				negated.Comparison = opposite
+				assert.NotEqual(t, matches, negated.Matches(dm, config), "%+v %v", filter, dm)
+			}
+		}
+	}
+	assert.Greater(t, checked, 1000)
+	assert.Greater(t, matchedCount, checked)
+	assert.Less(t, matchedCount, checked*9)
+}
+
+func TestFilterValidate(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeText, "name"),
+			New(FieldTypeNumber, "age"),
+			New(FieldTypeDate, "createdAt"),
+			NewNumber("quantity", NumberFormatInteger).Field,
+			NewReference("organisation", "organisations", true).ToField(),
+		},
+	}
+
+	valid := FilterFromMap(map[string]interface{}{
+		"operator": "and",
+		"filters": []interface{}{
+			map[string]interface{}{"comparison": "contain", "id": "name", "value": "ada"},
+			map[string]interface{}{"comparison": "gte", "id": "age", "value": float64(18)},
+			map[string]interface{}{"comparison": "date-past-number-of-days", "id": "createdAt", "value": "7"},
+			map[string]interface{}{"comparison": "eq", "id": "organisation.name", "value": "Devingen"},
+			map[string]interface{}{"comparison": "eq-mongo-oid", "id": "_id", "value": "5f1d7f3e2b7c4a0001a1b2c3"},
+		},
+	})
+	assert.Nil(t, valid.Validate(config))
+
+	invalid := FilterFromMap(map[string]interface{}{
+		"operator": "xor",
+		"filters": []interface{}{
+			map[string]interface{}{"comparison": "contain", "id": "name", "value": float64(5)},
+			map[string]interface{}{"comparison": "date-this-week", "id": "age"},
+			map[string]interface{}{"comparison": "eq", "id": "price", "value": "9.99"},
+			map[string]interface{}{"comparison": "eq", "value": "x"},
+			map[string]interface{}{"operator": "or", "filters": []interface{}{
+				map[string]interface{}{"comparison": "lt", "id": "createdAt", "value": "yesterday"},
+			}},
+			map[string]interface{}{"comparison": "between", "id": "age", "value": []interface{}{float64(1)}},
+			map[string]interface{}{"comparison": "between", "id": "createdAt", "value": []interface{}{"2020-01-01T00:00:00Z", "now"}},
+			map[string]interface{}{"comparison": "regex", "id": "name", "value": "(\\w+\\s?)*$"},
+			map[string]interface{}{"comparison": "regex", "id": "name", "value": "(?<=a)b"},
+			map[string]interface{}{"comparison": "size", "id": "name", "value": float64(1)},
+			map[string]interface{}{"comparison": "eq", "id": "quantity", "value": 1.5},
+		},
+	})
+	err := invalid.Validate(config)
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{
+		"filter:invalid-operator:xor",
+		"filter.filters[0]:invalid-value:expected-text",
+		"filter.filters[1]:invalid-comparison-for-field-type:date-this-week:number",
+		"filter.filters[2]:unknown-field:price",
+		"filter.filters[3]:missing-id",
+		"filter.filters[4].filters[0]:invalid-value:expected-date",
+		"filter.filters[5]:invalid-value:expected-range",
+		"filter.filters[6]:invalid-value:expected-date",
+		"filter.filters[7]:invalid-regex:nested-quantifier",
+		"filter.filters[8]:invalid-regex:syntax",
+		"filter.filters[9]:invalid-comparison-for-field-type:size:text",
+		"filter.filters[10]:invalid-value:expected-integer",
+	}), err)
+
+	// invalid filters don't panic while generating the query
+	assert.NotPanics(t, func() { invalid.ToMatchQuery(config) })
+}
+
+func TestFilterValidateGeo(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeText, "name"),
+			NewGeo("location", GeoShapePoint).ToField(),
+		},
+	}
+
+	valid := FilterFromMap(map[string]interface{}{
+		"operator": "and",
+		"filters": []interface{}{
+			map[string]interface{}{"comparison": "near", "id": "location", "value": map[string]interface{}{
+				"point": []interface{}{28.97, 41.0}, "minDistance": int64(10), "maxDistance": 1000.5,
+			}},
+			map[string]interface{}{"comparison": "within-box", "id": "location", "value": []interface{}{
+				[]interface{}{28.9, 40.9}, []interface{}{29.1, 41.1},
+			}},
+			map[string]interface{}{"comparison": "within-polygon", "id": "location", "value": []interface{}{
+				[]interface{}{28.9, 40.9}, []interface{}{29.1, 40.9}, map[string]interface{}{"type": "Point", "coordinates": []interface{}{29.0, 41.1}},
+			}},
+			map[string]interface{}{"comparison": "within-radius", "id": "location", "value": map[string]interface{}{
+				"center": []interface{}{28.97, 41.0}, "radius": int64(500),
+			}},
+		},
+	})
+	assert.Nil(t, valid.Validate(config))
+
+	invalid := FilterFromMap(map[string]interface{}{
+		"operator": "and",
+		"filters": []interface{}{
+			map[string]interface{}{"comparison": "near", "id": "location", "value": map[string]interface{}{
+				"point": []interface{}{190.0, 41.0},
+			}},
+			map[string]interface{}{"comparison": "near", "id": "location", "value": map[string]interface{}{
+				"point": []interface{}{28.97, 41.0}, "maxDistance": -1,
+			}},
+			map[string]interface{}{"comparison": "within-box", "id": "location", "value": []interface{}{
+				[]interface{}{29.1, 41.1}, []interface{}{28.9, 40.9},
+			}},
+			map[string]interface{}{"comparison": "within-polygon", "id": "location", "value": []interface{}{
+				[]interface{}{28.9, 40.9}, []interface{}{29.1, 40.9},
+			}},
+			map[string]interface{}{"comparison": "within-radius", "id": "location", "value": []interface{}{28.97, 41.0}},
+			map[string]interface{}{"comparison": "eq", "id": "location", "value": "here"},
+			map[string]interface{}{"comparison": "near", "id": "name", "value": map[string]interface{}{
+				"point": []interface{}{28.97, 41.0},
+			}},
+			map[string]interface{}{"operator": "or", "filters": []interface{}{
+				map[string]interface{}{"comparison": "near", "id": "location", "value": map[string]interface{}{
+					"point": []interface{}{28.97, 41.0},
+				}},
+			}},
+		},
+	})
+	err := invalid.Validate(config)
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{
+		"filter.filters[0]:invalid-value:expected-point-and-distance",
+		"filter.filters[1]:invalid-value:expected-point-and-distance",
+		"filter.filters[2]:invalid-value:expected-box",
+		"filter.filters[3]:invalid-value:expected-polygon",
+		"filter.filters[4]:invalid-value:expected-circle",
+		"filter.filters[5]:invalid-comparison-for-field-type:eq:geo",
+		"filter.filters[6]:invalid-comparison-for-field-type:near:text",
+		"filter.filters[1]:more-than-one-near",
+		"filter.filters[6]:more-than-one-near",
+		"filter.filters[7].filters[0]:near-not-top-level",
+	}), err)
+
+	assert.NotPanics(t, func() { invalid.ToMatchQuery(config) })
+}
+
+func TestFilterValidateObjectAndArray(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			NewObject("address", []Field{New(FieldTypeText, "city"), New(FieldTypeNumber, "zip")}).ToField(),
+			NewObject("meta", nil).ToField(),
+			NewArray("tags", NewSelect("", []SelectOption{{Value: "gift"}, {Value: "express"}}).ToField()).ToField(),
+			NewArray("lines", NewObject("", []Field{New(FieldTypeNumber, "price")}).ToField()).ToField(),
+		},
+	}
+	assert.Equal(t, FieldTypeNumber, config.GetField("address.zip").GetType())
+	assert.Equal(t, FieldTypeNumber, config.GetField("lines.price").GetType())
+	assert.Equal(t, FieldTypeNumber, config.GetField("lines.2.price").GetType())
+	assert.Equal(t, FieldTypeSelect, config.GetField("tags.0").GetType())
+	assert.Nil(t, config.GetField("address.country"))
+
+	valid := &Filter{Operator: OperatorAnd, Filters: []Filter{
+		{Comparison: ComparisonContain, FieldId: "address.city", FieldValue: "ist"},
+		{Comparison: ComparisonEq, FieldId: "meta.source", FieldValue: "import"},
+		{Comparison: ComparisonAny, FieldId: "tags", FieldValue: []interface{}{"gift"}},
+		{Comparison: ComparisonEq, FieldId: "tags", FieldValue: "express"},
+		{Comparison: ComparisonElemMatch, FieldId: "lines", FieldValue: map[string]interface{}{
+			"comparison": "lt", "id": "price", "value": float64(10),
+		}},
+	}}
+	assert.Nil(t, valid.Validate(config))
+	assert.Equal(t, bson.M{"$and": []bson.M{
+		{"address.city": bson.M{"$regex": "ist", "$options": "i"}},
+		{"meta.source": bson.M{"$eq": "import"}},
+		{"tags": bson.M{"$in": []interface{}{"gift"}}},
+		{"tags": bson.M{"$eq": "express"}},
+		{"lines": bson.M{"$elemMatch": bson.M{"price": bson.M{"$lt": int64(10)}}}},
+	}}, valid.ToMatchQuery(config))
+
+	invalid := &Filter{Operator: OperatorAnd, Filters: []Filter{
+		{Comparison: ComparisonEq, FieldId: "address.country", FieldValue: "TR"},
+		{Comparison: ComparisonEq, FieldId: "address.zip", FieldValue: "x"},
+		{Comparison: ComparisonEq, FieldId: "tags", FieldValue: "cheap"},
+		{Comparison: ComparisonAll, FieldId: "tags", FieldValue: []interface{}{"gift", "cheap"}},
+		{Comparison: ComparisonElemMatch, FieldId: "tags", FieldValue: map[string]interface{}{}},
+		{Comparison: ComparisonElemMatch, FieldId: "lines", FieldValue: "cheap"},
+		{Comparison: ComparisonElemMatch, FieldId: "lines", FieldValue: map[string]interface{}{
+			"comparison": "lt", "id": "discount", "value": float64(10),
+		}},
+		{Comparison: ComparisonContain, FieldId: "address", FieldValue: "x"},
+	}}
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{
+		"filter.filters[0]:unknown-field:address.country",
+		"filter.filters[1]:invalid-value:expected-number",
+		"filter.filters[2]:invalid-option:cheap",
+		"filter.filters[3]:invalid-option:cheap",
+		"filter.filters[4]:invalid-comparison-for-field-type:elem-match:array",
+		"filter.filters[5]:invalid-value:expected-filter",
+		"filter.filters[6].value:unknown-field:discount",
+		"filter.filters[7]:invalid-comparison-for-field-type:contain:object",
+	}), invalid.Validate(config))
+	assert.NotPanics(t, func() { invalid.ToMatchQuery(config) })
+
+	assert.Nil(t, (&QueryConfig{Fields: config.Fields}).SelectFields([]string{"address.city", "lines.price", "meta.source"}))
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{"fields:unknown-field:lines.discount"}),
+		(&QueryConfig{Fields: config.Fields}).SelectFields([]string{"lines.discount"}))
+}
+
+func TestFilterArrayRanges(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			NewArray("dates", New(FieldTypeDate, "")).ToField(),
+			NewArray("lines", NewObject("", []Field{
+				New(FieldTypeNumber, "price"),
+				NewArray("taxes", NewObject("", []Field{New(FieldTypeNumber, "rate")}).ToField()).ToField(),
+			}).ToField()).ToField(),
+		},
+		Location: time.UTC,
+		Now:      func() time.Time { return time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC) },
+	}
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 6, 30, 23, 59, 59, 999999999, time.UTC)
+
+	thisMonth := Filter{Comparison: ComparisonDateThisMonth, FieldId: "dates"}
+	assert.Equal(t, bson.M{"dates": bson.M{"$elemMatch": bson.M{"$gte": start, "$lte": end}}}, thisMonth.ToMatchQuery(config))
+	assert.False(t, thisMonth.Matches(DataModel{"dates": primitive.A{
+		primitive.NewDateTimeFromTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+		primitive.NewDateTimeFromTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}}, config))
+	assert.True(t, thisMonth.Matches(DataModel{"dates": primitive.A{
+		primitive.NewDateTimeFromTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+		primitive.NewDateTimeFromTime(time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)),
+	}}, config))
+
+	price := Filter{Comparison: ComparisonBetween, FieldId: "lines.price", FieldValue: []interface{}{10, 20}}
+	assert.Equal(t, bson.M{"lines": bson.M{"$elemMatch": bson.M{"price": bson.M{"$gte": int64(10), "$lte": int64(20)}}}}, price.ToMatchQuery(config))
+	assert.False(t, price.Matches(DataModel{"lines": primitive.A{DataModel{"price": 5}, DataModel{"price": 25}}}, config))
+	assert.True(t, price.Matches(DataModel{"lines": primitive.A{DataModel{"price": 5}, DataModel{"price": 15}}}, config))
+
+	// the indexes refer to a single element
+	first := Filter{Comparison: ComparisonBetween, FieldId: "lines.0.price", FieldValue: []interface{}{10, 20}}
+	assert.Equal(t, bson.M{"lines.0.price": bson.M{"$gte": int64(10), "$lte": int64(20)}}, first.ToMatchQuery(config))
+
+	rate := Filter{Comparison: ComparisonBetween, FieldId: "lines.taxes.rate", FieldValue: []interface{}{1, 8}}
+	assert.Equal(t, bson.M{"lines": bson.M{"$elemMatch": bson.M{"taxes": bson.M{"$elemMatch": bson.M{
+		"rate": bson.M{"$gte": int64(1), "$lte": int64(8)},
+	}}}}}, rate.ToMatchQuery(config))
+}
+
+func TestFilterEncoding(t *testing.T) {
+	data := []byte(`{
+		"operator": "and",
+		"filters": [
+			{ "comparison": "in", "id": "status", "value": ["active", 2, null] },
+			{ "comparison": "gt", "id": "price", "value": 12.5 },
+			{ "comparison": "eq", "id": "enabled", "value": false },
+			{ "comparison": "eq", "id": "meta", "value": { "count": 3, "tags": ["a"] } }
+		]
+	}`)
+	expected := Filter{
+		Operator: "and",
+		Filters: []Filter{
+			{Comparison: ComparisonIn, FieldId: "status", FieldValue: []interface{}{"active", int64(2), nil}},
+			{Comparison: ComparisonGt, FieldId: "price", FieldValue: 12.5},
+			{Comparison: ComparisonEq, FieldId: "enabled", FieldValue: false},
+			{Comparison: ComparisonEq, FieldId: "meta", FieldValue: map[string]interface{}{
+				"count": int64(3),
+				"tags":  []interface{}{"a"},
+			}},
+		},
+	}
+
+	var filter Filter
+	assert.Nil(t, json.Unmarshal(data, &filter))
+	assert.Equal(t, expected, filter)
+
+	// the filters in the field definitions are stored as documents
+	field := Field{"id": "orders", "type": FieldTypeReverseReference, "filter": filter}
+	encoded, err := bson.Marshal(field)
+	assert.Nil(t, err)
+
+	var decodedField Field
+	assert.Nil(t, bson.Unmarshal(encoded, &decodedField))
+	assert.Equal(t, &expected, decodedField.GetFilter("filter"))
+
+	var config QueryConfig
+	encoded, err = bson.Marshal(QueryConfig{Filter: &filter})
+	assert.Nil(t, err)
+	assert.Nil(t, bson.Unmarshal(encoded, &config))
+	assert.Equal(t, &expected, config.Filter)
+
+	// the filters stored before the bson tags were added
+	legacy, err := bson.Marshal(bson.M{"comparison": "eq", "fieldid": "name", "fieldvalue": "Jane"})
+	assert.Nil(t, err)
+	var legacyFilter Filter
+	assert.Nil(t, bson.Unmarshal(legacy, &legacyFilter))
+	assert.Equal(t, Filter{Comparison: ComparisonEq, FieldId: "name", FieldValue: "Jane"}, legacyFilter)
+}
+
+func TestFilterDateRange(t *testing.T) {
+	istanbul := time.FixedZone("Europe/Istanbul", 3*60*60)
+	sunday := time.Sunday
+	config := &QueryConfig{
+		Location: istanbul,
+		// it's still Sunday in UTC but Monday in Istanbul
+		Now: func() time.Time { return time.Date(2024, time.March, 31, 22, 30, 0, 0, time.UTC) },
+	}
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2024, month, d, 0, 0, 0, 0, istanbul)
+	}
+
+	cases := []struct {
+		filter Filter
+		start  time.Time
+		end    time.Time
+	}{
+		{Filter{Comparison: ComparisonDateThisWeek}, day(time.April, 1), day(time.April, 8)},
+		{Filter{Comparison: ComparisonDateLastWeek}, day(time.March, 25), day(time.April, 1)},
+		{Filter{Comparison: ComparisonDateNextWeek}, day(time.April, 8), day(time.April, 15)},
+		{Filter{Comparison: ComparisonDateThisMonth}, day(time.April, 1), day(time.May, 1)},
+		{Filter{Comparison: ComparisonDateLastMonth}, day(time.March, 1), day(time.April, 1)},
+		{Filter{Comparison: ComparisonDateNextMonth}, day(time.May, 1), day(time.June, 1)},
+		{Filter{Comparison: ComparisonDateThisYear}, day(time.January, 1), time.Date(2025, time.January, 1, 0, 0, 0, 0, istanbul)},
+		{Filter{Comparison: DatePastNumberOfDays, FieldValue: 2}, day(time.March, 30), day(time.April, 2)},
+		// the day of the value is the one in the time zone, not the current month's
+		{Filter{Comparison: ComparisonDateEqDay, FieldValue: "2024-02-14T21:30:00Z"}, day(time.February, 15), day(time.February, 16)},
+	}
+	for _, c := range cases {
+		start, end, ok := c.filter.DateRange(config)
+		assert.True(t, ok, c.filter.Comparison)
+		assert.Equal(t, c.start, start, c.filter.Comparison)
+		assert.Equal(t, c.end.Add(-time.Nanosecond), end, c.filter.Comparison)
+	}
+
+	config.WeekStart = &sunday
+	start, _, _ := Filter{Comparison: ComparisonDateThisWeek}.DateRange(config)
+	assert.Equal(t, day(time.March, 31), start)
+
+	_, _, ok := Filter{Comparison: ComparisonEq}.DateRange(config)
+	assert.False(t, ok)
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"net/http"
+
+	core "github.com/devingen/api-core"
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FormulaField is a computed field whose value is the formula over the other fields
+// of the document. The result type is used to filter the field like a field of that
+// type. Ex: daysUntilDue = datediff(now(), dueDate, "day") with the result type number
+type FormulaField struct {
+	Field
+}
+
+func FormulaFromField(field Field) FormulaField {
+	return FormulaField{
+		Field: field,
+	}
+}
+
+func NewFormula(fieldName, formula string, resultType FieldType) FormulaField {
+	return FormulaField{
+		Field: Field{
+			"type":       FieldTypeFormula,
+			"id":         fieldName,
+			"formula":    formula,
+			"resultType": resultType,
+		},
+	}
+}
+
+func (field FormulaField) GetID() string {
+	return field.Field.GetID()
+}
+
+func (field FormulaField) GetFormula() string {
+	return field.Field.GetString("formula")
+}
+
+func (field FormulaField) GetResultType() FieldType {
+	return Field{"type": field.Field["resultType"]}.GetType()
+}
+
+// ToExpression compiles the formula into an aggregation expression. It returns a
+// DVNError with status 400 if the formula is invalid.
+// Ex: "invalid-formula:total:unexpected end of formula"
+func (field FormulaField) ToExpression() (interface{}, error) {
+	expression, err := CompileFormula(field.GetFormula())
+	if err != nil {
+		return nil, core.NewError(http.StatusBadRequest, "invalid-formula:"+field.GetID()+":"+err.Error())
+	}
+	return expression, nil
+}
+
+// Eval computes the value of the formula for the data model without querying the
+// database.
+func (field FormulaField) Eval(dm DataModel) (interface{}, error) {
+	expression, err := field.ToExpression()
+	if err != nil {
+		return nil, err
+	}
+	return match.New(match.Options{CaseInsensitive: true}).Evaluate(expression, dm)
+}
+
+func (field FormulaField) ToField() Field {
+	return field.Field
+}
+
+// FormulaStages returns the $addFields stages that compute the formula fields. Each
+// formula is computed in a separate stage, so the formulas can refer to the formula
+// fields that are declared before them.
+func FormulaStages(fields []Field) ([]bson.M, error) {
+	stages := make([]bson.M, 0)
+	for _, field := range fields {
+		if field.GetType() != FieldTypeFormula {
+			continue
+		}
+		formulaField := FormulaFromField(field)
+		expression, err := formulaField.ToExpression()
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, bson.M{"$addFields": bson.M{formulaField.GetID(): expression}})
+	}
+	return stages, nil
+}
@@ -0,0 +1,368 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// formulaFunction is a function of the formula language that is compiled into an
+// aggregation expression.
+type formulaFunction struct {
+	minArgs int
+	maxArgs int
+	compile func(args []interface{}) interface{}
+}
+
+var formulaFunctions = map[string]formulaFunction{
+	"if": {3, 3, func(args []interface{}) interface{} {
+		return bson.M{"$cond": args}
+	}},
+	"ifnull": {2, 2, func(args []interface{}) interface{} {
+		return bson.M{"$ifNull": args}
+	}},
+	"concat": {1, -1, func(args []interface{}) interface{} {
+		return bson.M{"$concat": args}
+	}},
+	"lower": {1, 1, func(args []interface{}) interface{} {
+		return bson.M{"$toLower": args[0]}
+	}},
+	"upper": {1, 1, func(args []interface{}) interface{} {
+		return bson.M{"$toUpper": args[0]}
+	}},
+	"abs": {1, 1, func(args []interface{}) interface{} {
+		return bson.M{"$abs": args[0]}
+	}},
+	"floor": {1, 1, func(args []interface{}) interface{} {
+		return bson.M{"$floor": args[0]}
+	}},
+	"ceil": {1, 1, func(args []interface{}) interface{} {
+		return bson.M{"$ceil": args[0]}
+	}},
+	"round": {1, 2, func(args []interface{}) interface{} {
+		if len(args) == 1 {
+			args = append(args, int64(0))
+		}
+		return bson.M{"$round": args}
+	}},
+	"now": {0, 0, func(args []interface{}) interface{} {
+		return "$$NOW"
+	}},
+	// datediff(start, end, unit) counts the unit boundaries between the dates.
+	// The units are year, quarter, month, week, day, hour, minute, second and millisecond.
+	"datediff": {3, 3, func(args []interface{}) interface{} {
+		return bson.M{"$dateDiff": bson.M{
+			"startDate": args[0],
+			"endDate":   args[1],
+			"unit":      args[2],
+		}}
+	}},
+}
+
+// binary operators of the formula language in the order of their precedence, from
+// the lowest to the highest.
+var formulaOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"&"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+var formulaOperatorExpressions = map[string]string{
+	"||": "$or", "&&": "$and",
+	"==": "$eq", "!=": "$ne", "<": "$lt", "<=": "$lte", ">": "$gt", ">=": "$gte",
+	"&": "$concat",
+	"+": "$add", "-": "$subtract", "*": "$multiply", "/": "$divide", "%": "$mod",
+}
+
+// CompileFormula compiles the formula into an aggregation expression. The formulas
+// consist of the field paths, the number, text (in single or double quotes), true,
+// false and null literals, the arithmetic (+ - * / %), concatenation (&),
+// comparison (== != < <= > >=) and boolean (&& || !) operators and the functions
+// if, ifnull, concat, lower, upper, abs, floor, ceil, round, now and datediff. The
+// division and the modulo by 0 are null.
+// Ex: if(status == "done", 0, datediff(now(), dueDate, "day"))
+func CompileFormula(formula string) (interface{}, error) {
+	tokens, err := tokenizeFormula(formula)
+	if err != nil {
+		return nil, err
+	}
+	p := &formulaParser{tokens: tokens}
+	expression, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.unexpected()
+	}
+	return expression, nil
+}
+
+type formulaTokenKind int
+
+const (
+	formulaNumber formulaTokenKind = iota
+	formulaString
+	formulaIdentifier
+	formulaSymbol
+)
+
+type formulaToken struct {
+	kind     formulaTokenKind
+	text     string
+	position int
+}
+
+var formulaSymbols = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "+", "-", "*", "/", "%", "&", "!", "(", ")", ","}
+
+func tokenizeFormula(formula string) ([]formulaToken, error) {
+	tokens := make([]formulaToken, 0)
+	runes := []rune(formula)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, formulaToken{formulaNumber, string(runes[start:i]), start})
+		case r == '"' || r == '\'':
+			start := i
+			var builder strings.Builder
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				builder.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated text at %d", start)
+			}
+			i++
+			tokens = append(tokens, formulaToken{formulaString, builder.String(), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, formulaToken{formulaIdentifier, string(runes[start:i]), start})
+		default:
+			symbol := ""
+			for _, s := range formulaSymbols {
+				if strings.HasPrefix(string(runes[i:]), s) {
+					symbol = s
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", string(r), i)
+			}
+			tokens = append(tokens, formulaToken{formulaSymbol, symbol, i})
+			i += len([]rune(symbol))
+		}
+	}
+	return tokens, nil
+}
+
+type formulaParser struct {
+	tokens []formulaToken
+	next   int
+}
+
+func (p *formulaParser) done() bool {
+	return p.next >= len(p.tokens)
+}
+
+func (p *formulaParser) peek() formulaToken {
+	if p.done() {
+		return formulaToken{kind: -1}
+	}
+	return p.tokens[p.next]
+}
+
+func (p *formulaParser) isSymbol(symbol string) bool {
+	token := p.peek()
+	return token.kind == formulaSymbol && token.text == symbol
+}
+
+func (p *formulaParser) unexpected() error {
+	if p.done() {
+		return fmt.Errorf("unexpected end of formula")
+	}
+	token := p.peek()
+	return fmt.Errorf("unexpected %q at %d", token.text, token.position)
+}
+
+func (p *formulaParser) expect(symbol string) error {
+	if !p.isSymbol(symbol) {
+		return p.unexpected()
+	}
+	p.next++
+	return nil
+}
+
+// parseBinary parses the binary operators of the precedence level and the higher ones.
+// The operators are left-associative.
+func (p *formulaParser) parseBinary(level int) (interface{}, error) {
+	if level == len(formulaOperators) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator := ""
+		for _, o := range formulaOperators[level] {
+			if p.isSymbol(o) {
+				operator = o
+				break
+			}
+		}
+		if operator == "" {
+			return left, nil
+		}
+		p.next++
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpression(operator, left, right)
+	}
+}
+
+// binaryExpression returns the expression of the binary operator. The division and
+// the modulo by 0 are null since MongoDB aborts the aggregation otherwise.
+// Ex: { "$cond": [ { "$eq": [ "$count", 0 ] }, null, { "$divide": [ "$total", "$count" ] } ] }
+func binaryExpression(operator string, left, right interface{}) interface{} {
+	expression := bson.M{formulaOperatorExpressions[operator]: []interface{}{left, right}}
+	if operator != "/" && operator != "%" {
+		return expression
+	}
+	switch n := right.(type) {
+	case int64:
+		if n != 0 {
+			return expression
+		}
+	case float64:
+		if n != 0 {
+			return expression
+		}
+	}
+	return bson.M{"$cond": []interface{}{bson.M{"$eq": []interface{}{right, int64(0)}}, nil, expression}}
+}
+
+func (p *formulaParser) parseUnary() (interface{}, error) {
+	if p.isSymbol("-") || p.isSymbol("!") {
+		operator := p.peek().text
+		p.next++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operator == "!" {
+			return bson.M{"$not": []interface{}{operand}}, nil
+		}
+		switch n := operand.(type) {
+		case int64:
+			return -n, nil
+		case float64:
+			return -n, nil
+		}
+		return bson.M{"$multiply": []interface{}{int64(-1), operand}}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *formulaParser) parsePrimary() (interface{}, error) {
+	if p.done() {
+		return nil, p.unexpected()
+	}
+
+	token := p.peek()
+	switch token.kind {
+	case formulaNumber:
+		p.next++
+		if i, err := strconv.ParseInt(token.text, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", token.text, token.position)
+		}
+		return f, nil
+	case formulaString:
+		p.next++
+		// the texts that start with $ would be field paths otherwise
+		return bson.M{"$literal": token.text}, nil
+	case formulaIdentifier:
+		p.next++
+		if p.isSymbol("(") {
+			return p.parseCall(token)
+		}
+		switch token.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		for _, part := range strings.Split(token.text, ".") {
+			if part == "" {
+				return nil, fmt.Errorf("invalid field %q at %d", token.text, token.position)
+			}
+		}
+		return "$" + token.text, nil
+	}
+
+	if p.isSymbol("(") {
+		p.next++
+		expression, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return expression, p.expect(")")
+	}
+	return nil, p.unexpected()
+}
+
+func (p *formulaParser) parseCall(name formulaToken) (interface{}, error) {
+	function, isFunction := formulaFunctions[strings.ToLower(name.text)]
+	if !isFunction {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.position)
+	}
+
+	// skip (
+	p.next++
+	args := make([]interface{}, 0)
+	for !p.isSymbol(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next++
+
+	if len(args) < function.minArgs || (function.maxArgs >= 0 && len(args) > function.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments to %s at %d", name.text, name.position)
+	}
+	return function.compile(args), nil
+}
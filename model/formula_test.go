@@ -0,0 +1,77 @@
+package model
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	core "github.com/devingen/api-core"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCompileFormula(t *testing.T) {
+	expression, err := CompileFormula(`if(status == "done", 0, datediff(createdAt, dueDate, "day")) * -2 + price.amount`)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$add": []interface{}{
+		bson.M{"$multiply": []interface{}{
+			bson.M{"$cond": []interface{}{
+				bson.M{"$eq": []interface{}{"$status", bson.M{"$literal": "done"}}},
+				int64(0),
+				bson.M{"$dateDiff": bson.M{"startDate": "$createdAt", "endDate": "$dueDate", "unit": bson.M{"$literal": "day"}}},
+			}},
+			int64(-2),
+		}},
+		"$price.amount",
+	}}, expression)
+
+	expression, err = CompileFormula(`total / count + total % 2`)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$add": []interface{}{
+		bson.M{"$cond": []interface{}{
+			bson.M{"$eq": []interface{}{"$count", int64(0)}},
+			nil,
+			bson.M{"$divide": []interface{}{"$total", "$count"}},
+		}},
+		bson.M{"$mod": []interface{}{"$total", int64(2)}},
+	}}, expression)
+
+	_, err = NewFormula("total", "price * (1 + tax", FieldTypeNumber).ToExpression()
+	assert.Equal(t, core.NewError(http.StatusBadRequest, "invalid-formula:total:unexpected end of formula"), err)
+	_, err = CompileFormula("sum(price)")
+	assert.EqualError(t, err, `unknown function "sum" at 0`)
+}
+
+func TestFormulaEval(t *testing.T) {
+	dm := DataModel{
+		"firstName": "Ada",
+		"lastName":  "Lovelace",
+		"createdAt": time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC),
+		"dueDate":   time.Date(2024, 3, 4, 1, 0, 0, 0, time.UTC),
+		"price":     int64(120),
+	}
+
+	value, err := NewFormula("name", `concat(firstName, " ", upper(lastName))`, FieldTypeText).Eval(dm)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ada LOVELACE", value)
+
+	// the day boundaries are counted, not the 24 hour periods
+	value, err = NewFormula("days", `datediff(createdAt, dueDate, "day")`, FieldTypeNumber).Eval(dm)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), value)
+
+	value, err = NewFormula("discounted", `ifnull(discount, 0) + price * 0.9 > 100`, FieldTypeBoolean).Eval(dm)
+	assert.Nil(t, err)
+	assert.Equal(t, true, value)
+
+	// the division by 0 doesn't abort the aggregation
+	value, err = NewFormula("unitPrice", `price / ifnull(quantity, 0)`, FieldTypeNumber).Eval(dm)
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+	value, err = NewFormula("remainder", `price % 0`, FieldTypeNumber).Eval(dm)
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+	value, err = NewFormula("half", `price / 2`, FieldTypeNumber).Eval(dm)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(60), value)
+}
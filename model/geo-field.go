@@ -0,0 +1,280 @@
+package model
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type GeoShape string
+
+const (
+	GeoShapePoint   GeoShape = "point"
+	GeoShapePolygon GeoShape = "polygon"
+)
+
+// DistanceField is the field that the near comparison sets the distances of the
+// documents in meters to. The results can be sorted by it.
+// Ex: { "id": "_distance", "order": 1 }
+const DistanceField = "_distance"
+
+// GeoField stores a GeoJSON point or polygon in longitude and latitude. The field
+// must have a 2dsphere index to be filtered by the near comparison.
+// Ex: { "type": "Point", "coordinates": [ 28.97, 41.01 ] }
+type GeoField struct {
+	Field
+}
+
+func GeoFromField(field Field) GeoField {
+	return GeoField{
+		Field: field,
+	}
+}
+
+func NewGeo(fieldName string, shape GeoShape) GeoField {
+	return GeoField{
+		Field: Field{
+			"type":  FieldTypeGeo,
+			"id":    fieldName,
+			"shape": shape,
+		},
+	}
+}
+
+func (field GeoField) GetID() string {
+	return field.Field.GetID()
+}
+
+func (field GeoField) GetShape() GeoShape {
+	shape, has := field.Field["shape"].(GeoShape)
+	if !has {
+		return GeoShape(field.Field.GetString("shape"))
+	}
+	return shape
+}
+
+func (field GeoField) ToField() Field {
+	return field.Field
+}
+
+// NewGeoPoint returns the GeoJSON point of the position.
+func NewGeoPoint(lng, lat float64) bson.M {
+	return bson.M{"type": "Point", "coordinates": bson.A{lng, lat}}
+}
+
+// NewGeoPolygon returns the GeoJSON polygon of the positions, which are [ lng, lat ]
+// pairs. The ring is closed if its last position is not the first one.
+func NewGeoPolygon(positions [][2]float64) bson.M {
+	ring := make(bson.A, 0, len(positions)+1)
+	for _, p := range positions {
+		ring = append(ring, bson.A{p[0], p[1]})
+	}
+	if len(positions) > 0 && positions[0] != positions[len(positions)-1] {
+		ring = append(ring, bson.A{positions[0][0], positions[0][1]})
+	}
+	return bson.M{"type": "Polygon", "coordinates": bson.A{ring}}
+}
+
+// geoPoint parses the position of a filter value, which is either a [ lng, lat ] pair
+// or a GeoJSON point. The coordinates must be in the valid ranges.
+func geoPoint(value interface{}) (match.GeoPoint, bool) {
+	points, isGeo := match.GeoPoints(value)
+	if doc, isDocument := match.AsDocument(value); isDocument && doc["type"] != "Point" {
+		return match.GeoPoint{}, false
+	}
+	if !isGeo || len(points) != 1 {
+		return match.GeoPoint{}, false
+	}
+	p := points[0]
+	return p, p.Lng >= -180 && p.Lng <= 180 && p.Lat >= -90 && p.Lat <= 90
+}
+
+func geoPoints(value interface{}) ([]match.GeoPoint, bool) {
+	items, isArray := match.AsArray(value)
+	if !isArray {
+		return nil, false
+	}
+	points := make([]match.GeoPoint, len(items))
+	for i, item := range items {
+		p, isPoint := geoPoint(item)
+		if !isPoint {
+			return nil, false
+		}
+		points[i] = p
+	}
+	return points, true
+}
+
+// geoDistance parses a distance in meters, which must be a non-negative number.
+func geoDistance(value interface{}) (float64, bool) {
+	number, isNumber := ParseNumber(value, "")
+	if !isNumber {
+		return 0, false
+	}
+	distance := numberToFloat(number)
+	return distance, distance >= 0 && !math.IsInf(distance, 0)
+}
+
+// nearValue parses the value of the near comparison.
+// Ex: { "point": [ 28.97, 41.01 ], "maxDistance": 1000, "minDistance": 10 }
+func (c Filter) nearValue() (point match.GeoPoint, minDistance, maxDistance interface{}, ok bool) {
+	value, isDocument := match.AsDocument(c.FieldValue)
+	if !isDocument {
+		return point, nil, nil, false
+	}
+	point, ok = geoPoint(value["point"])
+	for _, key := range []string{"minDistance", "maxDistance"} {
+		if value[key] == nil {
+			continue
+		}
+		distance, isDistance := geoDistance(value[key])
+		ok = ok && isDistance
+		if key == "minDistance" {
+			minDistance = distance
+		} else {
+			maxDistance = distance
+		}
+	}
+	return point, minDistance, maxDistance, ok
+}
+
+// geoShape returns the $geoWithin shape of the within comparisons. The boxes are
+// converted into polygons since $box doesn't work with GeoJSON.
+// within-box: [ [ lng, lat ], [ lng, lat ] ], the bottom left and top right corners
+// within-polygon: [ [ lng, lat ], ... ], at least 3 positions
+// within-radius: { "center": [ lng, lat ], "radius": meters }
+func (c Filter) geoShape() (bson.M, bool) {
+	switch c.Comparison {
+	case ComparisonWithinBox:
+		corners, isBox := geoPoints(c.FieldValue)
+		if !isBox || len(corners) != 2 || corners[0].Lng > corners[1].Lng || corners[0].Lat > corners[1].Lat {
+			return nil, false
+		}
+		min, max := corners[0], corners[1]
+		return bson.M{"$geometry": NewGeoPolygon([][2]float64{
+			{min.Lng, min.Lat}, {max.Lng, min.Lat}, {max.Lng, max.Lat}, {min.Lng, max.Lat},
+		})}, true
+	case ComparisonWithinPolygon:
+		points, isPolygon := geoPoints(c.FieldValue)
+		if !isPolygon || len(points) < 3 {
+			return nil, false
+		}
+		positions := make([][2]float64, len(points))
+		for i, p := range points {
+			positions[i] = [2]float64{p.Lng, p.Lat}
+		}
+		return bson.M{"$geometry": NewGeoPolygon(positions)}, true
+	case ComparisonWithinRadius:
+		value, isDocument := match.AsDocument(c.FieldValue)
+		if !isDocument {
+			return nil, false
+		}
+		center, isPoint := geoPoint(value["center"])
+		radius, isRadius := geoDistance(value["radius"])
+		if !isPoint || !isRadius {
+			return nil, false
+		}
+		return bson.M{"$centerSphere": bson.A{bson.A{center.Lng, center.Lat}, radius / match.EarthRadius}}, true
+	}
+	return nil, false
+}
+
+// geoCondition returns the condition of the geo comparisons. The invalid values,
+// which are rejected by Validate, don't match any value.
+func (c Filter) geoCondition() bson.M {
+	if c.Comparison == ComparisonNear {
+		point, minDistance, maxDistance, isValid := c.nearValue()
+		if !isValid {
+			return bson.M{"$in": bson.A{}}
+		}
+		near := bson.M{"$geometry": NewGeoPoint(point.Lng, point.Lat)}
+		if minDistance != nil {
+			near["$minDistance"] = minDistance
+		}
+		if maxDistance != nil {
+			near["$maxDistance"] = maxDistance
+		}
+		return bson.M{"$nearSphere": near}
+	}
+	shape, isValid := c.geoShape()
+	if !isValid {
+		return bson.M{"$in": bson.A{}}
+	}
+	return bson.M{"$geoWithin": shape}
+}
+
+// geoNearStage returns the $geoNear stage of the near comparison that sets the
+// distances to DistanceField and sorts the documents by distance.
+func (c Filter) geoNearStage() bson.M {
+	point, minDistance, maxDistance, _ := c.nearValue()
+	stage := bson.M{
+		"near":          NewGeoPoint(point.Lng, point.Lat),
+		"distanceField": DistanceField,
+		"key":           c.FieldId,
+		"spherical":     true,
+	}
+	if minDistance != nil {
+		stage["minDistance"] = minDistance
+	}
+	if maxDistance != nil {
+		stage["maxDistance"] = maxDistance
+	}
+	return bson.M{"$geoNear": stage}
+}
+
+// splitNear separates the near comparison from the rest of the filter since it's
+// converted into a $geoNear stage. The near comparison must be the filter itself or
+// in the top-level and group, which Validate checks.
+func (c *Filter) splitNear() (near *Filter, rest *Filter) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.Filters == nil {
+		if c.Comparison == ComparisonNear {
+			return c, nil
+		}
+		return nil, c
+	}
+	if c.Operator != OperatorAnd {
+		return nil, c
+	}
+	for i, filter := range c.Filters {
+		if filter.Filters == nil && filter.Comparison == ComparisonNear {
+			others := append(append([]Filter{}, c.Filters[:i]...), c.Filters[i+1:]...)
+			if len(others) == 0 {
+				return &c.Filters[i], nil
+			}
+			return &c.Filters[i], &Filter{Operator: OperatorAnd, Filters: others}
+		}
+	}
+	return nil, c
+}
+
+// validateNear returns the messages of the near comparisons that are not the filter
+// or in its top-level and group, and of the near comparisons after the first one.
+func (c Filter) validateNear(path string) []string {
+	messages := make([]string, 0)
+	if c.Filters == nil {
+		return messages
+	}
+	found := false
+	for i, filter := range c.Filters {
+		filterPath := path + ".filters[" + strconv.Itoa(i) + "]"
+		if filter.Filters != nil {
+			messages = append(messages, filter.validateNear(filterPath)...)
+			continue
+		}
+		if filter.Comparison != ComparisonNear {
+			continue
+		}
+		if c.Operator != OperatorAnd || path != "filter" {
+			messages = append(messages, filterPath+":near-not-top-level")
+		} else if found {
+			messages = append(messages, filterPath+":more-than-one-near")
+		}
+		found = true
+	}
+	return messages
+}
@@ -0,0 +1,286 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	core "github.com/devingen/api-core"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type DateBucket string
+
+const (
+	DateBucketDay   DateBucket = "day"
+	DateBucketWeek  DateBucket = "week"
+	DateBucketMonth DateBucket = "month"
+)
+
+// GroupKey is a field that the documents are grouped by. The date fields can be
+// grouped by the day, week or month that the dates are in.
+type GroupKey struct {
+	ID     string     `bson:"id" json:"id"`
+	Bucket DateBucket `bson:"bucket,omitempty" json:"bucket,omitempty"`
+}
+
+// GroupAggregate is a value computed over the documents of each group with the
+// function. The field is not used by the count function.
+// Ex: { "id": "revenue", "function": "sum", "fieldId": "total" }
+type GroupAggregate struct {
+	ID       string         `bson:"id" json:"id"`
+	Function RollupFunction `bson:"function" json:"function"`
+	FieldId  string         `bson:"fieldId,omitempty" json:"fieldId,omitempty"`
+}
+
+// GroupConfig is the configuration of a group-by query. The groups are sorted by the
+// keys if there isn't any sort config. The ids of the sort configs are either the
+// ids of the keys, the ids of the aggregates or count, so the ids of the keys and the
+// aggregates must be unique and can't be count.
+type GroupConfig struct {
+	Keys       []GroupKey       `bson:"keys" json:"keys"`
+	Aggregates []GroupAggregate `bson:"aggregates,omitempty" json:"aggregates,omitempty"`
+	Sort       []SortConfig     `bson:"sort,omitempty" json:"sort,omitempty"`
+	Limit      int              `bson:"limit,omitempty" json:"limit,omitempty"`
+}
+
+// Group is a result of a group-by query. The key contains the values of the keys at
+// their paths and the aggregates contain the aggregated values by their ids.
+// Ex: { "key": { "owner": { "name": "Ada" } }, "count": 3, "aggregates": { "revenue": 120 } }
+type Group struct {
+	Key        DataModel `bson:"key" json:"key"`
+	Count      int       `bson:"count" json:"count"`
+	Aggregates DataModel `bson:"aggregates,omitempty" json:"aggregates,omitempty"`
+}
+
+// ToGroupPipeline generates the aggregation pipeline that groups the documents that
+// match the filter of the config. The sort, pagination and selected fields of the
+// config are not used. It returns a DVNError with status 400 if the group config is
+// invalid. Ex: "group:unknown-field:owner.phone"
+func (c *QueryConfig) ToGroupPipeline(group GroupConfig) ([]bson.M, error) {
+	if messages := group.validate(c, "group:"); len(messages) > 0 {
+		return nil, core.NewErrors(http.StatusBadRequest, messages)
+	}
+	pipeline, err := c.matchStages()
+	if err != nil {
+		return nil, err
+	}
+	return append(pipeline, group.stages(c)...), nil
+}
+
+// ToFacetPipeline generates the aggregation pipeline that groups the documents that
+// match the filter of the config by each group config in a $facet. The result is a
+// single document that contains the groups by the names of the facets.
+func (c *QueryConfig) ToFacetPipeline(facets map[string]GroupConfig) ([]bson.M, error) {
+	messages := make([]string, 0)
+	facetStages := bson.M{}
+	for name, group := range facets {
+		if name == "" || strings.ContainsAny(name, ".$") {
+			messages = append(messages, "facets:invalid-name:"+name)
+			continue
+		}
+		if groupMessages := group.validate(c, "facets:"+name+":"); len(groupMessages) > 0 {
+			messages = append(messages, groupMessages...)
+			continue
+		}
+		facetStages[name] = group.stages(c)
+	}
+	if len(messages) > 0 {
+		return nil, core.NewErrors(http.StatusBadRequest, messages)
+	}
+
+	pipeline, err := c.matchStages()
+	if err != nil {
+		return nil, err
+	}
+	return append(pipeline, bson.M{"$facet": facetStages}), nil
+}
+
+// stages returns the stages that group the documents. The keys and the aggregates are
+// named by their indexes in the $group stage since the paths can't be used as the
+// field names, and then projected to their paths.
+func (g GroupConfig) stages(config *QueryConfig) []bson.M {
+	var id interface{}
+	key := bson.M{}
+	names := map[string]string{"count": "count"}
+	if len(g.Keys) > 0 {
+		keys := bson.M{}
+		for i, k := range g.Keys {
+			name := "k" + strconv.Itoa(i)
+			keys[name] = k.expression(config)
+			names[k.ID] = "_id." + name
+			setKeyPath(key, k.ID, "$_id."+name)
+		}
+		id = keys
+	}
+
+	groupStage := bson.M{"_id": id, "count": bson.M{"$sum": 1}}
+	aggregates := bson.M{}
+	for i, a := range g.Aggregates {
+		name := "a" + strconv.Itoa(i)
+		var expression interface{} = "$" + a.FieldId
+		if a.Function == RollupFunctionCount {
+			expression = 1
+		}
+		groupStage[name] = bson.M{rollupAccumulators[a.Function]: expression}
+		if a.Function == RollupFunctionDistinctCount {
+			aggregates[a.ID] = bson.M{"$size": "$" + name}
+		} else {
+			aggregates[a.ID] = "$" + name
+		}
+		names[a.ID] = name
+	}
+
+	sort := g.Sort
+	if len(sort) == 0 {
+		for _, k := range g.Keys {
+			sort = append(sort, SortConfig{ID: k.ID, Order: 1})
+		}
+	}
+	sortStage := make(bson.D, 0, len(sort))
+	for _, s := range sort {
+		sortStage = append(sortStage, bson.E{Key: names[s.ID], Value: sortDirection(s.Order)})
+	}
+
+	stages := []bson.M{{"$group": groupStage}}
+	if len(sortStage) > 0 {
+		stages = append(stages, bson.M{"$sort": sortStage})
+	}
+	if g.Limit > 0 {
+		stages = append(stages, bson.M{"$limit": g.Limit})
+	}
+	project := bson.M{"_id": 0, "key": key, "count": 1}
+	if len(key) == 0 {
+		project["key"] = bson.M{"$literal": bson.M{}}
+	}
+	if len(aggregates) > 0 {
+		project["aggregates"] = aggregates
+	}
+	return append(stages, bson.M{"$project": project})
+}
+
+// validate returns the messages of the invalid keys, aggregates and sort configs with
+// the prefix.
+func (g GroupConfig) validate(config *QueryConfig, prefix string) []string {
+	messages := make([]string, 0)
+	ids := map[string]bool{"count": true}
+	keyIDs := make([]string, 0, len(g.Keys))
+	for _, k := range g.Keys {
+		// the sort configs refer to the count of the groups by count
+		if k.ID == "count" {
+			messages = append(messages, "reserved-key:"+k.ID)
+			continue
+		}
+		if message := validateSelectPath(config.Fields, k.ID); message != "" {
+			messages = append(messages, message)
+			continue
+		}
+		// the values of the keys are set at their paths in the key of the group
+		if message := validateKeyPath(keyIDs, k.ID); message != "" {
+			messages = append(messages, message)
+			continue
+		}
+		keyIDs = append(keyIDs, k.ID)
+		ids[k.ID] = true
+		if k.Bucket == "" {
+			continue
+		}
+		if k.Bucket != DateBucketDay && k.Bucket != DateBucketWeek && k.Bucket != DateBucketMonth {
+			messages = append(messages, "invalid-bucket:"+k.ID+":"+string(k.Bucket))
+		} else if field := config.GetField(k.ID); field != nil && field.GetValueType() != FieldTypeDate {
+			messages = append(messages, "invalid-bucket-for-field-type:"+k.ID+":"+string(field.GetValueType()))
+		}
+	}
+
+	for _, a := range g.Aggregates {
+		// the ids of the sort configs can't refer to both an aggregate and a key
+		if a.ID == "" || strings.ContainsAny(a.ID, ".$") || ids[a.ID] {
+			messages = append(messages, "invalid-aggregate-id:"+a.ID)
+			continue
+		}
+		ids[a.ID] = true
+		if _, isKnown := rollupAccumulators[a.Function]; !isKnown {
+			messages = append(messages, "invalid-function:"+a.ID+":"+string(a.Function))
+			continue
+		}
+		if a.Function == RollupFunctionCount {
+			continue
+		}
+		if a.FieldId == "" {
+			messages = append(messages, "field-required:"+a.ID)
+		} else if message := validateSelectPath(config.Fields, a.FieldId); message != "" {
+			messages = append(messages, message)
+		}
+	}
+
+	for _, s := range g.Sort {
+		if !ids[s.ID] {
+			messages = append(messages, "invalid-sort:"+s.ID)
+		}
+	}
+	for i, message := range messages {
+		messages[i] = prefix + message
+	}
+	return messages
+}
+
+// validateKeyPath returns the message of the path if it's the path of another key or
+// one of them is in the other, since only one of their values can be set in the key
+// of the group. Ex: "owner" and "owner.name"
+func validateKeyPath(paths []string, path string) string {
+	for _, p := range paths {
+		if p == path {
+			return "duplicate-key:" + path
+		}
+		if strings.HasPrefix(path, p+".") || strings.HasPrefix(p, path+".") {
+			return "overlapping-key:" + path
+		}
+	}
+	return ""
+}
+
+// expression returns the expression of the key's values. The date buckets are
+// computed in the time zone of the config like the date filters, and the weeks start
+// on the config's week start.
+func (k GroupKey) expression(config *QueryConfig) interface{} {
+	if k.Bucket == "" {
+		return "$" + k.ID
+	}
+	trunc := bson.M{
+		"date":     "$" + k.ID,
+		"unit":     string(k.Bucket),
+		"timezone": timezoneName(config.GetLocation(), config.GetNow()),
+	}
+	if k.Bucket == DateBucketWeek {
+		trunc["startOfWeek"] = strings.ToLower(config.GetWeekStart().String())
+	}
+	return bson.M{"$dateTrunc": trunc}
+}
+
+// setKeyPath sets the value at the dotted path of the key, creating the documents
+// on the path.
+func setKeyPath(key bson.M, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, isDocument := key[part].(bson.M)
+		if !isDocument {
+			child = bson.M{}
+			key[part] = child
+		}
+		key = child
+	}
+	key[parts[len(parts)-1]] = value
+}
+
+// timezoneName returns the name of the time zone for MongoDB, which is either an Olson
+// time zone identifier or a UTC offset. The offset at the time is used for the local
+// time zone and the fixed zones since MongoDB doesn't know their names.
+func timezoneName(loc *time.Location, at time.Time) string {
+	if name := loc.String(); name != "Local" {
+		if _, err := time.LoadLocation(name); err == nil {
+			return name
+		}
+	}
+	return at.In(loc).Format("-07:00")
+}
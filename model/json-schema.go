@@ -0,0 +1,327 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// JSONSchemaDialect is the JSON Schema draft of the schemas that JSONSchema generates.
+// The min and max of the date fields are the formatMinimum and formatMaximum keywords
+// of ajv-formats, which are not in the draft, so the other validators ignore them.
+// The bounds are always checked by Validate and by the MongoValidator of a collection.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// objectIDPattern is the pattern of the hex strings that the ObjectIDs are encoded to.
+const objectIDPattern = "^[0-9a-fA-F]{24}$"
+
+// JSONSchema generates the JSON Schema of the documents that have the fields, which
+// describes the values that Validate accepts. The fields that are computed or
+// populated by the lookups are read-only, the references are either the hex strings
+// of the ids or DBRefs, the dates are RFC3339 strings and the keys that are not in the
+// fields are allowed unless the policy rejects them. The reserved keys such as _id are
+// always allowed.
+func JSONSchema(fields []Field, policy UnknownFieldPolicy) bson.M {
+	schema := JSONSchemaConverter{Policy: policy}.object(fields)
+	schema["$schema"] = JSONSchemaDialect
+	return schema
+}
+
+// MongoJSONSchema generates the $jsonSchema validator of the collection of the
+// documents that have the fields, which describes the values as they are stored after
+// Validate converts them. It's the BSON version of JSONSchema without the fields that
+// are not stored and the keywords that MongoDB doesn't support, such as default.
+func MongoJSONSchema(fields []Field, policy UnknownFieldPolicy) bson.M {
+	return JSONSchemaConverter{IsBSON: true, Policy: policy}.object(fields)
+}
+
+// MongoValidator generates the validator of the collection of the documents that have
+// the fields. It's the $jsonSchema of MongoJSONSchema and the conditions of the min
+// and max rules of the dates, which $jsonSchema can't compare. A condition on an
+// array fails if any of its elements is out of the bounds.
+// Ex: { "$jsonSchema": { ... }, "$and": [ { "dueDate": { "$not": { "$lt": min } } } ] }
+func MongoValidator(fields []Field, policy UnknownFieldPolicy) bson.M {
+	validator := bson.M{"$jsonSchema": MongoJSONSchema(fields, policy)}
+	if bounds := dateBounds(fields, ""); len(bounds) > 0 {
+		validator["$and"] = bounds
+	}
+	return validator
+}
+
+// dateBounds returns the conditions of the bounds of the date fields, including the
+// ones in the objects and the arrays. The null values and the values of the other
+// types don't match $lt and $gt, so they are left to $jsonSchema.
+func dateBounds(fields []Field, prefix string) []bson.M {
+	conditions := make([]bson.M, 0)
+	for _, field := range fields {
+		path := prefix + field.GetID()
+		if field.GetType() == FieldTypeArray {
+			items := ArrayFromField(field).GetItems()
+			if items == nil {
+				continue
+			}
+			field = *items
+		}
+		switch field.GetType() {
+		case FieldTypeObject:
+			conditions = append(conditions, dateBounds(field.GetFields(), path+".")...)
+		case FieldTypeDate:
+			if min, isDate := toTime(field.GetInterface("min")); isDate {
+				conditions = append(conditions, bson.M{path: bson.M{"$not": bson.M{"$lt": min}}})
+			}
+			if max, isDate := toTime(field.GetInterface("max")); isDate {
+				conditions = append(conditions, bson.M{path: bson.M{"$not": bson.M{"$gt": max}}})
+			}
+		}
+	}
+	return conditions
+}
+
+// JSONSchemaConverter converts the fields into JSON Schema, or into the dialect of
+// $jsonSchema if IsBSON is true. The policy is the one of ValidateWithPolicy. It's
+// passed to the JSONSchema of the field type definitions, which can call Value for
+// the schemas of the inner fields.
+type JSONSchemaConverter struct {
+	IsBSON bool
+	Policy UnknownFieldPolicy
+}
+
+func (c JSONSchemaConverter) object(fields []Field) bson.M {
+	properties := bson.M{}
+	required := make([]string, 0)
+	for _, field := range fields {
+		if c.IsBSON && isReadOnlyFieldType(field.GetType()) {
+			continue
+		}
+		properties[field.GetID()] = c.field(field)
+		if field.GetBool("required") {
+			required = append(required, field.GetID())
+		}
+	}
+
+	schema := c.typed("object", "object")
+	schema["properties"] = properties
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if c.Policy == UnknownFieldsReject {
+		schema["patternProperties"] = bson.M{"^_": bson.M{}}
+		schema["additionalProperties"] = false
+	}
+	return schema
+}
+
+func (c JSONSchemaConverter) field(field Field) bson.M {
+	schema := c.Value(field)
+	if field.GetType() != FieldTypeAny && field.GetType() != FieldTypeDataTransfer && !isReadOnlyFieldType(field.GetType()) {
+		// the null values are valid unless the field is required
+		schema = c.nullable(schema)
+	}
+	if c.IsBSON {
+		return schema
+	}
+	if isReadOnlyFieldType(field.GetType()) {
+		schema["readOnly"] = true
+	}
+	if value := field.GetInterface("default"); value != nil {
+		schema["default"] = value
+	}
+	return schema
+}
+
+// Value returns the schema of the values of the field without null, which is
+// generated by the JSONSchema of the definition of its type. The values of the
+// fields whose types don't have it are not known, so their schema is empty.
+func (c JSONSchemaConverter) Value(field Field) bson.M {
+	if definition, has := GetFieldTypeDefinition(field.GetType()); has && definition.JSONSchema != nil {
+		return definition.JSONSchema(c, field)
+	}
+	return bson.M{}
+}
+
+func (c JSONSchemaConverter) textSchema(field Field) bson.M {
+	schema := c.typed("string", "string")
+	copyRules(field, schema, map[string]string{"minLength": "minLength", "maxLength": "maxLength", "pattern": "pattern"})
+	return schema
+}
+
+func (c JSONSchemaConverter) numberSchema(field Field) bson.M {
+	schema := c.typed("number", "number")
+	if NumberFromField(field).GetFormat() == NumberFormatInteger {
+		schema = c.typed("integer", bson.A{"int", "long"})
+	}
+	copyRules(field, schema, map[string]string{"min": "minimum", "max": "maximum"})
+	return schema
+}
+
+func (c JSONSchemaConverter) booleanSchema(field Field) bson.M {
+	return c.typed("boolean", "bool")
+}
+
+func (c JSONSchemaConverter) dateSchema(field Field) bson.M {
+	if c.IsBSON {
+		// $jsonSchema can't compare the dates, see MongoValidator
+		return bson.M{"bsonType": "date"}
+	}
+	schema := bson.M{"type": "string", "format": "date-time"}
+	// the bounds are ajv-formats keywords, see JSONSchemaDialect
+	copyRules(field, schema, map[string]string{"min": "formatMinimum", "max": "formatMaximum"})
+	return schema
+}
+
+func (c JSONSchemaConverter) selectSchema(field Field) bson.M {
+	selectField := SelectFromField(field)
+	values := bson.A{}
+	for _, option := range selectField.GetOptions() {
+		values = append(values, option.Value)
+	}
+	option := c.typed("string", "string")
+	option["enum"] = values
+	if !selectField.IsMulti() {
+		return option
+	}
+	schema := c.typed("array", "array")
+	schema["items"] = option
+	schema["uniqueItems"] = true
+	copyRules(field, schema, map[string]string{"minItems": "minItems", "maxItems": "maxItems"})
+	return schema
+}
+
+func (c JSONSchemaConverter) referenceSchema(field Field) bson.M {
+	ref := c.reference(ReferenceFromField(field))
+	if ReferenceFromField(field).IsSingle() {
+		return ref
+	}
+	schema := c.typed("array", "array")
+	schema["items"] = ref
+	return schema
+}
+
+func (c JSONSchemaConverter) geoSchema(field Field) bson.M {
+	return c.geo(GeoFromField(field).GetShape())
+}
+
+func (c JSONSchemaConverter) objectSchema(field Field) bson.M {
+	return c.object(field.GetFields())
+}
+
+func (c JSONSchemaConverter) arraySchema(field Field) bson.M {
+	schema := c.typed("array", "array")
+	if items := ArrayFromField(field).GetItems(); items != nil {
+		schema["items"] = c.Value(*items)
+	}
+	copyRules(field, schema, map[string]string{"minItems": "minItems", "maxItems": "maxItems"})
+	return schema
+}
+
+// computedSchema returns the schema of the values of the result type of the formula
+// and the rollup fields without the rules of the field.
+func (c JSONSchemaConverter) computedSchema(field Field) bson.M {
+	switch valueType := field.GetValueType(); valueType {
+	case FieldTypeText, FieldTypeNumber, FieldTypeBoolean, FieldTypeDate:
+		return c.Value(New(valueType, ""))
+	}
+	return bson.M{}
+}
+
+// lookupSchema returns the schema of the looked up documents.
+func (c JSONSchemaConverter) lookupSchema(field Field) bson.M {
+	if field.GetBool("isSingle") {
+		return c.typed("object", "object")
+	}
+	return c.typed("array", "array")
+}
+
+// reference returns the schema of a reference, which is stored as a DBRef. The hex
+// string of the id is accepted in JSON as well.
+func (c JSONSchemaConverter) reference(field ReferenceField) bson.M {
+	ref := c.typed("object", "object")
+	ref["properties"] = bson.M{
+		"_id":  c.objectID(),
+		"_ref": c.typed("string", "string"),
+		"_db":  c.typed("string", "string"),
+	}
+	ref["required"] = []string{"_id"}
+	if c.IsBSON {
+		return ref
+	}
+	ref["properties"].(bson.M)["_ref"] = bson.M{"const": field.GetOtherCollection()}
+	return bson.M{"oneOf": bson.A{c.objectID(), ref}}
+}
+
+func (c JSONSchemaConverter) objectID() bson.M {
+	if c.IsBSON {
+		return bson.M{"bsonType": "objectId"}
+	}
+	return bson.M{"type": "string", "pattern": objectIDPattern}
+}
+
+// geo returns the schema of the GeoJSON point or polygon. The positions are longitude
+// and latitude pairs.
+func (c JSONSchemaConverter) geo(shape GeoShape) bson.M {
+	position := c.typed("array", "array")
+	position["items"] = c.typed("number", "number")
+	position["minItems"] = 2
+	position["maxItems"] = 2
+
+	geometryType := bson.A{"Point", "Polygon"}
+	var coordinates bson.M
+	switch shape {
+	case GeoShapePoint:
+		geometryType = bson.A{"Point"}
+		coordinates = position
+	case GeoShapePolygon:
+		geometryType = bson.A{"Polygon"}
+		ring := c.typed("array", "array")
+		ring["items"] = position
+		ring["minItems"] = 4
+		coordinates = c.typed("array", "array")
+		coordinates["items"] = ring
+	default:
+		coordinates = c.typed("array", "array")
+	}
+
+	schema := c.typed("object", "object")
+	schema["properties"] = bson.M{
+		"type":        bson.M{"enum": geometryType},
+		"coordinates": coordinates,
+	}
+	schema["required"] = []string{"type", "coordinates"}
+	return schema
+}
+
+// typed returns the schema of the JSON type or the BSON type.
+func (c JSONSchemaConverter) typed(jsonType string, bsonType interface{}) bson.M {
+	if c.IsBSON {
+		return bson.M{"bsonType": bsonType}
+	}
+	return bson.M{"type": jsonType}
+}
+
+// nullable adds null to the types and the values of the schema.
+func (c JSONSchemaConverter) nullable(schema bson.M) bson.M {
+	key, null := "type", "null"
+	if c.IsBSON {
+		key = "bsonType"
+	}
+	enum, hasEnum := schema["enum"].(bson.A)
+	if hasEnum {
+		schema["enum"] = append(enum, nil)
+	}
+	switch t := schema[key].(type) {
+	case string:
+		schema[key] = bson.A{t, null}
+	case bson.A:
+		schema[key] = append(t, null)
+	default:
+		if !hasEnum {
+			return bson.M{"anyOf": bson.A{schema, bson.M{key: null}}}
+		}
+	}
+	return schema
+}
+
+// copyRules copies the rules of the field to the schema with the keywords.
+func copyRules(field Field, schema bson.M, keywords map[string]string) {
+	for rule, keyword := range keywords {
+		if value := field.GetInterface(rule); value != nil {
+			schema[keyword] = value
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestJSONSchema(t *testing.T) {
+	title := New(FieldTypeText, "title").SetInt("maxLength", 20)
+	title["required"] = true
+	quantity := NewNumber("quantity", NumberFormatInteger).ToField().SetInt("min", 1)
+	quantity["default"] = 1
+	fields := []Field{
+		title, quantity,
+		New(FieldTypeDate, "dueDate").SetString("min", "2020-01-01T00:00:00Z"),
+		NewSelect("status", []SelectOption{{Value: "open"}, {Value: "closed"}}).ToField(),
+		NewReference("owner", "users", true).ToField(),
+		NewGeo("location", GeoShapePoint).ToField(),
+		NewArray("tags", New(FieldTypeText, "")).ToField(),
+		NewRollup("total", "lines", "order", RollupFunctionSum, "price").ToField(),
+	}
+
+	schema := JSONSchema(fields, UnknownFieldsReject)
+	assert.Equal(t, JSONSchemaDialect, schema["$schema"])
+	assert.Equal(t, []string{"title"}, schema["required"])
+	assert.Equal(t, false, schema["additionalProperties"])
+	assert.Equal(t, bson.M{"^_": bson.M{}}, schema["patternProperties"])
+	properties := schema["properties"].(bson.M)
+	assert.Equal(t, bson.M{"type": bson.A{"string", "null"}, "maxLength": 20}, properties["title"])
+	assert.Equal(t, bson.M{"type": bson.A{"integer", "null"}, "minimum": 1, "default": 1}, properties["quantity"])
+	assert.Equal(t, bson.M{"type": bson.A{"string", "null"}, "format": "date-time", "formatMinimum": "2020-01-01T00:00:00Z"}, properties["dueDate"])
+	assert.Equal(t, bson.M{"type": bson.A{"string", "null"}, "enum": bson.A{"open", "closed", nil}}, properties["status"])
+	assert.Equal(t, bson.M{"anyOf": bson.A{
+		bson.M{"oneOf": bson.A{
+			bson.M{"type": "string", "pattern": objectIDPattern},
+			bson.M{
+				"type": "object",
+				"properties": bson.M{
+					"_id":  bson.M{"type": "string", "pattern": objectIDPattern},
+					"_ref": bson.M{"const": "users"},
+					"_db":  bson.M{"type": "string"},
+				},
+				"required": []string{"_id"},
+			},
+		}},
+		bson.M{"type": "null"},
+	}}, properties["owner"])
+	assert.Equal(t, bson.M{"type": bson.A{"array", "null"}, "items": bson.M{"type": "string"}}, properties["tags"])
+	assert.Equal(t, bson.M{"type": "number", "readOnly": true}, properties["total"])
+	_, err := json.Marshal(schema)
+	assert.Nil(t, err)
+
+	validator := MongoJSONSchema(fields, UnknownFieldsStrip)
+	assert.Equal(t, []string{"title"}, validator["required"])
+	assert.NotContains(t, validator, "additionalProperties")
+	properties = validator["properties"].(bson.M)
+	assert.Equal(t, bson.M{"bsonType": bson.A{"int", "long", "null"}, "minimum": 1}, properties["quantity"])
+	assert.Equal(t, bson.M{"bsonType": bson.A{"date", "null"}}, properties["dueDate"])
+	assert.Equal(t, bson.M{
+		"bsonType": bson.A{"object", "null"},
+		"properties": bson.M{
+			"_id":  bson.M{"bsonType": "objectId"},
+			"_ref": bson.M{"bsonType": "string"},
+			"_db":  bson.M{"bsonType": "string"},
+		},
+		"required": []string{"_id"},
+	}, properties["owner"])
+	assert.Equal(t, bson.A{"Point"}, properties["location"].(bson.M)["properties"].(bson.M)["type"].(bson.M)["enum"])
+	assert.NotContains(t, properties, "total")
+	_, err = bson.Marshal(validator)
+	assert.Nil(t, err)
+
+	lines := NewArray("lines", NewObject("", []Field{
+		New(FieldTypeDate, "shippedAt").SetString("max", "2030-01-01T00:00:00Z"),
+	}).ToField()).ToField()
+	assert.Equal(t, bson.M{
+		"$jsonSchema": MongoJSONSchema(append(fields, lines), UnknownFieldsReject),
+		"$and": []bson.M{
+			{"dueDate": bson.M{"$not": bson.M{"$lt": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}}},
+			{"lines.shippedAt": bson.M{"$not": bson.M{"$gt": time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}}},
+		},
+	}, MongoValidator(append(fields, lines), UnknownFieldsReject))
+}
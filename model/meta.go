@@ -1,5 +1,20 @@
 package model
 
 type Meta struct {
-	Total int `json:"total"`
+	Total int `bson:"total" json:"total"`
+}
+
+// ListResult is the result of the pipeline generated by QueryConfig.ToListPipeline.
+type ListResult struct {
+	Results []*DataModel `bson:"results" json:"results"`
+	Meta    []Meta       `bson:"meta" json:"meta"`
+}
+
+// GetMeta returns the meta of the list. The total is 0 if there isn't any document
+// since $count doesn't return a document for the empty lists.
+func (r ListResult) GetMeta() *Meta {
+	if len(r.Meta) == 0 {
+		return &Meta{}
+	}
+	return &r.Meta[0]
 }
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type NumberFormat string
+
+const (
+	// NumberFormatInteger is for the whole numbers, which are stored as int64.
+	NumberFormatInteger NumberFormat = "integer"
+
+	// NumberFormatDecimal is for the numbers that are stored as Decimal128 to keep
+	// their precision. Ex: prices
+	NumberFormatDecimal NumberFormat = "decimal"
+
+	// NumberFormatPercent is for the ratios. 15% is stored as 0.15.
+	NumberFormatPercent NumberFormat = "percent"
+)
+
+type NumberField struct {
+	Field
+}
+
+func NumberFromField(field Field) NumberField {
+	return NumberField{
+		Field: field,
+	}
+}
+
+func NewNumber(fieldName string, format NumberFormat) NumberField {
+	field := NumberField{
+		Field: New(FieldTypeNumber, fieldName),
+	}
+	if format != "" {
+		field.Field["format"] = format
+	}
+	return field
+}
+
+func (field NumberField) GetID() string {
+	return field.Field.GetID()
+}
+
+func (field NumberField) GetFormat() NumberFormat {
+	format, has := field.Field["format"].(NumberFormat)
+	if !has {
+		return NumberFormat(field.Field.GetString("format"))
+	}
+	return format
+}
+
+// ParseValue converts the filter value into the number that is compared with the
+// values of the field.
+func (field NumberField) ParseValue(value interface{}) (interface{}, bool) {
+	return ParseNumber(value, field.GetFormat())
+}
+
+// ParseNumber converts the numbers of any Go or BSON type and the numbers encoded as
+// strings into int64, float64 or Decimal128 without losing their precision or sign.
+// The whole numbers are converted into int64 and the others into float64 except the
+// numbers of the decimal format, which are converted into Decimal128 since a float64
+// like 9.99 is not equal to the Decimal128 9.99 in MongoDB. The strings of the percent
+// format may have the % suffix. Ex: "15%" is 0.15
+func ParseNumber(value interface{}, format NumberFormat) (interface{}, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float32:
+		return parseFloat(float64(v), format)
+	case float64:
+		return parseFloat(v, format)
+	case primitive.Decimal128:
+		return v, true
+	case string:
+		return parseNumberString(strings.TrimSpace(v), format)
+	}
+	return nil, false
+}
+
+func parseFloat(f float64, format NumberFormat) (interface{}, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, false
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f), true
+	}
+	if format == NumberFormatDecimal {
+		// the shortest representation is the number the user has written
+		d, err := primitive.ParseDecimal128(strconv.FormatFloat(f, 'f', -1, 64))
+		return d, err == nil
+	}
+	return f, true
+}
+
+func parseNumberString(s string, format NumberFormat) (interface{}, bool) {
+	if format == NumberFormatPercent && strings.HasSuffix(s, "%") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil {
+			return nil, false
+		}
+		return parseFloat(f/100, format)
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, true
+	}
+	if format == NumberFormatDecimal {
+		d, err := primitive.ParseDecimal128(s)
+		if err != nil {
+			return nil, false
+		}
+		return d, true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, false
+	}
+	return parseFloat(f, format)
+}
+
+// isInteger reports whether the parsed number is a whole number.
+func isInteger(number interface{}) bool {
+	switch v := number.(type) {
+	case int64:
+		return true
+	case float64:
+		return v == math.Trunc(v)
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return err == nil && f == math.Trunc(f)
+	}
+	return false
+}
+
+func (field NumberField) ToField() Field {
+	return field.Field
+}
@@ -0,0 +1,201 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ObjectField stores a document whose fields are the inner fields of the field.
+// Its inner fields can be filtered by their paths. Ex: { "id": "address.city" }
+type ObjectField struct {
+	Field
+}
+
+func ObjectFromField(field Field) ObjectField {
+	return ObjectField{
+		Field: field,
+	}
+}
+
+func NewObject(fieldName string, fields []Field) ObjectField {
+	field := ObjectField{
+		Field: New(FieldTypeObject, fieldName),
+	}
+	field.Field.SetFields(fields)
+	return field
+}
+
+func (field ObjectField) GetID() string {
+	return field.Field.GetID()
+}
+
+func (field ObjectField) GetFields() []Field {
+	return field.Field.GetFields()
+}
+
+func (field ObjectField) ToField() Field {
+	return field.Field
+}
+
+// ArrayField stores an array whose elements are the values of the items field, which
+// doesn't have an id. The comparisons of the items field match the arrays that have
+// an element that matches them, and the elem-match comparison matches the arrays that
+// have an element that matches all of its filters.
+// Ex: NewArray("lines", NewObject("", []Field{ NewNumber("price", ""), ... }).ToField())
+type ArrayField struct {
+	Field
+}
+
+func ArrayFromField(field Field) ArrayField {
+	return ArrayField{
+		Field: field,
+	}
+}
+
+func NewArray(fieldName string, items Field) ArrayField {
+	return ArrayField{
+		Field: Field{
+			"type":  FieldTypeArray,
+			"id":    fieldName,
+			"items": items,
+		},
+	}
+}
+
+func (field ArrayField) GetID() string {
+	return field.Field.GetID()
+}
+
+// GetItems returns the field of the elements that is either set as a Field or decoded
+// from JSON or BSON as a document. It returns nil if the elements are untyped.
+func (field ArrayField) GetItems() *Field {
+	if items, has := field.GetInterface("items").(Field); has {
+		return &items
+	}
+	items, isDocument := match.AsDocument(field.GetInterface("items"))
+	if !isDocument {
+		return nil
+	}
+	itemsField := Field(items)
+	return &itemsField
+}
+
+func (field ArrayField) ToField() Field {
+	return field.Field
+}
+
+// elementFields returns the inner fields of the object fields and of the elements of
+// the array fields. It reports false for the other fields.
+func elementFields(field Field) ([]Field, bool) {
+	if field.GetType() == FieldTypeArray {
+		items := ArrayFromField(field).GetItems()
+		if items == nil {
+			return nil, false
+		}
+		field = *items
+	}
+	if field.GetType() != FieldTypeObject {
+		return nil, false
+	}
+	return field.GetFields(), true
+}
+
+// fieldAtPath returns the field at the dotted path in the object and array fields.
+// The indexes of the array elements are skipped. Ex: "lines.0.price" is the price
+// field of the items of the lines field.
+func fieldAtPath(fields []Field, path string) *Field {
+	parts := strings.Split(path, ".")
+	for i := 0; i < len(parts); i++ {
+		var field *Field
+		for _, f := range fields {
+			if f.GetID() == parts[i] {
+				field = &f
+				break
+			}
+		}
+		if field == nil || i == len(parts)-1 {
+			return field
+		}
+
+		if field.GetType() == FieldTypeArray {
+			if _, err := strconv.Atoi(parts[i+1]); err == nil {
+				i++
+				if i == len(parts)-1 {
+					return ArrayFromField(*field).GetItems()
+				}
+			}
+		}
+		innerFields, hasInnerFields := elementFields(*field)
+		if !hasInnerFields {
+			return nil
+		}
+		fields = innerFields
+	}
+	return nil
+}
+
+// elemMatchQuery generates the query of the condition on the dotted path that matches
+// the condition on the same element of each array on the path. The indexes of the
+// array elements refer to a single element, so they are kept in the path.
+// Ex: { "lines": { "$elemMatch": { "price": { "$gte": 10, "$lte": 20 } } } }
+func elemMatchQuery(fields []Field, path string, condition bson.M) bson.M {
+	parts := strings.Split(path, ".")
+	for i := 0; i < len(parts)-1; i++ {
+		var field *Field
+		for _, f := range fields {
+			if f.GetID() == parts[i] {
+				field = &f
+				break
+			}
+		}
+		if field == nil {
+			break
+		}
+
+		if field.GetType() == FieldTypeArray {
+			if _, err := strconv.Atoi(parts[i+1]); err != nil {
+				itemFields, _ := elementFields(*field)
+				inner := elemMatchQuery(itemFields, strings.Join(parts[i+1:], "."), condition)
+				return bson.M{strings.Join(parts[:i+1], "."): bson.M{"$elemMatch": inner}}
+			}
+			i++
+		}
+		innerFields, hasInnerFields := elementFields(*field)
+		if !hasInnerFields {
+			break
+		}
+		fields = innerFields
+	}
+	return bson.M{path: condition}
+}
+
+// elemFilter returns the filter of the elem-match comparison that is either set as a
+// Filter or decoded from JSON or BSON as a document.
+func (c Filter) elemFilter() *Filter {
+	switch value := c.FieldValue.(type) {
+	case *Filter:
+		return value
+	case Filter:
+		return &value
+	}
+	value, isDocument := match.AsDocument(c.FieldValue)
+	if !isDocument {
+		return nil
+	}
+	return FilterFromMap(value)
+}
+
+// elemConfig returns the config of the filters of the elem-match comparison, whose
+// fields are the inner fields of the elements.
+func elemConfig(config *QueryConfig, fields []Field) *QueryConfig {
+	elem := &QueryConfig{}
+	if config != nil {
+		*elem = *config
+	}
+	elem.Fields = fields
+	elem.Filter = nil
+	return elem
+}
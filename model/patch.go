@@ -0,0 +1,683 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	core "github.com/devingen/api-core"
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// ContentTypeJSONPatch is the content type of the JSON Patch (RFC 6902) bodies.
+	ContentTypeJSONPatch = "application/json-patch+json"
+
+	// ContentTypeMergePatch is the content type of the JSON Merge Patch (RFC 7396)
+	// bodies.
+	ContentTypeMergePatch = "application/merge-patch+json"
+)
+
+// Patch is a partial change of a data model, which is either a JSONPatch or a
+// MergePatch. See PatchDataModel.
+type Patch interface {
+	// Apply applies the patch to the data model. The data model is not changed if
+	// the patch fails.
+	Apply(dm DataModel) error
+
+	// apply applies the patch to the data model in place and returns the changes to
+	// compile the update document from.
+	apply(dm DataModel) ([]patchChange, error)
+}
+
+// ParsePatch parses the body of a PATCH request by its content type. The bodies of
+// application/json are parsed as merge patches. It returns a DVNError with status 415
+// if the content type is not supported.
+func ParsePatch(contentType string, data []byte) (Patch, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, core.NewError(http.StatusUnsupportedMediaType, "unsupported-patch-type:"+contentType)
+	}
+	switch mediaType {
+	case ContentTypeJSONPatch:
+		return ParseJSONPatch(data)
+	case ContentTypeMergePatch, "application/json":
+		return ParseMergePatch(data)
+	}
+	return nil, core.NewError(http.StatusUnsupportedMediaType, "unsupported-patch-type:"+mediaType)
+}
+
+// PatchDataModel applies the patch to a copy of the data model and checks the result
+// against the fields with Validate. It returns the patched data model and the update
+// document that makes the same change in the database with the $set, $unset, $push
+// and $pull operators. The update document is empty if the patch doesn't change
+// anything.
+//
+// The changes of the array elements are compiled into $push and $pull if possible,
+// otherwise the whole array is set. The values of the update document are the values
+// that Validate converts, so they are stored with the types of the fields, and the
+// defaults that Validate sets are set by the update document as well, so the older
+// documents that don't have the defaulted fields get them when they are patched.
+func PatchDataModel(dm DataModel, patch Patch, fields []Field) (DataModel, bson.M, error) {
+	return PatchDataModelWithPolicy(dm, patch, fields, UnknownFieldsReject)
+}
+
+// PatchDataModelWithPolicy patches the data model like PatchDataModel and checks the
+// result with ValidateWithPolicy, so the keys that are not in the fields are handled
+// by the policy. The keys that the patch changes and the policy strips are unset by
+// the update document.
+func PatchDataModelWithPolicy(dm DataModel, patch Patch, fields []Field, policy UnknownFieldPolicy) (DataModel, bson.M, error) {
+	patched := dm.Copy()
+	changes, err := patch.apply(patched)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ValidateWithPolicy(patched, fields, policy); err != nil {
+		return nil, nil, err
+	}
+	changes = append(changes, addedChanges(dm, patched, "")...)
+	return patched, updateOf(changes, patched), nil
+}
+
+// addedChanges returns the $set changes of the keys that are in the patched document
+// but not in the document, such as the defaults that Validate sets. The documents in
+// the arrays are compared by their indexes, and the elements that are pushed by the
+// patch are skipped since their values are pushed with their defaults.
+func addedChanges(doc, patched map[string]interface{}, prefix string) []patchChange {
+	changes := make([]patchChange, 0)
+	for _, key := range sortedKeys(patched) {
+		path := prefix + key
+		value, has := doc[key]
+		if !has {
+			changes = append(changes, patchChange{operator: "$set", path: path})
+			continue
+		}
+		if inner, isDocument := match.AsDocument(value); isDocument {
+			if patchedInner, isPatchedDocument := match.AsDocument(patched[key]); isPatchedDocument {
+				changes = append(changes, addedChanges(inner, patchedInner, path+".")...)
+			}
+			continue
+		}
+		items, isArray := match.AsArray(value)
+		patchedItems, isPatchedArray := match.AsArray(patched[key])
+		if !isArray || !isPatchedArray {
+			continue
+		}
+		for i := 0; i < len(items) && i < len(patchedItems); i++ {
+			inner, isDocument := match.AsDocument(items[i])
+			patchedInner, isPatchedDocument := match.AsDocument(patchedItems[i])
+			if isDocument && isPatchedDocument {
+				changes = append(changes, addedChanges(inner, patchedInner, path+"."+strconv.Itoa(i)+".")...)
+			}
+		}
+	}
+	return changes
+}
+
+type PatchOp string
+
+const (
+	PatchOpAdd     PatchOp = "add"
+	PatchOpRemove  PatchOp = "remove"
+	PatchOpReplace PatchOp = "replace"
+	PatchOpMove    PatchOp = "move"
+	PatchOpCopy    PatchOp = "copy"
+	PatchOpTest    PatchOp = "test"
+)
+
+// PatchOperation is an operation of a JSON Patch. The paths are JSON Pointers
+// (RFC 6901) that can't point to the root of the document.
+// Ex: { "op": "replace", "path": "/lines/0/price", "value": 12 }
+type PatchOperation struct {
+	Op    PatchOp     `bson:"op" json:"op"`
+	Path  string      `bson:"path" json:"path"`
+	From  string      `bson:"from,omitempty" json:"from,omitempty"`
+	Value interface{} `bson:"value" json:"value"`
+}
+
+// JSONPatch is a JSON Patch (RFC 6902), whose operations are applied in order.
+type JSONPatch []PatchOperation
+
+// ParseJSONPatch parses a JSON Patch. It returns a DVNError with status 400 that
+// contains a message for each invalid operation, which starts with its index.
+// Ex: "2:missing-value"
+func ParseJSONPatch(data []byte) (JSONPatch, error) {
+	var operations []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &operations); err != nil {
+		return nil, core.NewError(http.StatusBadRequest, "invalid-json-patch")
+	}
+
+	patch := make(JSONPatch, len(operations))
+	messages := make([]string, 0)
+	for i, operation := range operations {
+		index := strconv.Itoa(i)
+		for _, key := range []string{"op", "path", "from"} {
+			if raw, has := operation[key]; has {
+				var s string
+				if err := json.Unmarshal(raw, &s); err != nil {
+					messages = append(messages, index+":invalid-"+key)
+				}
+				switch key {
+				case "op":
+					patch[i].Op = PatchOp(s)
+				case "path":
+					patch[i].Path = s
+				case "from":
+					patch[i].From = s
+				}
+			}
+		}
+
+		raw, hasValue := operation["value"]
+		switch patch[i].Op {
+		case PatchOpAdd, PatchOpReplace, PatchOpTest:
+			if !hasValue {
+				messages = append(messages, index+":missing-value")
+			} else if err := json.Unmarshal(raw, &patch[i].Value); err != nil {
+				messages = append(messages, index+":invalid-value")
+			}
+		}
+	}
+	messages = append(messages, patch.validate()...)
+	if len(messages) > 0 {
+		return nil, core.NewErrors(http.StatusBadRequest, messages)
+	}
+	return patch, nil
+}
+
+// validate returns the messages of the operations that have invalid ops or paths.
+func (p JSONPatch) validate() []string {
+	messages := make([]string, 0)
+	for i, operation := range p {
+		index := strconv.Itoa(i)
+		switch operation.Op {
+		case PatchOpAdd, PatchOpRemove, PatchOpReplace, PatchOpTest:
+		case PatchOpMove, PatchOpCopy:
+			if _, isValid := pointerToPath(operation.From); !isValid {
+				messages = append(messages, index+":invalid-from:"+operation.From)
+			}
+		default:
+			messages = append(messages, index+":invalid-op:"+string(operation.Op))
+			continue
+		}
+		if _, isValid := pointerToPath(operation.Path); !isValid {
+			messages = append(messages, index+":invalid-path:"+operation.Path)
+		}
+	}
+	return messages
+}
+
+// pointerToPath converts the JSON Pointer into a dotted path. It reports false for
+// the root and for the keys that can't be in the dotted paths of MongoDB.
+func pointerToPath(pointer string) (string, bool) {
+	if !strings.HasPrefix(pointer, "/") {
+		return "", false
+	}
+	parts := strings.Split(pointer[1:], "/")
+	for i, part := range parts {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		if !isValidKey(part) {
+			return "", false
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "."), true
+}
+
+func isValidKey(key string) bool {
+	return key != "" && !strings.Contains(key, ".") && !strings.HasPrefix(key, "$")
+}
+
+// Apply applies the operations to the data model in order. The data model is not
+// changed if an operation fails. It returns a DVNError with status 400 if a path
+// doesn't exist or a reserved key such as _id is changed, and with status 409 if a
+// test operation fails. Ex: "lines.3:not-found", "status:test-failed"
+func (p JSONPatch) Apply(dm DataModel) error {
+	return applyToCopy(p, dm)
+}
+
+func (p JSONPatch) apply(dm DataModel) ([]patchChange, error) {
+	if messages := p.validate(); len(messages) > 0 {
+		return nil, core.NewErrors(http.StatusBadRequest, messages)
+	}
+
+	changes := make([]patchChange, 0, len(p))
+	for _, operation := range p {
+		path, _ := pointerToPath(operation.Path)
+		from, _ := pointerToPath(operation.From)
+
+		var operationChanges []patchChange
+		var err error
+		switch operation.Op {
+		case PatchOpAdd:
+			operationChanges, err = addAtPath(dm, path, copyValue(operation.Value))
+		case PatchOpRemove:
+			operationChanges, err = removeAtPath(dm, path)
+		case PatchOpReplace:
+			operationChanges, err = replaceAtPath(dm, path, copyValue(operation.Value))
+		case PatchOpMove:
+			operationChanges, err = moveAtPath(dm, from, path)
+		case PatchOpCopy:
+			var value interface{}
+			value, err = getAtPath(dm, from)
+			if err == nil {
+				operationChanges, err = addAtPath(dm, path, copyValue(value))
+			}
+		case PatchOpTest:
+			var value interface{}
+			value, err = getAtPath(dm, path)
+			if err == nil && match.New(match.Options{}).Compare(value, operation.Value) != 0 {
+				err = core.NewError(http.StatusConflict, path+":test-failed")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, operationChanges...)
+	}
+	return changes, nil
+}
+
+// MergePatch is a JSON Merge Patch (RFC 7396). The null values delete the keys, the
+// documents are merged into the documents and the other values, including the arrays,
+// replace the values. Ex: { "status": "done", "address": { "zip": null } }
+type MergePatch map[string]interface{}
+
+// ParseMergePatch parses a JSON Merge Patch, which must be a document since the whole
+// data model can't be replaced. It returns a DVNError with status 400 if it's invalid.
+func ParseMergePatch(data []byte) (MergePatch, error) {
+	var patch MergePatch
+	if err := json.Unmarshal(data, &patch); err != nil || patch == nil {
+		return nil, core.NewError(http.StatusBadRequest, "invalid-merge-patch")
+	}
+	return patch, nil
+}
+
+// Apply merges the patch into the data model. The data model is not changed if the
+// patch fails. It returns a DVNError with status 400 if a reserved key such as _id is
+// changed or a key can't be in the dotted paths of MongoDB.
+func (p MergePatch) Apply(dm DataModel) error {
+	return applyToCopy(p, dm)
+}
+
+func (p MergePatch) apply(dm DataModel) ([]patchChange, error) {
+	if messages := validateMergeKeys(p, ""); len(messages) > 0 {
+		return nil, core.NewErrors(http.StatusBadRequest, messages)
+	}
+	return mergeDocument(dm, p, ""), nil
+}
+
+func validateMergeKeys(patch map[string]interface{}, prefix string) []string {
+	messages := make([]string, 0)
+	for _, key := range sortedKeys(patch) {
+		path := prefix + key
+		if !isValidKey(key) {
+			messages = append(messages, path+":invalid-path")
+			continue
+		}
+		if isReservedPath(path) {
+			messages = append(messages, path+":read-only")
+			continue
+		}
+		if doc, isDocument := match.AsDocument(patch[key]); isDocument {
+			messages = append(messages, validateMergeKeys(doc, path+".")...)
+		}
+	}
+	return messages
+}
+
+// mergeDocument merges the patch into the document whose path starts with the
+// prefix.
+func mergeDocument(doc map[string]interface{}, patch map[string]interface{}, prefix string) []patchChange {
+	changes := make([]patchChange, 0)
+	for _, key := range sortedKeys(patch) {
+		path := prefix + key
+		value := patch[key]
+		if value == nil {
+			if _, has := doc[key]; has {
+				delete(doc, key)
+				changes = append(changes, patchChange{operator: "$set", path: path})
+			}
+			continue
+		}
+
+		patchDoc, isPatchDocument := match.AsDocument(value)
+		if !isPatchDocument {
+			doc[key] = copyValue(value)
+			changes = append(changes, patchChange{operator: "$set", path: path})
+			continue
+		}
+		if target, isDocument := WritableDocument(doc[key]); isDocument {
+			changes = append(changes, mergeDocument(target, patchDoc, path+".")...)
+			continue
+		}
+		// the documents replace the other values without their null values
+		target := map[string]interface{}{}
+		mergeDocument(target, patchDoc, "")
+		doc[key] = target
+		changes = append(changes, patchChange{operator: "$set", path: path})
+	}
+	return changes
+}
+
+func sortedKeys(doc map[string]interface{}) []string {
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyToCopy applies the patch to a copy of the data model and replaces the keys of
+// the data model with the keys of the copy if the patch succeeds.
+func applyToCopy(patch Patch, dm DataModel) error {
+	patched := dm.Copy()
+	if _, err := patch.apply(patched); err != nil {
+		return err
+	}
+	for key := range dm {
+		delete(dm, key)
+	}
+	for key, value := range patched {
+		dm[key] = value
+	}
+	return nil
+}
+
+// patchChange is a change of a patch that is compiled into the update document. The
+// $set changes are compiled into $unset if there isn't any value at the path after
+// the patch. The values of the $push changes are taken from the end of the patched
+// array since Validate may convert them.
+type patchChange struct {
+	operator string
+	path     string
+	values   []interface{}
+}
+
+// isReservedPath reports whether the path is in a reserved key such as _id, which
+// can't be patched.
+func isReservedPath(path string) bool {
+	return strings.HasPrefix(path, "_")
+}
+
+// pathError converts the errors of the path accessors of DataModel into DVNErrors.
+func pathError(path string, err error) error {
+	if errors.Is(err, ErrPathNotFound) {
+		return core.NewError(http.StatusBadRequest, path+":not-found")
+	}
+	return core.NewError(http.StatusBadRequest, path+":invalid-path")
+}
+
+func getAtPath(dm DataModel, path string) (interface{}, error) {
+	value, err := dm.Get(path)
+	if err != nil {
+		return nil, pathError(path, err)
+	}
+	return value, nil
+}
+
+// parentAtPath returns the container of the value at the path and the last part of
+// the path.
+func parentAtPath(dm DataModel, path string) (string, interface{}, string, error) {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		return "", dm, path, nil
+	}
+	parent, err := getAtPath(dm, path[:i])
+	return path[:i], parent, path[i+1:], err
+}
+
+// addAtPath adds the value to the document or inserts it into the array. The index
+// "-" appends it to the array.
+func addAtPath(dm DataModel, path string, value interface{}) ([]patchChange, error) {
+	if isReservedPath(path) {
+		return nil, core.NewError(http.StatusBadRequest, path+":read-only")
+	}
+	parentPath, parent, key, err := parentAtPath(dm, path)
+	if err != nil {
+		return nil, err
+	}
+	array, isA, isArray := writableArray(parent)
+	if !isArray {
+		if err := dm.Set(path, value); err != nil {
+			return nil, pathError(path, err)
+		}
+		return []patchChange{{operator: "$set", path: path}}, nil
+	}
+
+	index := len(array)
+	if key != "-" {
+		if index, err = strconv.Atoi(key); err != nil || index < 0 || index > len(array) {
+			return nil, core.NewError(http.StatusBadRequest, path+":not-found")
+		}
+	}
+	inserted := make([]interface{}, 0, len(array)+1)
+	inserted = append(append(append(inserted, array[:index]...), value), array[index:]...)
+	if err := dm.Set(parentPath, arrayOfType(inserted, isA)); err != nil {
+		return nil, pathError(parentPath, err)
+	}
+	if index == len(array) {
+		return []patchChange{{operator: "$push", path: parentPath, values: []interface{}{value}}}, nil
+	}
+	return []patchChange{{operator: "$set", path: parentPath}}, nil
+}
+
+// removeAtPath removes the value from the document or the array. The removed array
+// elements are compiled into $pull if they are unique values in the array, since
+// $pull removes all the elements that are equal to them.
+func removeAtPath(dm DataModel, path string) ([]patchChange, error) {
+	if isReservedPath(path) {
+		return nil, core.NewError(http.StatusBadRequest, path+":read-only")
+	}
+	parentPath, parent, key, err := parentAtPath(dm, path)
+	if err != nil {
+		return nil, err
+	}
+	array, _, isArray := writableArray(parent)
+	if !isArray {
+		if err := dm.Delete(path); err != nil {
+			return nil, pathError(path, err)
+		}
+		return []patchChange{{operator: "$set", path: path}}, nil
+	}
+
+	index, err := strconv.Atoi(key)
+	if err != nil || index < 0 || index >= len(array) {
+		return nil, core.NewError(http.StatusBadRequest, path+":not-found")
+	}
+	removed := array[index]
+	if err := dm.Delete(path); err != nil {
+		return nil, pathError(path, err)
+	}
+	if !isPullable(array, removed) {
+		return []patchChange{{operator: "$set", path: parentPath}}, nil
+	}
+	return []patchChange{{operator: "$pull", path: parentPath, values: []interface{}{removed}}}, nil
+}
+
+// isPullable reports whether pulling the value from the array removes only one
+// element. The documents are not pullable since $pull matches them as queries.
+func isPullable(array []interface{}, value interface{}) bool {
+	if _, isDocument := match.AsDocument(value); isDocument {
+		return false
+	}
+	matcher := match.New(match.Options{})
+	count := 0
+	for _, item := range array {
+		if matcher.Compare(item, value) == 0 {
+			count++
+		}
+	}
+	return count == 1
+}
+
+func replaceAtPath(dm DataModel, path string, value interface{}) ([]patchChange, error) {
+	if isReservedPath(path) {
+		return nil, core.NewError(http.StatusBadRequest, path+":read-only")
+	}
+	if _, err := getAtPath(dm, path); err != nil {
+		return nil, err
+	}
+	if err := dm.Set(path, value); err != nil {
+		return nil, pathError(path, err)
+	}
+	return []patchChange{{operator: "$set", path: path}}, nil
+}
+
+func moveAtPath(dm DataModel, from, path string) ([]patchChange, error) {
+	if from == path {
+		_, err := getAtPath(dm, from)
+		return nil, err
+	}
+	if strings.HasPrefix(path, from+".") {
+		return nil, core.NewError(http.StatusBadRequest, path+":invalid-move:"+from)
+	}
+	value, err := getAtPath(dm, from)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := removeAtPath(dm, from)
+	if err != nil {
+		return nil, err
+	}
+	added, err := addAtPath(dm, path, value)
+	if err != nil {
+		return nil, err
+	}
+	return append(removed, added...), nil
+}
+
+func arrayOfType(array []interface{}, isA bool) interface{} {
+	if isA {
+		return primitive.A(array)
+	}
+	return array
+}
+
+// copyValue returns a deep copy of the documents and the arrays in the value.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case DataModel:
+		copied := make(DataModel, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case primitive.M:
+		copied := make(primitive.M, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case primitive.D:
+		copied := make(primitive.D, len(v))
+		for i, e := range v {
+			copied[i] = primitive.E{Key: e.Key, Value: copyValue(e.Value)}
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	case primitive.A:
+		copied := make(primitive.A, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	}
+	return value
+}
+
+// updateOf compiles the changes into an update document with the values of the
+// patched data model.
+func updateOf(changes []patchChange, patched DataModel) bson.M {
+	merged := make([]patchChange, 0, len(changes))
+	for _, change := range changes {
+		merged = mergeChange(merged, change)
+	}
+
+	update := bson.M{}
+	addOperator := func(operator, path string, value interface{}) {
+		if update[operator] == nil {
+			update[operator] = bson.M{}
+		}
+		update[operator].(bson.M)[path] = value
+	}
+	setPath := func(path string) {
+		value, err := patched.Get(path)
+		if err != nil {
+			addOperator("$unset", path, "")
+		} else {
+			addOperator("$set", path, value)
+		}
+	}
+
+	// the arrays that can't be pushed to are set after the other changes
+	fallbacks := make([]string, 0)
+	for _, change := range merged {
+		switch change.operator {
+		case "$set":
+			setPath(change.path)
+		case "$push":
+			value, err := patched.Get(change.path)
+			array, isArray := match.AsArray(value)
+			if err != nil || !isArray || len(array) < len(change.values) {
+				// the array is stripped or replaced by Validate
+				fallbacks = append(fallbacks, change.path)
+				continue
+			}
+			items := array[len(array)-len(change.values):]
+			addOperator("$push", change.path, bson.M{"$each": bson.A(items)})
+		case "$pull":
+			if len(change.values) == 1 {
+				addOperator("$pull", change.path, change.values[0])
+			} else {
+				addOperator("$pull", change.path, bson.M{"$in": bson.A(change.values)})
+			}
+		}
+	}
+	for _, path := range fallbacks {
+		setPath(path)
+	}
+	return update
+}
+
+// mergeChange adds the change to the changes. The $push and $pull changes of the same
+// array are combined and the other conflicting changes, whose paths are equal or one
+// is in the other, are replaced with a $set of the outer path since MongoDB rejects
+// the update documents that change a path more than once.
+func mergeChange(changes []patchChange, change patchChange) []patchChange {
+	for i, c := range changes {
+		if c.path != change.path && !strings.HasPrefix(c.path, change.path+".") && !strings.HasPrefix(change.path, c.path+".") {
+			continue
+		}
+		if c.path == change.path && c.operator == change.operator {
+			c.values = append(c.values, change.values...)
+			changes[i] = c
+			return changes
+		}
+		path := c.path
+		if len(change.path) < len(path) {
+			path = change.path
+		}
+		remaining := append(changes[:i:i], changes[i+1:]...)
+		return mergeChange(remaining, patchChange{operator: "$set", path: path})
+	}
+	return append(changes, change)
+}
@@ -0,0 +1,176 @@
+package model
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	core "github.com/devingen/api-core"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func patchTestDataModel(t *testing.T) DataModel {
+	var dm DataModel
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"_id": "5f1d7f3e2b7c4a0001a1b2c3",
+		"title": "Order",
+		"tags": ["new", "urgent"],
+		"address": { "city": "Istanbul", "zip": "34000" },
+		"lines": [{ "price": 4 }, { "price": 6 }]
+	}`), &dm))
+	return dm
+}
+
+func patchTestFields() []Field {
+	return []Field{
+		New(FieldTypeText, "title"),
+		New(FieldTypeText, "note"),
+		New(FieldTypeDate, "dueDate"),
+		NewMultiSelect("tags", []SelectOption{{Value: "new"}, {Value: "urgent"}, {Value: "paid"}}).ToField(),
+		NewObject("address", []Field{New(FieldTypeText, "city"), New(FieldTypeText, "zip")}).ToField(),
+		NewArray("lines", NewObject("", []Field{NewNumber("price", "").ToField().SetInt("min", 0)}).ToField()).ToField(),
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	patch, err := ParseJSONPatch([]byte(`[
+		{ "op": "test", "path": "/title", "value": "Order" },
+		{ "op": "replace", "path": "/address/city", "value": "Ankara" },
+		{ "op": "add", "path": "/lines/-", "value": { "price": 8 } },
+		{ "op": "remove", "path": "/tags/0" },
+		{ "op": "remove", "path": "/address/zip" },
+		{ "op": "add", "path": "/dueDate", "value": "2024-05-01T12:00:00Z" },
+		{ "op": "copy", "from": "/title", "path": "/note" }
+	]`))
+	assert.Nil(t, err)
+
+	patched, update, err := PatchDataModel(patchTestDataModel(t), patch, patchTestFields())
+	assert.Nil(t, err)
+	dueDate := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, []interface{}{"urgent"}, patched["tags"])
+	assert.Equal(t, map[string]interface{}{"city": "Ankara"}, patched["address"])
+	assert.Equal(t, bson.M{
+		"$set":   bson.M{"address.city": "Ankara", "dueDate": dueDate, "note": "Order"},
+		"$unset": bson.M{"address.zip": ""},
+		"$push":  bson.M{"lines": bson.M{"$each": bson.A{map[string]interface{}{"price": int64(8)}}}},
+		"$pull":  bson.M{"tags": "new"},
+	}, update)
+
+	// the changes of the same array are replaced with a $set of the array
+	_, update, err = PatchDataModel(patchTestDataModel(t), JSONPatch{
+		{Op: PatchOpAdd, Path: "/tags/-", Value: "paid"},
+		{Op: PatchOpRemove, Path: "/tags/0"},
+	}, patchTestFields())
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$set": bson.M{"tags": []interface{}{"urgent", "paid"}}}, update)
+
+	// the conflicting changes are replaced with a $set of the outer path
+	patch = JSONPatch{
+		{Op: PatchOpAdd, Path: "/lines/0", Value: map[string]interface{}{"price": 1}},
+		{Op: PatchOpReplace, Path: "/lines/2/price", Value: 7},
+		{Op: PatchOpMove, From: "/address/city", Path: "/address/town"},
+	}
+	dm := patchTestDataModel(t)
+	assert.Nil(t, patch.Apply(dm))
+	assert.Equal(t, map[string]interface{}{"town": "Istanbul", "zip": "34000"}, dm["address"])
+	_, update, err = PatchDataModel(patchTestDataModel(t), patch[:2], patchTestFields())
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$set": bson.M{"lines": []interface{}{
+		map[string]interface{}{"price": int64(1)},
+		map[string]interface{}{"price": int64(4)},
+		map[string]interface{}{"price": int64(7)},
+	}}}, update)
+
+	_, err = ParseJSONPatch([]byte(`[{ "op": "add", "path": "/title" }, { "op": "rename", "path": "/a" }, { "op": "move", "path": "/a" }]`))
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{"0:missing-value", "1:invalid-op:rename", "2:invalid-from:"}), err)
+
+	dm = patchTestDataModel(t)
+	err = JSONPatch{
+		{Op: PatchOpRemove, Path: "/title"},
+		{Op: PatchOpTest, Path: "/tags/0", Value: "old"},
+	}.Apply(dm)
+	assert.Equal(t, core.NewError(http.StatusConflict, "tags.0:test-failed"), err)
+	assert.Equal(t, "Order", dm["title"])
+	assert.Equal(t, core.NewError(http.StatusBadRequest, "lines.3:not-found"), JSONPatch{{Op: PatchOpAdd, Path: "/lines/3", Value: 1}}.Apply(dm))
+	assert.Equal(t, core.NewError(http.StatusBadRequest, "_id:read-only"), JSONPatch{{Op: PatchOpRemove, Path: "/_id"}}.Apply(dm))
+
+	_, _, err = PatchDataModel(dm, JSONPatch{{Op: PatchOpReplace, Path: "/lines/1/price", Value: -1}}, patchTestFields())
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{"lines.1.price:min:0"}), err)
+}
+
+func TestJSONPatchStrippedArray(t *testing.T) {
+	dm := patchTestDataModel(t)
+	dm["legacy"] = []interface{}{"a"}
+	patch, err := ParseJSONPatch([]byte(`[
+		{ "op": "add", "path": "/legacy/-", "value": "b" },
+		{ "op": "add", "path": "/lines/-", "value": { "price": 8 } }
+	]`))
+	assert.Nil(t, err)
+
+	patched, update, err := PatchDataModelWithPolicy(dm, patch, patchTestFields(), UnknownFieldsStrip)
+	assert.Nil(t, err)
+	assert.NotContains(t, patched, "legacy")
+	assert.Equal(t, bson.M{
+		"$unset": bson.M{"legacy": ""},
+		"$push":  bson.M{"lines": bson.M{"$each": bson.A{map[string]interface{}{"price": int64(8)}}}},
+	}, update)
+}
+
+func TestPatchDefaults(t *testing.T) {
+	// the document was created before the defaults were added to the fields
+	fields := patchTestFields()
+	fields[1]["default"] = "none"
+	lineFields := NewObject("", []Field{NewNumber("price", "").ToField(), New(FieldTypeText, "currency").SetString("default", "EUR")})
+	fields[5] = NewArray("lines", lineFields.ToField()).ToField()
+
+	_, update, err := PatchDataModel(patchTestDataModel(t), MergePatch{"title": "Invoice"}, fields)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$set": bson.M{
+		"title":            "Invoice",
+		"note":             "none",
+		"lines.0.currency": "EUR",
+		"lines.1.currency": "EUR",
+	}}, update)
+
+	// the defaults of the elements conflict with the $push, so the array is set
+	_, update, err = PatchDataModel(patchTestDataModel(t), JSONPatch{
+		{Op: PatchOpAdd, Path: "/lines/-", Value: map[string]interface{}{"price": 8}},
+	}, fields)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$set": bson.M{"note": "none", "lines": []interface{}{
+		map[string]interface{}{"price": int64(4), "currency": "EUR"},
+		map[string]interface{}{"price": int64(6), "currency": "EUR"},
+		map[string]interface{}{"price": int64(8), "currency": "EUR"},
+	}}}, update)
+}
+
+func TestMergePatch(t *testing.T) {
+	patch, err := ParsePatch("application/merge-patch+json; charset=utf-8", []byte(`{
+		"title": "Invoice",
+		"note": null,
+		"address": { "zip": null, "city": "Ankara" },
+		"tags": ["paid"]
+	}`))
+	assert.Nil(t, err)
+
+	patched, update, err := PatchDataModel(patchTestDataModel(t), patch, patchTestFields())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"city": "Ankara"}, patched["address"])
+	assert.Equal(t, bson.M{
+		"$set":   bson.M{"title": "Invoice", "address.city": "Ankara", "tags": []interface{}{"paid"}},
+		"$unset": bson.M{"address.zip": ""},
+	}, update)
+
+	_, err = ParsePatch("text/plain", []byte(`{}`))
+	assert.Equal(t, core.NewError(http.StatusUnsupportedMediaType, "unsupported-patch-type:text/plain"), err)
+	_, err = ParseMergePatch([]byte(`[1]`))
+	assert.Equal(t, core.NewError(http.StatusBadRequest, "invalid-merge-patch"), err)
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{"_id:read-only", "address.a.b:invalid-path"}),
+		MergePatch{"_id": "x", "address": map[string]interface{}{"a.b": 1}}.Apply(patchTestDataModel(t)))
+
+	_, update, err = PatchDataModel(patchTestDataModel(t), MergePatch{"note": nil}, patchTestFields())
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{}, update)
+}
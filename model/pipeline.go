@@ -0,0 +1,53 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// LookupStages generates the aggregation stages that populate the given fields with
+// the LookupStages of the definitions of their types. Fields that don't require a
+// lookup (text, number etc.) don't generate any stage. The lookups with inner fields
+// or filters use the concise correlated subquery syntax (localField and foreignField
+// with pipeline) that requires MongoDB 5.0. The filters of the lookups are compiled
+// with the time zone and the clock of the config.
+func LookupStages(fields []Field, config *QueryConfig) []bson.M {
+	stages := make([]bson.M, 0)
+	for _, field := range fields {
+		if definition, has := GetFieldTypeDefinition(field.GetType()); has && definition.LookupStages != nil {
+			stages = append(stages, definition.LookupStages(field, config)...)
+		}
+	}
+	return stages
+}
+
+// SortStage converts the sort configs into the value of a $sort stage.
+// The order of the sort configs is preserved.
+func SortStage(sort []SortConfig) bson.D {
+	stage := make(bson.D, len(sort))
+	for i, s := range sort {
+		order := 1
+		if s.Order < 0 {
+			order = -1
+		}
+		stage[i] = bson.E{Key: s.ID, Value: order}
+	}
+	return stage
+}
+
+// subQueryPipeline generates the pipeline of a $lookup stage that populates the
+// inner fields of the looked up documents and filters them. The filter is compiled
+// with the fields of the looked up documents and the rest of the parent config.
+func subQueryPipeline(fields []Field, filter *Filter, config *QueryConfig) []bson.M {
+	pipeline := LookupStages(fields, config)
+	if filter != nil {
+		pipeline = append(pipeline, bson.M{"$match": filter.ToMatchQuery(elemConfig(config, fields))})
+	}
+	return pipeline
+}
+
+// unwindStage converts the array field into a single document. The documents
+// that don't have any item in the array are kept with an empty field.
+func unwindStage(fieldID string) bson.M {
+	return bson.M{"$unwind": bson.M{
+		"path":                       "$" + fieldID,
+		"preserveNullAndEmptyArrays": true,
+	}}
+}
@@ -0,0 +1,159 @@
+package model
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	core "github.com/devingen/api-core"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ProjectionPaths returns the paths of the fields to be projected. The lookup fields
+// that have inner fields are projected by the paths of their inner fields.
+// Ex: [ "name", "owner.email", "owner.name" ]
+func ProjectionPaths(fields []Field) []string {
+	return projectionPaths(fields, "")
+}
+
+func projectionPaths(fields []Field, prefix string) []string {
+	paths := make([]string, 0, len(fields))
+	for _, field := range fields {
+		id := field.GetID()
+		if field.GetType() == FieldTypeCollectionLookup {
+			// the collection lookups that don't have an id keep the documents in the local field
+			id = CollectionLookupFieldFromField(field).GetAs()
+		}
+		path := prefix + id
+		innerFields := field.GetFields()
+		if hasInnerFields(field.GetType()) && field.GetType() != FieldTypeAny && len(innerFields) > 0 {
+			paths = append(paths, projectionPaths(innerFields, path+".")...)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// ProjectStage returns the specification of the $project stage that keeps the paths.
+// The paths that are in another path are dropped since MongoDB doesn't accept the
+// path collisions. Ex: "owner.email" is dropped if "owner" is projected.
+func ProjectStage(paths []string) bson.M {
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+
+	// the ancestors of a path are sorted before it since they are its prefixes
+	stage := bson.M{}
+	for _, path := range sorted {
+		if path != "" && !hasProjectedAncestor(stage, path) {
+			stage[path] = 1
+		}
+	}
+	return stage
+}
+
+// hasProjectedAncestor returns true if the path or a path that contains it is in the
+// stage.
+func hasProjectedAncestor(stage bson.M, path string) bool {
+	for i := range path {
+		if path[i] == '.' {
+			if _, has := stage[path[:i]]; has {
+				return true
+			}
+		}
+	}
+	_, has := stage[path]
+	return has
+}
+
+// SelectFields validates the paths of a sparse fieldset such as the value of the
+// fields query parameter against the fields of the config and sets them to Select.
+// The inner fields of the lookup fields are checked as well if they have fields. It
+// returns a DVNError with status 400 that contains a message for each invalid path.
+// Ex: "fields:unknown-field:owner.phone"
+func (c *QueryConfig) SelectFields(paths []string) error {
+	messages := make([]string, 0)
+	for _, path := range paths {
+		if message := validateSelectPath(c.Fields, path); message != "" {
+			messages = append(messages, "fields:"+message)
+		}
+	}
+	if len(messages) > 0 {
+		return core.NewErrors(http.StatusBadRequest, messages)
+	}
+	c.Select = paths
+	return nil
+}
+
+func validateSelectPath(fields []Field, path string) string {
+	parts := strings.Split(path, ".")
+	for _, part := range parts {
+		if part == "" || strings.HasPrefix(part, "$") {
+			return "invalid-path:" + path
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+
+	for i, part := range parts {
+		if strings.HasPrefix(part, "_") {
+			// the reserved fields such as _id are not in the fields
+			return ""
+		}
+		var field *Field
+		for _, f := range fields {
+			if f.GetID() == part {
+				field = &f
+				break
+			}
+		}
+		if field == nil {
+			return "unknown-field:" + path
+		}
+		if i == len(parts)-1 {
+			return ""
+		}
+		if innerFields, hasInnerFields := elementFields(*field); hasInnerFields {
+			if len(innerFields) == 0 {
+				// the inner fields are not known
+				return ""
+			}
+			fields = innerFields
+			continue
+		}
+		if !hasInnerFields(field.GetType()) {
+			return "unknown-field:" + path
+		}
+		fields = field.GetFields()
+		if len(fields) == 0 || field.GetType() == FieldTypeAny {
+			// the inner fields are not known
+			return ""
+		}
+	}
+	return ""
+}
+
+// projectStage returns the $project stage of the selected fields or all the fields
+// of the config. The sort fields, the text score and the distance are kept as well
+// since the cursors of the pages are created from their values. It returns nil if
+// there isn't any field.
+func (c *QueryConfig) projectStage() bson.M {
+	paths := c.Select
+	if len(paths) == 0 {
+		paths = ProjectionPaths(c.Fields)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	for _, s := range c.Sort {
+		paths = append(paths, s.ID)
+	}
+	if c.Search != nil {
+		paths = append(paths, TextScoreField)
+	}
+	if near, _ := c.Filter.splitNear(); near != nil {
+		paths = append(paths, DistanceField)
+	}
+	return bson.M{"$project": ProjectStage(paths)}
+}
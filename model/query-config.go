@@ -1,18 +1,249 @@
 package model
 
+import (
+	"net/http"
+	"time"
+
+	core "github.com/devingen/api-core"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
 type QueryConfig struct {
 	Filter *Filter      `bson:"filter" json:"filter"`
 	Limit  int          `bson:"limit" json:"limit"`
 	Skip   int          `bson:"skip" json:"skip"`
 	Sort   []SortConfig `bson:"sort" json:"sort"`
 	Fields []Field      `bson:"fields" json:"fields"`
+
+	// After and Before are the signed cursors of the keyset pagination. The documents
+	// that come after or before the cursor in the order of Sort are fetched.
+	After  string `bson:"after,omitempty" json:"after,omitempty"`
+	Before string `bson:"before,omitempty" json:"before,omitempty"`
+
+	// Search is the full-text search of the documents. The relevance scores of the
+	// documents are set to TextScoreField.
+	Search *SearchConfig `bson:"search,omitempty" json:"search,omitempty"`
+
+	// Select is the sparse fieldset of the documents. The fields of the config are
+	// projected if it's empty. Ex: [ "name", "owner.email" ]
+	Select []string `bson:"select,omitempty" json:"select,omitempty"`
+
+	// Location is the time zone that the relative date comparisons such as
+	// date-this-week are computed in. The local time zone is used if it's nil.
+	Location *time.Location `bson:"-" json:"-"`
+
+	// Now is the clock of the relative date comparisons. time.Now is used if it's nil.
+	Now func() time.Time `bson:"-" json:"-"`
+
+	// WeekStart is the first day of the week for the week comparisons such as
+	// date-this-week. Monday is used if it's nil.
+	WeekStart *time.Weekday `bson:"-" json:"-"`
+}
+
+// GetLocation returns the time zone of the date comparisons.
+func (c *QueryConfig) GetLocation() *time.Location {
+	if c == nil || c.Location == nil {
+		return time.Local
+	}
+	return c.Location
+}
+
+// GetNow returns the current time of the config's clock.
+func (c *QueryConfig) GetNow() time.Time {
+	if c == nil || c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// GetWeekStart returns the first day of the week of the date comparisons.
+func (c *QueryConfig) GetWeekStart() time.Weekday {
+	if c == nil || c.WeekStart == nil {
+		return time.Monday
+	}
+	return *c.WeekStart
 }
 
+// GetField returns the field with the id or the inner field at the dotted path in the
+// object and array fields. Ex: "address.city", "lines.price" or "lines.0.price"
 func (c *QueryConfig) GetField(id string) *Field {
+	if c == nil {
+		return nil
+	}
 	for _, field := range c.Fields {
 		if field.GetID() == id {
 			return &field
 		}
 	}
-	return nil
+	return fieldAtPath(c.Fields, id)
+}
+
+// ToPipeline generates the aggregation pipeline that fetches the documents with
+// the fields, filter, sort and pagination of the config. The result can be passed
+// to Database.Aggregate as is. It returns an error if the cursor is invalid.
+//
+// The lookups run before the $match stage so the filter can refer to the inner
+// fields of the looked up documents. Ex: { "id": "organisation.name" }
+//
+// The documents are sorted by _id after the sort configs, so the order is the same
+// on every page. Only the selected fields or the fields of the config are projected
+// at the end of the pipeline. The documents before the Before cursor are fetched in
+// the reverse order and sorted back after they are limited.
+func (c *QueryConfig) ToPipeline() ([]bson.M, error) {
+	matchStages, err := c.matchStages()
+	if err != nil {
+		return nil, err
+	}
+	pageStages, err := c.pageStages()
+	if err != nil {
+		return nil, err
+	}
+	return append(matchStages, pageStages...), nil
+}
+
+// ToListPipeline generates the aggregation pipeline that fetches the page of the
+// documents like ToPipeline and counts all the documents that match the filter in
+// the same query. The pipeline returns a single document that can be decoded into
+// ListResult.
+func (c *QueryConfig) ToListPipeline() ([]bson.M, error) {
+	matchStages, err := c.matchStages()
+	if err != nil {
+		return nil, err
+	}
+	pageStages, err := c.pageStages()
+	if err != nil {
+		return nil, err
+	}
+	if len(pageStages) == 0 {
+		// $facet doesn't accept the empty pipelines
+		pageStages = []bson.M{{"$skip": 0}}
+	}
+	return append(matchStages, bson.M{"$facet": bson.M{
+		"results": pageStages,
+		"meta":    []bson.M{{"$count": "total"}},
+	}}), nil
+}
+
+// matchStages returns the stages that fetch the documents that match the filter. The
+// formula fields are computed after the lookups, so they can refer to the inner
+// fields of the looked up documents and can be filtered and sorted. The near
+// comparison is converted into a $geoNear stage, which must be the first stage like
+// the full-text search, so they can't be used together.
+func (c *QueryConfig) matchStages() ([]bson.M, error) {
+	near, filter := c.Filter.splitNear()
+	if near != nil && c.Search != nil {
+		return nil, core.NewError(http.StatusBadRequest, "near-and-search-together")
+	}
+	pipeline, err := c.searchStages()
+	if err != nil {
+		return nil, err
+	}
+	if near != nil {
+		pipeline = append(pipeline, near.geoNearStage())
+	}
+	formulaStages, err := FormulaStages(c.Fields)
+	if err != nil {
+		return nil, err
+	}
+	pipeline = append(append(pipeline, LookupStages(c.Fields, c)...), formulaStages...)
+	if filter != nil {
+		pipeline = append(pipeline, bson.M{"$match": filter.ToMatchQuery(c)})
+	}
+	return pipeline, nil
+}
+
+// pageStages returns the stages that sort the documents and take the ones in the page.
+func (c *QueryConfig) pageStages() ([]bson.M, error) {
+	pipeline := make([]bson.M, 0)
+	sort := c.Sort
+	if len(sort) > 0 || c.Limit > 0 || c.After != "" || c.Before != "" {
+		// the pages are sorted by the sort of their cursors, so they don't overlap
+		sort = c.pageSort()
+	}
+	if c.After != "" || c.Before != "" {
+		cursorMatch, err := c.cursorQuery(sort)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, bson.M{"$match": cursorMatch})
+	}
+	if c.Before != "" {
+		pipeline = append(pipeline, bson.M{"$sort": SortStage(reverseSort(sort))})
+	} else if len(sort) > 0 {
+		pipeline = append(pipeline, bson.M{"$sort": SortStage(sort)})
+	}
+	if c.Skip > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": c.Skip})
+	}
+	if c.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": c.Limit})
+	}
+	if c.Before != "" {
+		pipeline = append(pipeline, bson.M{"$sort": SortStage(sort)})
+	}
+	if project := c.projectStage(); project != nil {
+		pipeline = append(pipeline, project)
+	}
+	return pipeline, nil
+}
+
+func (c *QueryConfig) cursorQuery(sort []SortConfig) (bson.M, error) {
+	if c.After != "" && c.Before != "" {
+		return nil, core.NewError(http.StatusBadRequest, "after-and-before-cursors-together")
+	}
+	token := c.After
+	if c.Before != "" {
+		token = c.Before
+	}
+	cursor, err := DecodeCursor(sort, token)
+	if err != nil {
+		return nil, err
+	}
+	return cursor.ToMatchQuery(sort, c.Before != ""), nil
+}
+
+// Cursors returns the cursors of the pages that come after and before the results
+// that are fetched with the config. The next cursor is returned if the page is full,
+// so the next page may be empty. The previous cursor is returned if the results are
+// not on the first page.
+func (c *QueryConfig) Cursors(results []*DataModel) (next string, prev string, err error) {
+	if len(results) == 0 {
+		return "", "", nil
+	}
+	sort := c.pageSort()
+	isFull := c.Limit > 0 && len(results) >= c.Limit
+
+	if c.Before != "" || isFull {
+		next, err = EncodeCursor(sort, *results[len(results)-1])
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if c.After != "" || (c.Before != "" && isFull) {
+		prev, err = EncodeCursor(sort, *results[0])
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return next, prev, nil
+}
+
+// pageSort returns the sort configs of the pages and their cursors. The documents are
+// sorted by the distance in the near queries unless there is a sort, and the sort
+// always ends with _id. See cursorSort.
+func (c *QueryConfig) pageSort() []SortConfig {
+	if len(c.Sort) == 0 {
+		if near, _ := c.Filter.splitNear(); near != nil {
+			return cursorSort([]SortConfig{{ID: DistanceField, Order: 1}})
+		}
+	}
+	return cursorSort(c.Sort)
+}
+
+func reverseSort(sort []SortConfig) []SortConfig {
+	reversed := make([]SortConfig, len(sort))
+	for i, s := range sort {
+		reversed[i] = SortConfig{ID: s.ID, Order: -sortDirection(s.Order)}
+	}
+	return reversed
 }
@@ -0,0 +1,332 @@
+package model
+
+import (
+	"net/http"
+
+	core "github.com/devingen/api-core"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestToPipeline(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeText, "name"),
+			NewReference("organisation", "organisations", true).ToField(),
+			NewReverseReference("tasks", "tasks", "owner", false).
+				SetFilter(&Filter{Comparison: ComparisonEq, FieldId: "status", FieldValue: "open"}).
+				SetSort(&SortConfig{ID: "dueDate", Order: -1}).
+				SetLimit(5).
+				ToField(),
+		},
+		Filter: &Filter{Comparison: ComparisonContain, FieldId: "name", FieldValue: "a.b"},
+		Sort:   []SortConfig{{ID: "name", Order: 1}, {ID: "_id", Order: -1}},
+		Skip:   20,
+		Limit:  10,
+	}
+
+	pipeline, err := config.ToPipeline()
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.M{
+		{"$lookup": bson.M{"from": "organisations", "localField": "organisation._id", "foreignField": "_id", "as": "organisation"}},
+		{"$unwind": bson.M{"path": "$organisation", "preserveNullAndEmptyArrays": true}},
+		{"$lookup": bson.M{"from": "tasks", "localField": "_id", "foreignField": "owner._id", "as": "tasks", "pipeline": []bson.M{
+			{"$match": bson.M{"status": bson.M{"$eq": "open"}}},
+			{"$sort": bson.D{{Key: "dueDate", Value: -1}}},
+			{"$limit": 5},
+		}}},
+		{"$match": bson.M{"name": bson.M{"$regex": `a\.b`, "$options": "i"}}},
+		{"$sort": bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: -1}}},
+		{"$skip": 20},
+		{"$limit": 10},
+		{"$project": bson.M{"_id": 1, "name": 1, "organisation": 1, "tasks": 1}},
+	}, pipeline)
+}
+
+func TestToPipelineRelationReference(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			NewRelationReference("members", "memberships", "team", "users", "user").
+				SetRelationFilter(&Filter{Comparison: ComparisonEq, FieldId: "role", FieldValue: "admin"}).
+				ToField(),
+		},
+	}
+
+	pipeline, err := config.ToPipeline()
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.M{
+		{"$lookup": bson.M{"from": "memberships", "localField": "_id", "foreignField": "team._id", "as": "members", "pipeline": []bson.M{
+			{"$lookup": bson.M{"from": "users", "localField": "user._id", "foreignField": "_id", "as": "user"}},
+			{"$unwind": "$user"},
+			{"$match": bson.M{"role": bson.M{"$eq": "admin"}}},
+		}}},
+		{"$project": bson.M{"members": 1}},
+	}, pipeline)
+}
+
+func TestSelectFields(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeText, "name"),
+			New(FieldTypeText, "phone"),
+			NewReference("owner", "users", true).
+				SetFields([]Field{New(FieldTypeText, "name"), New(FieldTypeText, "email")}).
+				ToField(),
+			New(FieldTypeAny, "settings"),
+		},
+	}
+	assert.Equal(t, []string{"name", "phone", "owner.name", "owner.email", "settings"}, ProjectionPaths(config.Fields))
+
+	err := config.SelectFields([]string{"name", "owner.phone", "price", "owner..name", "settings.theme"})
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{
+		"fields:unknown-field:owner.phone",
+		"fields:unknown-field:price",
+		"fields:invalid-path:owner..name",
+	}), err)
+	assert.Nil(t, config.Select)
+
+	assert.Nil(t, config.SelectFields([]string{"owner.email", "name", "owner", "settings.theme"}))
+	pipeline, err := config.ToPipeline()
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$project": bson.M{"name": 1, "owner": 1, "settings.theme": 1}}, pipeline[len(pipeline)-1])
+}
+
+func TestToPipelineRollup(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			NewRollup("orderCount", "orders", "customer", RollupFunctionDistinctCount, "product").
+				SetFilter(&Filter{Comparison: ComparisonEq, FieldId: "status", FieldValue: "open"}).
+				ToField(),
+		},
+		Sort: []SortConfig{{ID: "orderCount", Order: -1}},
+	}
+
+	pipeline, err := config.ToPipeline()
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.M{
+		{"$lookup": bson.M{"from": "orders", "localField": "_id", "foreignField": "customer._id", "as": "orderCount", "pipeline": []bson.M{
+			{"$match": bson.M{"status": bson.M{"$eq": "open"}}},
+			{"$group": bson.M{"_id": nil, "value": bson.M{"$addToSet": "$product"}}},
+			{"$project": bson.M{"value": bson.M{"$size": "$value"}}},
+		}}},
+		{"$addFields": bson.M{"orderCount": bson.M{"$ifNull": []interface{}{bson.M{"$first": "$orderCount.value"}, 0}}}},
+		{"$sort": bson.D{{Key: "orderCount", Value: -1}, {Key: "_id", Value: 1}}},
+		{"$project": bson.M{"orderCount": 1}},
+	}, pipeline)
+
+	// the sum of a missing field is null instead of the invalid path "$"
+	total := NewRollup("total", "orders", "customer", RollupFunctionSum, "").ToField()
+	config = &QueryConfig{Fields: []Field{total}}
+	assert.Equal(t, []bson.M{{"$addFields": bson.M{"total": nil}}}, LookupStages(config.Fields, config))
+	assert.Equal(t,
+		core.NewErrors(http.StatusBadRequest, []string{"filter:rollup-field-required:total"}),
+		Filter{Comparison: ComparisonGt, FieldId: "total", FieldValue: 0}.Validate(config),
+	)
+}
+
+func TestToGroupPipeline(t *testing.T) {
+	sunday := time.Sunday
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeDate, "createdAt"),
+			NewReference("owner", "users", true).SetFields([]Field{New(FieldTypeText, "name")}).ToField(),
+		},
+		Location:  time.UTC,
+		WeekStart: &sunday,
+	}
+
+	pipeline, err := config.ToGroupPipeline(GroupConfig{
+		Keys: []GroupKey{{ID: "owner.name"}, {ID: "createdAt", Bucket: DateBucketWeek}},
+		Aggregates: []GroupAggregate{
+			{ID: "owners", Function: RollupFunctionDistinctCount, FieldId: "owner._id"},
+		},
+		Sort:  []SortConfig{{ID: "owners", Order: -1}},
+		Limit: 10,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.M{
+		{"$lookup": bson.M{"from": "users", "localField": "owner._id", "foreignField": "_id", "as": "owner"}},
+		{"$unwind": bson.M{"path": "$owner", "preserveNullAndEmptyArrays": true}},
+		{"$group": bson.M{
+			"_id": bson.M{
+				"k0": "$owner.name",
+				"k1": bson.M{"$dateTrunc": bson.M{"date": "$createdAt", "unit": "week", "timezone": "UTC", "startOfWeek": "sunday"}},
+			},
+			"count": bson.M{"$sum": 1},
+			"a0":    bson.M{"$addToSet": "$owner._id"},
+		}},
+		{"$sort": bson.D{{Key: "a0", Value: -1}}},
+		{"$limit": 10},
+		{"$project": bson.M{
+			"_id":        0,
+			"key":        bson.M{"owner": bson.M{"name": "$_id.k0"}, "createdAt": "$_id.k1"},
+			"count":      1,
+			"aggregates": bson.M{"owners": bson.M{"$size": "$a0"}},
+		}},
+	}, pipeline)
+
+	_, err = config.ToFacetPipeline(map[string]GroupConfig{
+		"owners": {
+			Keys: []GroupKey{
+				{ID: "owner.phone"}, {ID: "createdAt", Bucket: "year"},
+				{ID: "owner"}, {ID: "owner.name"}, {ID: "owner"},
+			},
+			Aggregates: []GroupAggregate{{ID: "total", Function: RollupFunctionSum}},
+			Sort:       []SortConfig{{ID: "name", Order: 1}},
+		},
+	})
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{
+		"facets:owners:unknown-field:owner.phone",
+		"facets:owners:invalid-bucket:createdAt:year",
+		"facets:owners:overlapping-key:owner.name",
+		"facets:owners:duplicate-key:owner",
+		"facets:owners:field-required:total",
+		"facets:owners:invalid-sort:name",
+	}), err)
+
+	// count is the sort id of the count of the groups
+	_, err = config.ToGroupPipeline(GroupConfig{
+		Keys: []GroupKey{{ID: "count"}, {ID: "createdAt"}},
+		Aggregates: []GroupAggregate{
+			{ID: "count", Function: RollupFunctionCount},
+			{ID: "createdAt", Function: RollupFunctionCount},
+			{ID: "created", Function: RollupFunctionCount},
+		},
+		Sort: []SortConfig{{ID: "count", Order: -1}},
+	})
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{
+		"group:reserved-key:count",
+		"group:invalid-aggregate-id:count",
+		"group:invalid-aggregate-id:createdAt",
+	}), err)
+
+	// the dates are bucketed in the time zone of the date filters
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	local := &QueryConfig{Fields: config.Fields, Now: func() time.Time { return now }}
+	assert.Equal(t,
+		bson.M{"$dateTrunc": bson.M{"date": "$createdAt", "unit": "day", "timezone": timezoneName(time.Local, now)}},
+		GroupKey{ID: "createdAt", Bucket: DateBucketDay}.expression(local),
+	)
+}
+
+func TestToPipelineSearch(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeText, "title"),
+			NewReference("author", "users", true).ToField(),
+		},
+		Search: &SearchConfig{Text: `"green tea" -matcha`, Language: "en", DiacriticSensitive: true},
+		Sort:   []SortConfig{{ID: TextScoreField, Order: -1}},
+		Limit:  10,
+	}
+
+	pipeline, err := config.ToPipeline()
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.M{
+		{"$match": bson.M{"$text": bson.M{"$search": `"green tea" -matcha`, "$language": "en", "$diacriticSensitive": true}}},
+		{"$addFields": bson.M{"_score": bson.M{"$meta": "textScore"}}},
+		{"$lookup": bson.M{"from": "users", "localField": "author._id", "foreignField": "_id", "as": "author"}},
+		{"$unwind": bson.M{"path": "$author", "preserveNullAndEmptyArrays": true}},
+		{"$sort": bson.D{{Key: "_score", Value: -1}, {Key: "_id", Value: 1}}},
+		{"$limit": 10},
+		{"$project": bson.M{"_score": 1, "author": 1, "title": 1}},
+	}, pipeline)
+	assert.Equal(t, bson.D{{Key: "title", Value: "text"}}, TextIndexKeys(config.Fields))
+}
+
+func TestToPipelineNear(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeText, "name"),
+			NewGeo("location", GeoShapePoint).ToField(),
+		},
+		Filter: &Filter{Operator: OperatorAnd, Filters: []Filter{
+			{Comparison: ComparisonNear, FieldId: "location", FieldValue: map[string]interface{}{
+				"point": []interface{}{28.97, 41.0}, "maxDistance": 1000,
+			}},
+			{Comparison: ComparisonWithinRadius, FieldId: "location", FieldValue: map[string]interface{}{
+				"center": []interface{}{29.0, 41.0}, "radius": 6378.1,
+			}},
+		}},
+		Limit: 10,
+	}
+
+	pipeline, err := config.ToPipeline()
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.M{
+		{"$geoNear": bson.M{
+			"near":          bson.M{"type": "Point", "coordinates": bson.A{28.97, 41.0}},
+			"distanceField": "_distance",
+			"key":           "location",
+			"spherical":     true,
+			"maxDistance":   1000.0,
+		}},
+		{"$match": bson.M{"$and": []bson.M{
+			{"location": bson.M{"$geoWithin": bson.M{"$centerSphere": bson.A{bson.A{29.0, 41.0}, 0.001}}}},
+		}}},
+		{"$sort": bson.D{{Key: "_distance", Value: 1}, {Key: "_id", Value: 1}}},
+		{"$limit": 10},
+		{"$project": bson.M{"_distance": 1, "location": 1, "name": 1}},
+	}, pipeline)
+
+	config.Search = &SearchConfig{Text: "tower"}
+	_, err = config.ToPipeline()
+	assert.Equal(t, core.NewError(http.StatusBadRequest, "near-and-search-together"), err)
+}
+
+func TestProjectStage(t *testing.T) {
+	assert.Equal(t, bson.M{"owner": 1, "owner-x": 1}, ProjectStage([]string{"owner.email", "owner-x", "owner", "owner-x.name", "owner"}))
+	assert.Equal(t, bson.M{"owner.email": 1, "owner-x": 1}, ProjectStage([]string{"owner-x", "owner.email", "owner.email.domain"}))
+
+	config := &QueryConfig{
+		Fields: []Field{
+			New(FieldTypeText, "name"),
+			NewCollectionLookup("users", "_id", "owner", true).ToField(),
+		},
+	}
+	pipeline, err := config.ToPipeline()
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$project": bson.M{"name": 1, "owner": 1}}, pipeline[len(pipeline)-1])
+}
+
+func TestToPipelinePageSort(t *testing.T) {
+	// the first page is sorted like the pages of its cursors
+	pipeline, err := (&QueryConfig{Limit: 10}).ToPipeline()
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.M{
+		{"$sort": bson.D{{Key: "_id", Value: 1}}},
+		{"$limit": 10},
+	}, pipeline)
+
+	_, err = (&QueryConfig{Limit: 10, After: "x.y"}).ToPipeline()
+	assert.Equal(t, core.NewError(http.StatusNotImplemented, "cursor-pagination-not-enabled"), err)
+}
+
+func TestToPipelineLookupDates(t *testing.T) {
+	istanbul := time.FixedZone("Istanbul", 3*60*60)
+	config := &QueryConfig{
+		Fields: []Field{
+			NewReverseReference("tasks", "tasks", "owner", false).
+				SetFilter(&Filter{Comparison: ComparisonDateThisMonth, FieldId: "dueDate"}).
+				SetFields([]Field{New(FieldTypeDate, "dueDate")}).
+				ToField(),
+			NewRollup("openTasks", "tasks", "owner", RollupFunctionCount, "").
+				SetFilter(&Filter{Comparison: ComparisonDateThisMonth, FieldId: "dueDate"}).
+				SetFields([]Field{New(FieldTypeDate, "dueDate")}).
+				ToField(),
+		},
+		Location: istanbul,
+		Now:      func() time.Time { return time.Date(2025, 6, 30, 22, 0, 0, 0, time.UTC) },
+	}
+	// it's July in Istanbul
+	dueThisMonth := bson.M{"$match": bson.M{"dueDate": bson.M{
+		"$gte": time.Date(2025, 7, 1, 0, 0, 0, 0, istanbul),
+		"$lte": time.Date(2025, 7, 31, 23, 59, 59, 999999999, istanbul),
+	}}}
+
+	stages := LookupStages(config.Fields, config)
+	assert.Equal(t, dueThisMonth, stages[0]["$lookup"].(bson.M)["pipeline"].([]bson.M)[0])
+	assert.Equal(t, dueThisMonth, stages[1]["$lookup"].(bson.M)["pipeline"].([]bson.M)[0])
+}
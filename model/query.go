@@ -6,7 +6,21 @@ type SortConfig struct {
 }
 
 type BasicQueryConfig struct {
-	Limit int          `bson:"limit" json:"limit"`
-	Skip  int          `bson:"skip" json:"skip"`
-	Sort  []SortConfig `bson:"sort" json:"sort"`
+	Limit  int          `bson:"limit" json:"limit"`
+	Skip   int          `bson:"skip" json:"skip"`
+	Sort   []SortConfig `bson:"sort" json:"sort"`
+	After  string       `bson:"after,omitempty" json:"after,omitempty"`
+	Before string       `bson:"before,omitempty" json:"before,omitempty"`
+}
+
+// ToQueryConfig converts the config into a QueryConfig to generate the pipeline and
+// the cursors of the pages.
+func (c BasicQueryConfig) ToQueryConfig() *QueryConfig {
+	return &QueryConfig{
+		Limit:  c.Limit,
+		Skip:   c.Skip,
+		Sort:   c.Sort,
+		After:  c.After,
+		Before: c.Before,
+	}
 }
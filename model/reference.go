@@ -1,5 +1,7 @@
 package model
 
+import "go.mongodb.org/mongo-driver/bson"
+
 type ReferenceField struct {
 	Field
 }
@@ -51,6 +53,27 @@ func (field ReferenceField) GetFields() []Field {
 	return field.Field.GetFields()
 }
 
+// ToLookupStages generates the stages that replace the DBRef(s) stored in the
+// field with the referenced documents of the other collection. The filter is
+// compiled with the time zone and the clock of the config.
+func (field ReferenceField) ToLookupStages(config *QueryConfig) []bson.M {
+	lookup := bson.M{
+		"from":         field.GetOtherCollection(),
+		"localField":   field.GetID() + "._id",
+		"foreignField": "_id",
+		"as":           field.GetID(),
+	}
+	if pipeline := subQueryPipeline(field.GetFields(), field.GetFilter(), config); len(pipeline) > 0 {
+		lookup["pipeline"] = pipeline
+	}
+
+	stages := []bson.M{{"$lookup": lookup}}
+	if field.IsSingle() {
+		stages = append(stages, unwindStage(field.GetID()))
+	}
+	return stages
+}
+
 func (field ReferenceField) ToField() Field {
 	return field.Field
 }
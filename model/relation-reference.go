@@ -1,5 +1,7 @@
 package model
 
+import "go.mongodb.org/mongo-driver/bson"
+
 type RelationReferenceField struct {
 	Field
 }
@@ -79,6 +81,43 @@ func (field RelationReferenceField) GetOtherCollectionFields() []Field {
 	return field.Field.GetFieldsForKey("otherCollectionFields")
 }
 
+// ToLookupStages generates the stages that populate the field with the documents
+// of the relation collection that refer to this document. The document of the other
+// collection is placed in each relation document under the name it has in the
+// relation collection. The relations whose other document doesn't exist or doesn't
+// match the other collection filter are excluded. The relative dates of the filters
+// are resolved in the time zone of the config.
+func (field RelationReferenceField) ToLookupStages(config *QueryConfig) []bson.M {
+	nameOfOtherCollection := field.GetNameOfOtherCollectionInRelationCollection()
+
+	otherCollectionLookup := bson.M{
+		"from":         field.GetOtherCollection(),
+		"localField":   nameOfOtherCollection + "._id",
+		"foreignField": "_id",
+		"as":           nameOfOtherCollection,
+	}
+	if pipeline := subQueryPipeline(field.GetOtherCollectionFields(), field.GetOtherCollectionFilter(), config); len(pipeline) > 0 {
+		otherCollectionLookup["pipeline"] = pipeline
+	}
+
+	relationPipeline := LookupStages(field.GetFields(), config)
+	relationPipeline = append(relationPipeline,
+		bson.M{"$lookup": otherCollectionLookup},
+		bson.M{"$unwind": "$" + nameOfOtherCollection},
+	)
+	if filter := field.GetRelationFilter(); filter != nil {
+		relationPipeline = append(relationPipeline, bson.M{"$match": filter.ToMatchQuery(elemConfig(config, field.GetFields()))})
+	}
+
+	return []bson.M{{"$lookup": bson.M{
+		"from":         field.GetRelationCollection(),
+		"localField":   "_id",
+		"foreignField": field.GetNameInRelationCollection() + "._id",
+		"as":           field.GetID(),
+		"pipeline":     relationPipeline,
+	}}}
+}
+
 func (field RelationReferenceField) ToField() Field {
 	return field.Field
 }
@@ -1,5 +1,10 @@
 package model
 
+import (
+	"github.com/devingen/api-core/internal/match"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
 type ReverseReferenceField struct {
 	Field
 }
@@ -59,13 +64,14 @@ func (field ReverseReferenceField) GetLimit() int {
 func (field ReverseReferenceField) GetSort() *SortConfig {
 	value, has := field.GetInterface("sort").(*SortConfig)
 	if !has {
-		valueInterface, hasInterface := field.GetInterface("sort").(map[string]interface{})
+		valueInterface, hasInterface := match.AsDocument(field.GetInterface("sort"))
 		if !hasInterface {
 			return nil
 		}
+		sort := Field(valueInterface)
 		return &SortConfig{
-			ID: valueInterface["id"].(string),
-			Order: int(valueInterface["order"].(float64)),
+			ID:    sort.GetString("id"),
+			Order: sort.GetInt("order"),
 		}
 	}
 	return value
@@ -85,6 +91,38 @@ func (field ReverseReferenceField) GetFields() []Field {
 	return field.Field.GetFields()
 }
 
+// ToLookupStages generates the stages that populate the field with the documents
+// of the other collection that refer to this document. The filter uses the config
+// like the filter of the query does.
+func (field ReverseReferenceField) ToLookupStages(config *QueryConfig) []bson.M {
+	pipeline := subQueryPipeline(field.GetFields(), field.GetFilter(), config)
+	if sort := field.GetSort(); sort != nil {
+		pipeline = append(pipeline, bson.M{"$sort": SortStage([]SortConfig{*sort})})
+	}
+	if field.IsSingle() {
+		// unwinding more than one document would duplicate the parent document
+		pipeline = append(pipeline, bson.M{"$limit": 1})
+	} else if limit := field.GetLimit(); limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": limit})
+	}
+
+	lookup := bson.M{
+		"from":         field.GetOtherCollection(),
+		"localField":   "_id",
+		"foreignField": field.GetNameInOtherCollection() + "._id",
+		"as":           field.GetID(),
+	}
+	if len(pipeline) > 0 {
+		lookup["pipeline"] = pipeline
+	}
+
+	stages := []bson.M{{"$lookup": lookup}}
+	if field.IsSingle() {
+		stages = append(stages, unwindStage(field.GetID()))
+	}
+	return stages
+}
+
 func (field ReverseReferenceField) ToField() Field {
 	return field.Field
 }
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type RollupFunction string
+
+const (
+	RollupFunctionCount         RollupFunction = "count"
+	RollupFunctionSum           RollupFunction = "sum"
+	RollupFunctionAvg           RollupFunction = "avg"
+	RollupFunctionMin           RollupFunction = "min"
+	RollupFunctionMax           RollupFunction = "max"
+	RollupFunctionDistinctCount RollupFunction = "distinct-count"
+)
+
+// rollupAccumulators are the $group accumulators of the rollup functions.
+var rollupAccumulators = map[RollupFunction]string{
+	RollupFunctionCount:         "$sum",
+	RollupFunctionSum:           "$sum",
+	RollupFunctionAvg:           "$avg",
+	RollupFunctionMin:           "$min",
+	RollupFunctionMax:           "$max",
+	RollupFunctionDistinctCount: "$addToSet",
+}
+
+// RollupField summarises the documents of the other collection that refer to this
+// document like a ReverseReferenceField with an aggregate function over one of their
+// fields. The value can be filtered and sorted like the other fields.
+// Ex: openOrders is the count of the orders of the customer whose status is open
+type RollupField struct {
+	Field
+}
+
+func RollupFromField(field Field) RollupField {
+	return RollupField{
+		Field: field,
+	}
+}
+
+// NewRollup creates a rollup field that aggregates the field of the other collection's
+// documents with the function. The rolled up field is not used by the count function
+// and it's required by the others.
+func NewRollup(fieldName, otherCollection, nameInOtherCollection string, function RollupFunction, rollupFieldName string) RollupField {
+	field := RollupField{
+		Field: Field{
+			"type":                  FieldTypeRollup,
+			"id":                    fieldName,
+			"otherCollection":       otherCollection,
+			"nameInOtherCollection": nameInOtherCollection,
+			"function":              function,
+		},
+	}
+	if rollupFieldName != "" {
+		field.Field["rollupField"] = rollupFieldName
+	}
+	return field
+}
+
+func (field RollupField) GetID() string {
+	return field.Field.GetID()
+}
+
+func (field RollupField) GetOtherCollection() string {
+	return field.Field.GetString("otherCollection")
+}
+
+func (field RollupField) GetNameInOtherCollection() string {
+	return field.Field.GetString("nameInOtherCollection")
+}
+
+func (field RollupField) GetFunction() RollupFunction {
+	function, has := field.Field["function"].(RollupFunction)
+	if !has {
+		return RollupFunction(field.Field.GetString("function"))
+	}
+	return function
+}
+
+func (field RollupField) GetRollupField() string {
+	return field.Field.GetString("rollupField")
+}
+
+// HasRollupField returns false if the function aggregates a field, which is every
+// function except count, and the rolled up field is not set.
+func (field RollupField) HasRollupField() bool {
+	return field.GetFunction() == RollupFunctionCount || field.GetRollupField() != ""
+}
+
+func (field RollupField) SetFilter(filter *Filter) RollupField {
+	field.SetInterface("filter", filter)
+	return field
+}
+
+func (field RollupField) GetFilter() *Filter {
+	return field.Field.GetFilter("filter")
+}
+
+func (field RollupField) SetFields(fields []Field) RollupField {
+	field.Field.SetFields(fields)
+	return field
+}
+
+func (field RollupField) GetFields() []Field {
+	return field.Field.GetFields()
+}
+
+// GetResultType returns the type of the rolled up value. The min and max of a field
+// have the type of the field if it's one of the inner fields, number otherwise.
+func (field RollupField) GetResultType() FieldType {
+	function := field.GetFunction()
+	if function == RollupFunctionMin || function == RollupFunctionMax {
+		for _, f := range field.GetFields() {
+			if f.GetID() == field.GetRollupField() {
+				return f.GetValueType()
+			}
+		}
+	}
+	return FieldTypeNumber
+}
+
+// ToLookupStages generates the stages that look up the documents of the other
+// collection that refer to this document and set the field to the aggregated value
+// of them. The count, sum and distinct count are 0 and the others are null if there
+// isn't any document. The field is null if the function is unknown or the rolled up
+// field is missing, see HasRollupField. The config is passed to the filter, so its
+// relative dates are the same as the query's.
+func (field RollupField) ToLookupStages(config *QueryConfig) []bson.M {
+	function := field.GetFunction()
+	accumulator, isKnown := rollupAccumulators[function]
+	if !isKnown || !field.HasRollupField() {
+		return []bson.M{{"$addFields": bson.M{field.GetID(): nil}}}
+	}
+
+	var expression interface{} = "$" + field.GetRollupField()
+	if function == RollupFunctionCount {
+		expression = 1
+	}
+	pipeline := subQueryPipeline(field.GetFields(), field.GetFilter(), config)
+	pipeline = append(pipeline, bson.M{"$group": bson.M{"_id": nil, "value": bson.M{accumulator: expression}}})
+	if function == RollupFunctionDistinctCount {
+		pipeline = append(pipeline, bson.M{"$project": bson.M{"value": bson.M{"$size": "$value"}}})
+	}
+
+	var empty interface{}
+	if function == RollupFunctionCount || function == RollupFunctionSum || function == RollupFunctionDistinctCount {
+		empty = 0
+	}
+	return []bson.M{
+		{"$lookup": bson.M{
+			"from":         field.GetOtherCollection(),
+			"localField":   "_id",
+			"foreignField": field.GetNameInOtherCollection() + "._id",
+			"as":           field.GetID(),
+			"pipeline":     pipeline,
+		}},
+		{"$addFields": bson.M{field.GetID(): bson.M{"$ifNull": []interface{}{bson.M{"$first": "$" + field.GetID() + ".value"}, empty}}}},
+	}
+}
+
+func (field RollupField) ToField() Field {
+	return field.Field
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"net/http"
+	"strings"
+
+	core "github.com/devingen/api-core"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TextScoreField is the field that the relevance scores of the full-text search are
+// set to. The results can be sorted by it. Ex: { "id": "_score", "order": -1 }
+const TextScoreField = "_score"
+
+// textSearchLanguages are the languages that the text indexes of MongoDB support.
+var textSearchLanguages = map[string]bool{
+	"none": true, "da": true, "danish": true, "nl": true, "dutch": true, "en": true, "english": true,
+	"fi": true, "finnish": true, "fr": true, "french": true, "de": true, "german": true,
+	"hu": true, "hungarian": true, "it": true, "italian": true, "nb": true, "norwegian": true,
+	"pt": true, "portuguese": true, "ro": true, "romanian": true, "ru": true, "russian": true,
+	"es": true, "spanish": true, "sv": true, "swedish": true, "tr": true, "turkish": true,
+}
+
+// SearchConfig is the full-text search of the documents with the text index of the
+// collection, which can be created with TextIndexKeys. The text consists of the
+// terms, the "quoted phrases" that must be in the documents and the -negated terms
+// that must not be in the documents.
+type SearchConfig struct {
+	Text string `bson:"text" json:"text"`
+
+	// Language determines the stop words and the stemming rules of the search. The
+	// default language of the text index is used if it's empty.
+	Language string `bson:"language,omitempty" json:"language,omitempty"`
+
+	CaseSensitive      bool `bson:"caseSensitive,omitempty" json:"caseSensitive,omitempty"`
+	DiacriticSensitive bool `bson:"diacriticSensitive,omitempty" json:"diacriticSensitive,omitempty"`
+}
+
+// ToMatchQuery generates the $text query of the search.
+// Ex: { "$text": { "$search": "coffee -decaf", "$language": "en" } }
+func (s SearchConfig) ToMatchQuery() bson.M {
+	text := bson.M{"$search": s.Text}
+	if s.Language != "" {
+		text["$language"] = s.Language
+	}
+	if s.CaseSensitive {
+		text["$caseSensitive"] = true
+	}
+	if s.DiacriticSensitive {
+		text["$diacriticSensitive"] = true
+	}
+	return bson.M{"$text": text}
+}
+
+// Validate returns a DVNError with status 400 if the text is empty or the language
+// is not supported. Ex: "search:invalid-language:klingon"
+func (s SearchConfig) Validate() error {
+	messages := make([]string, 0)
+	if strings.TrimSpace(s.Text) == "" {
+		messages = append(messages, "search:text-required")
+	}
+	if s.Language != "" && !textSearchLanguages[strings.ToLower(s.Language)] {
+		messages = append(messages, "search:invalid-language:"+s.Language)
+	}
+	if len(messages) > 0 {
+		return core.NewErrors(http.StatusBadRequest, messages)
+	}
+	return nil
+}
+
+// searchStages returns the stages of the full-text search, which must be the first
+// stages of the pipeline since $text is only allowed in the first $match stage.
+func (c *QueryConfig) searchStages() ([]bson.M, error) {
+	if c.Search == nil {
+		return []bson.M{}, nil
+	}
+	if err := c.Search.Validate(); err != nil {
+		return nil, err
+	}
+	return []bson.M{
+		{"$match": c.Search.ToMatchQuery()},
+		{"$addFields": bson.M{TextScoreField: bson.M{"$meta": "textScore"}}},
+	}, nil
+}
+
+// TextIndexKeys returns the keys of the text index of the text fields. The lookup
+// fields are not indexed since only their _id is stored in the documents.
+// Ex: { "title": "text", "description": "text" }
+func TextIndexKeys(fields []Field) bson.D {
+	keys := bson.D{}
+	for _, field := range fields {
+		if field.GetType() == FieldTypeText {
+			keys = append(keys, bson.E{Key: field.GetID(), Value: "text"})
+		}
+	}
+	return keys
+}
@@ -0,0 +1,160 @@
+package model
+
+import (
+	"net/http"
+
+	core "github.com/devingen/api-core"
+	"github.com/devingen/api-core/internal/match"
+)
+
+// SelectOption is an option of the select fields. The value is stored in the
+// documents and the label and the color are for the dropdowns of the UI.
+// Ex: { "value": "in-progress", "label": "In Progress", "color": "#f5a623" }
+type SelectOption struct {
+	Value string `bson:"value" json:"value"`
+	Label string `bson:"label,omitempty" json:"label,omitempty"`
+	Color string `bson:"color,omitempty" json:"color,omitempty"`
+}
+
+// SelectField stores one of its options, or an array of its options if it's a
+// multi-select field.
+type SelectField struct {
+	Field
+}
+
+func SelectFromField(field Field) SelectField {
+	return SelectField{
+		Field: field,
+	}
+}
+
+func NewSelect(fieldName string, options []SelectOption) SelectField {
+	return SelectField{
+		Field: Field{
+			"type":    FieldTypeSelect,
+			"id":      fieldName,
+			"options": options,
+		},
+	}
+}
+
+func NewMultiSelect(fieldName string, options []SelectOption) SelectField {
+	return SelectField{
+		Field: Field{
+			"type":    FieldTypeMultiSelect,
+			"id":      fieldName,
+			"options": options,
+		},
+	}
+}
+
+func (field SelectField) GetID() string {
+	return field.Field.GetID()
+}
+
+func (field SelectField) IsMulti() bool {
+	return field.GetType() == FieldTypeMultiSelect
+}
+
+// GetOptions returns the options that are either set as []SelectOption or decoded
+// from JSON or BSON as an array of documents.
+func (field SelectField) GetOptions() []SelectOption {
+	if options, has := field.GetInterface("options").([]SelectOption); has {
+		return options
+	}
+	items, isArray := match.AsArray(field.GetInterface("options"))
+	if !isArray {
+		return []SelectOption{}
+	}
+	options := make([]SelectOption, 0, len(items))
+	for _, item := range items {
+		option, isDocument := match.AsDocument(item)
+		if !isDocument {
+			continue
+		}
+		options = append(options, SelectOption{
+			Value: Field(option).GetString("value"),
+			Label: Field(option).GetString("label"),
+			Color: Field(option).GetString("color"),
+		})
+	}
+	return options
+}
+
+// HasOption reports whether the value is the value of an option.
+func (field SelectField) HasOption(value interface{}) bool {
+	s, isString := value.(string)
+	if !isString {
+		return false
+	}
+	for _, option := range field.GetOptions() {
+		if option.Value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidValues returns the values that are not options. The value is an array of
+// values if it's a multi-select field or the comparison is in, nin, all or any.
+func (field SelectField) invalidValues(value interface{}, isArray bool) []string {
+	values := []interface{}{value}
+	if isArray {
+		items, isArrayValue := match.AsArray(value)
+		if !isArrayValue {
+			return []string{stringValue(value)}
+		}
+		values = items
+	}
+	invalid := make([]string, 0)
+	for _, v := range values {
+		if !field.HasOption(v) {
+			invalid = append(invalid, stringValue(v))
+		}
+	}
+	return invalid
+}
+
+// ValidateValue returns a DVNError with status 400 if the value, which is an array
+// for the multi-select fields, is not one of the options. The null values are valid.
+// The messages are the ones of Validate. Ex: "status:invalid-option:archived"
+func (field SelectField) ValidateValue(value interface{}) error {
+	if messages := field.validateValue(value); len(messages) > 0 {
+		return core.NewErrors(http.StatusBadRequest, messages)
+	}
+	return nil
+}
+
+func (field SelectField) validateValue(value interface{}) []string {
+	messages := make([]string, 0)
+	if value == nil {
+		return messages
+	}
+	for _, invalid := range field.invalidValues(value, field.IsMulti()) {
+		messages = append(messages, field.GetID()+":invalid-option:"+invalid)
+	}
+	return messages
+}
+
+// ValidateOptions checks only the values of the select fields of the data model. The
+// documents that are written to the database are checked with Validate, which checks
+// the options as well, so it's for the partial updates such as the $set of a few keys
+// that Validate rejects because of the missing required fields. It returns a
+// DVNError with status 400 that contains a message for each value that is not an
+// option. Ex: "tags:invalid-option:question"
+func ValidateOptions(dm DataModel, fields []Field) error {
+	messages := make([]string, 0)
+	for _, f := range fields {
+		if f.GetType() == FieldTypeSelect || f.GetType() == FieldTypeMultiSelect {
+			messages = append(messages, SelectFromField(f).validateValue(dm[f.GetID()])...)
+		}
+	}
+	if len(messages) > 0 {
+		return core.NewErrors(http.StatusBadRequest, messages)
+	}
+	return nil
+}
+
+func (field SelectField) ToField() Field {
+	return field.Field
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	core "github.com/devingen/api-core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectField(t *testing.T) {
+	var status Field
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"id": "status",
+		"type": "select",
+		"options": [
+			{ "value": "open", "label": "Open", "color": "#4caf50" },
+			{ "value": "closed", "label": "Closed" }
+		]
+	}`), &status))
+	assert.Equal(t, []SelectOption{
+		{Value: "open", Label: "Open", Color: "#4caf50"},
+		{Value: "closed", Label: "Closed"},
+	}, SelectFromField(status).GetOptions())
+
+	tags := NewMultiSelect("tags", []SelectOption{{Value: "bug"}, {Value: "feature"}, {Value: "docs"}}).ToField()
+	fields := []Field{New(FieldTypeText, "title"), status, tags}
+
+	assert.Nil(t, ValidateOptions(DataModel{"title": "Crash", "status": "open", "tags": []interface{}{"bug"}}, fields))
+	assert.Nil(t, ValidateOptions(DataModel{"title": "Crash"}, fields))
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{
+		"status:invalid-option:archived",
+		"tags:invalid-option:question",
+	}), ValidateOptions(DataModel{"status": "archived", "tags": []interface{}{"bug", "question"}}, fields))
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{"tags:invalid-option:bug"}),
+		SelectFromField(tags).ValidateValue("bug"))
+}
+
+func TestFilterSelect(t *testing.T) {
+	config := &QueryConfig{
+		Fields: []Field{
+			NewSelect("status", []SelectOption{{Value: "open"}, {Value: "closed"}}).ToField(),
+			NewMultiSelect("tags", []SelectOption{{Value: "bug"}, {Value: "feature"}, {Value: "docs"}}).ToField(),
+		},
+	}
+	issue := DataModel{"status": "open", "tags": []interface{}{"bug", "docs"}}
+
+	for _, filter := range []Filter{
+		{Comparison: ComparisonEq, FieldId: "status", FieldValue: "open"},
+		{Comparison: ComparisonNin, FieldId: "status", FieldValue: []interface{}{"closed"}},
+		{Comparison: ComparisonEq, FieldId: "tags", FieldValue: "bug"},
+		{Comparison: ComparisonAny, FieldId: "tags", FieldValue: []interface{}{"feature", "docs"}},
+		{Comparison: ComparisonAll, FieldId: "tags", FieldValue: []interface{}{"bug", "docs"}},
+	} {
+		assert.Nil(t, filter.Validate(config), filter)
+		assert.True(t, filter.Matches(issue, config), filter)
+	}
+	for _, filter := range []Filter{
+		{Comparison: ComparisonNe, FieldId: "status", FieldValue: "open"},
+		{Comparison: ComparisonAny, FieldId: "tags", FieldValue: []interface{}{"feature"}},
+		{Comparison: ComparisonAll, FieldId: "tags", FieldValue: []interface{}{"bug", "feature"}},
+	} {
+		assert.False(t, filter.Matches(issue, config), filter)
+	}
+
+	invalid := &Filter{Operator: OperatorAnd, Filters: []Filter{
+		{Comparison: ComparisonEq, FieldId: "status", FieldValue: "archived"},
+		{Comparison: ComparisonIn, FieldId: "status", FieldValue: []interface{}{"open", "pending"}},
+		{Comparison: ComparisonAny, FieldId: "status", FieldValue: []interface{}{"open"}},
+		{Comparison: ComparisonAll, FieldId: "tags", FieldValue: []interface{}{"bug", 5}},
+		{Comparison: ComparisonContain, FieldId: "tags", FieldValue: "bu"},
+	}}
+	assert.Equal(t, core.NewErrors(http.StatusBadRequest, []string{
+		"filter.filters[0]:invalid-option:archived",
+		"filter.filters[1]:invalid-option:pending",
+		"filter.filters[2]:invalid-comparison-for-field-type:any:select",
+		"filter.filters[3]:invalid-option:5",
+		"filter.filters[4]:invalid-comparison-for-field-type:contain:multi-select",
+	}), invalid.Validate(config))
+}
@@ -82,6 +82,25 @@ func (r *Request) GetQueryStringParameter(key string) (string, bool) {
 	return "", false
 }
 
+// GetQueryStringParameterList returns the comma-separated values of the query parameter.
+// The values of the repeated parameters are merged. Ex: fields=name,owner.email
+func (r *Request) GetQueryStringParameterList(key string) []string {
+	values, hasKey := r.QueryStringParameters[key]
+	if !hasKey {
+		values = r.QueryStringParameters[strings.ToLower(key)]
+	}
+
+	list := make([]string, 0)
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
 func (r *Request) AssertQueryStringParameter(key string) (string, error) {
 	value, hasKey := r.QueryStringParameters[key]
 	if !hasKey {